@@ -1,31 +1,101 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"reflect"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo/v4"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
 	"github.com/Kale-Grabovski/gonah/cmd/middleware"
 	"github.com/Kale-Grabovski/gonah/src/api"
 	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
 )
 
 type CustomValidator struct {
 	validator *validator.Validate
 }
 
+func NewCustomValidator() *CustomValidator {
+	v := validator.New()
+	// Errors name the fields as clients know them
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return &CustomValidator{v}
+}
+
 func (cv *CustomValidator) Validate(i interface{}) error {
-	if err := cv.validator.Struct(i); err != nil {
-		// Optionally, you could return the error to give each route more control over the status code
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	err := cv.validator.Struct(i)
+	var fields validator.ValidationErrors
+	if !errors.As(err, &fields) {
+		return err
+	}
+
+	ve := &domain.ValidationError{}
+	for _, f := range fields {
+		// The namespace starts with the struct name
+		name := f.Namespace()
+		if i := strings.Index(name, "."); i >= 0 {
+			name = name[i+1:]
+		}
+		ve.Fields = append(ve.Fields, domain.FieldError{Field: name, Message: validationMessage(f)})
+	}
+	return ve
+}
+
+func validationMessage(f validator.FieldError) string {
+	switch f.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email"
+	case "oneof":
+		return "must be one of: " + f.Param()
+	}
+	return "must satisfy " + f.Tag()
+}
+
+// ipExtractor takes the client IP from the connection, or from X-Forwarded-For set by the trusted proxies.
+// The headers of the clients are never trusted, so they can't dodge the rate limits and lockouts by IP.
+func ipExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	opts := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", proxy, err)
+		}
+		opts = append(opts, echo.TrustIPRange(ipNet))
 	}
-	return nil
+	return echo.ExtractIPFromXFFHeader(opts...), nil
 }
 
 var apiCmd = &cobra.Command{
@@ -41,28 +111,162 @@ func init() {
 
 func runApi() {
 	e := echo.New()
-	e.Validator = &CustomValidator{validator: validator.New()}
+	e.Validator = NewCustomValidator()
+
+	cfg := diContainer.Get("config").(*domain.Config)
+	logger := diContainer.Get("logger").(domain.Logger)
+	ipExtract, err := ipExtractor(cfg.TrustedProxies)
+	if err != nil {
+		panic("cannot build IP extractor: " + err.Error())
+	}
+	e.IPExtractor = ipExtract
+
+	// Before routing, so that unknown routes get a request ID too
+	e.Pre(middleware.NewRequestID(logger).Process)
+	e.Use(middleware.NewAccessLog(cfg, logger).Process)
+	e.Use(middleware.NewVictoria(metrics.GetDefaultSet()).Process)
+	e.HTTPErrorHandler = api.NewErrorHandler(logger).Handle
+	openAPI := diContainer.Get("api.openapi").(*api.OpenAPIAction)
+	validation, err := middleware.NewOpenAPI(openAPI.Doc(), cfg.OpenAPI.ValidateResponses, logger)
+	if err != nil {
+		panic("cannot build OpenAPI router: " + err.Error())
+	}
+	rateLimit, err := middleware.NewRateLimit(cfg, diContainer.Get("service.ratelimit").(domain.RateLimitStore), logger)
+	if err != nil {
+		panic("cannot build rate limits: " + err.Error())
+	}
+	rateLimit = rateLimit.ErrorsFor("/scim/", api.SCIMError)
+	e.Use(rateLimit.Process)
+	e.Use(validation.ErrorsFor("/scim/", api.SCIMError).Process)
+	e.GET("/openapi.json", openAPI.Spec)
+	e.GET("/docs", openAPI.Docs)
+	e.GET("/docs/:asset", openAPI.DocsAsset)
 
-	e.Use((&middleware.Victoria{}).Process)
 	e.GET("/metrics", func(c echo.Context) (err error) {
 		metrics.WritePrometheus(c.Response(), true)
 		return nil
 	})
 
+	authn := middleware.NewAuth(
+		diContainer.Get("service.token").(*service.Token),
+		diContainer.Get("repo.apikey").(*repo.ApiKeyRepo),
+		diContainer.Get("repo.session").(*repo.SessionRepo),
+		logger,
+	)
+	limitClients := rateLimit.ForClients().Process
+	authenticated := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return authn.Process(limitClients(next))
+	}
+	// Opted in by the routes creating resources, the responses are stored
+	idempotency := middleware.NewIdempotency(cfg, diContainer.Get("repo.idempotency").(*repo.IdempotencyRepo), logger)
+	idempotent := idempotency.Process
+	rbac := middleware.NewRBAC(
+		diContainer.Get("repo.role").(*repo.RoleRepo),
+		diContainer.Get("repo.audit").(*repo.AuditRepo),
+		logger,
+	)
+
+	health := diContainer.Get("api.health").(*api.HealthAction)
+	e.GET("/livez", health.Livez)
+	e.GET("/readyz", health.Readyz)
+
 	users := diContainer.Get("api.users").(*api.UsersAction)
 	e.GET("/up", users.Up)
-	e.GET("/api/v1/users", users.GetAll)
-	e.GET("/api/v1/users/:id", users.GetById)
-	e.POST("/api/v1/users", users.Create)
-	e.DELETE("/api/v1/users/:id", users.Delete)
+	e.GET("/api/v1/users", users.GetAll, authenticated, rbac.Require(domain.PermUsersRead))
+	e.GET("/api/v1/users/:id", users.GetById, authenticated, rbac.Require(domain.PermUsersRead))
+	e.POST("/api/v1/users", users.Create, idempotent)
+	e.POST("/api/v1/users/:id/verify", users.Verify)
+	e.POST("/api/v1/users/:id/verify/resend", users.ResendVerification)
+	e.DELETE("/api/v1/users/:id", users.Delete, authenticated, rbac.Require(domain.PermUsersDelete))
+	e.DELETE("/api/v1/users/:id/lockout", users.Unlock, authenticated, rbac.Require(domain.PermUsersUnlock))
+
+	compliance := diContainer.Get("api.compliance").(*api.ComplianceAction)
+	e.GET("/api/v1/users/:id/export", compliance.Export, authenticated, rbac.Require(domain.PermUsersExport))
+	e.POST("/api/v1/users/:id/erasure", compliance.Erase, authenticated, rbac.Require(domain.PermUsersErase))
+
+	roles := diContainer.Get("api.roles").(*api.RolesAction)
+	e.GET("/api/v1/roles", roles.GetAll, authenticated, rbac.Require(domain.PermRolesRead))
+	e.GET("/api/v1/users/:id/roles", roles.GetByUser, authenticated, rbac.Require(domain.PermRolesRead))
+	e.PUT("/api/v1/users/:id/roles/:role", roles.Grant, authenticated, rbac.Require(domain.PermRolesManage))
+	e.DELETE("/api/v1/users/:id/roles/:role", roles.Revoke, authenticated, rbac.Require(domain.PermRolesManage))
+
+	// Users create groups and own them, owners manage their groups, so the other writes check permissions in the handlers
+	groups := diContainer.Get("api.groups").(*api.GroupsAction)
+	e.GET("/api/v1/groups", groups.GetAll, authenticated, rbac.Require(domain.PermGroupsRead))
+	e.GET("/api/v1/groups/:id", groups.GetById, authenticated, rbac.Require(domain.PermGroupsRead))
+	e.POST("/api/v1/groups", groups.Create, authenticated, rbac.Require(domain.PermGroupsCreate), idempotent)
+	e.PUT("/api/v1/groups/:id", groups.Update, authenticated)
+	e.DELETE("/api/v1/groups/:id", groups.Delete, authenticated)
+	e.GET("/api/v1/groups/:id/members", groups.GetMembers, authenticated, rbac.Require(domain.PermGroupsRead))
+	e.PUT("/api/v1/groups/:id/members/:uid", groups.SetMember, authenticated)
+	e.DELETE("/api/v1/groups/:id/members/:uid", groups.RemoveMember, authenticated)
+
+	invitations := diContainer.Get("api.invitations").(*api.InvitationsAction)
+	e.GET("/api/v1/groups/:id/invitations", invitations.GetAll, authenticated)
+	e.POST("/api/v1/groups/:id/invitations", invitations.Create, authenticated, idempotent)
+	e.DELETE("/api/v1/groups/:id/invitations/:iid", invitations.Revoke, authenticated)
+	e.POST("/api/v1/invitations/accept", invitations.Accept)
+
+	sessions := diContainer.Get("api.sessions").(*api.SessionsAction)
+	e.GET("/api/v1/sessions", sessions.GetAll, authenticated)
+	e.DELETE("/api/v1/sessions", sessions.RevokeAll, authenticated)
+	e.DELETE("/api/v1/sessions/:sid", sessions.Revoke, authenticated)
+	e.GET("/api/v1/users/:id/sessions", sessions.GetByUser, authenticated, rbac.Require(domain.PermSessionsManage))
+	e.DELETE("/api/v1/users/:id/sessions", sessions.RevokeAllByUser, authenticated, rbac.Require(domain.PermSessionsManage))
+	e.DELETE("/api/v1/users/:id/sessions/:sid", sessions.RevokeByUser, authenticated, rbac.Require(domain.PermSessionsManage))
+
+	audit := diContainer.Get("api.audit").(*api.AuditAction)
+	e.GET("/api/v1/audit", audit.GetAll, authenticated, rbac.Require(domain.PermAuditRead))
+
+	auth := diContainer.Get("api.auth").(*api.AuthAction)
+	e.GET("/.well-known/jwks.json", auth.JWKS)
+	e.POST("/api/v1/auth/login", auth.Login)
+	e.POST("/api/v1/auth/login/mfa", auth.LoginMFA)
+	e.POST("/api/v1/auth/refresh", auth.Refresh)
+	e.POST("/api/v1/auth/logout", auth.Logout)
+	e.POST("/api/v1/auth/password-reset", auth.RequestPasswordReset)
+	e.POST("/api/v1/auth/password-reset/confirm", auth.ConfirmPasswordReset)
+
+	oidc := diContainer.Get("api.oidc").(*api.OIDCAction)
+	e.GET("/api/v1/auth/oidc/login", oidc.Login)
+	e.GET("/api/v1/auth/oidc/callback", oidc.Callback)
+
+	totp := diContainer.Get("api.totp").(*api.TotpAction)
+	e.POST("/api/v1/auth/2fa/enroll", totp.Enroll, authenticated)
+	e.POST("/api/v1/auth/2fa/verify", totp.Verify, authenticated)
+	e.POST("/api/v1/auth/2fa/disable", totp.Disable, authenticated)
+
+	apiKeys := diContainer.Get("api.apikeys").(*api.ApiKeysAction)
+	e.GET("/api/v1/api-keys", apiKeys.GetAll, authenticated)
+	e.POST("/api/v1/api-keys", apiKeys.Create, authenticated)
+	e.DELETE("/api/v1/api-keys/:id", apiKeys.Revoke, authenticated)
+
+	// The IdP provisions with an API key having the scim:provision scope
+	scim := diContainer.Get("api.scim").(*api.SCIMAction)
+	scimAuth := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return authn.WithErrors(api.SCIMError).Process(limitClients(next))
+	}
+	scimAllowed := rbac.WithErrors(api.SCIMError).Require(domain.PermSCIMProvision)
+	scimIdempotent := idempotency.WithErrors(api.SCIMError).Process
+	e.GET("/scim/v2/ServiceProviderConfig", scim.ServiceProviderConfig, scimAuth, scimAllowed)
+	e.GET("/scim/v2/Users", scim.GetUsers, scimAuth, scimAllowed)
+	e.POST("/scim/v2/Users", scim.CreateUser, scimAuth, scimAllowed, scimIdempotent)
+	e.GET("/scim/v2/Users/:id", scim.GetUser, scimAuth, scimAllowed)
+	e.PUT("/scim/v2/Users/:id", scim.ReplaceUser, scimAuth, scimAllowed)
+	e.PATCH("/scim/v2/Users/:id", scim.PatchUser, scimAuth, scimAllowed)
+	e.DELETE("/scim/v2/Users/:id", scim.DeleteUser, scimAuth, scimAllowed)
+	e.GET("/scim/v2/Groups", scim.GetGroups, scimAuth, scimAllowed)
+	e.POST("/scim/v2/Groups", scim.CreateGroup, scimAuth, scimAllowed, scimIdempotent)
+	e.GET("/scim/v2/Groups/:id", scim.GetGroup, scimAuth, scimAllowed)
+	e.PUT("/scim/v2/Groups/:id", scim.ReplaceGroup, scimAuth, scimAllowed)
+	e.PATCH("/scim/v2/Groups/:id", scim.PatchGroup, scimAuth, scimAllowed)
+	e.DELETE("/scim/v2/Groups/:id", scim.DeleteGroup, scimAuth, scimAllowed)
 
-	logger := diContainer.Get("logger").(domain.Logger)
 	logger.Info("API is starting")
 
 	go func() {
-		cfg := diContainer.Get("config").(*domain.Config)
 		err := e.Start(":" + cfg.ApiPort)
-		if err != nil {
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			panic(err)
 		}
 	}()
@@ -71,6 +275,33 @@ func runApi() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
+	shutdownApi(e, cfg, logger)
 	diContainer.DeleteWithSubContainers()
 	logger.Info("API stopped")
 }
+
+// shutdownApi stops taking requests, waits for the ones in flight, their background work
+// and then for the Kafka deliveries, the DB is closed with the DI container after it.
+func shutdownApi(e *echo.Echo, cfg *domain.Config, logger domain.Logger) {
+	logger.Info("API is shutting down")
+	lifecycle := diContainer.Get("service.lifecycle").(*service.Lifecycle)
+	lifecycle.Stop()
+	time.Sleep(cfg.Shutdown.NotReadyDelay)
+
+	timeout := cfg.Shutdown.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		logger.Error("requests in flight cut off", zap.Error(err))
+	}
+	if err := lifecycle.Wait(ctx); err != nil {
+		logger.Error("background work of the requests cut off", zap.Error(err))
+	}
+	if err := diContainer.Get("service.kafka").(*service.Kafka).Close(ctx); err != nil {
+		logger.Error("cannot close Kafka", zap.Error(err))
+	}
+}
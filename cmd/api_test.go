@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/api"
+)
+
+var echoParam = regexp.MustCompile(`:(\w+)`)
+
+// TestRoutesInOpenAPI fails on the routes of runApi missing from the OpenAPI document.
+func TestRoutesInOpenAPI(t *testing.T) {
+	doc, err := api.LoadOpenAPI()
+	require.NoError(t, err)
+
+	routes := registeredRoutes(t)
+	require.NotEmpty(t, routes)
+	for _, r := range routes {
+		path := echoParam.ReplaceAllString(r.path, "{$1}")
+		item := doc.Paths.Value(path)
+		require.NotNil(t, item, "%s is missing from the OpenAPI document", path)
+		require.NotNil(t, item.GetOperation(r.method), "%s %s is missing from the OpenAPI document", r.method, path)
+	}
+}
+
+type route struct {
+	method, path string
+}
+
+// registeredRoutes reads the routes from the source, building them for real needs the DI container.
+func registeredRoutes(t *testing.T) (ret []route) {
+	f, err := parser.ParseFile(token.NewFileSet(), "api.go", nil, 0)
+	require.NoError(t, err)
+
+	methods := map[string]bool{
+		http.MethodGet: true, http.MethodPost: true, http.MethodPut: true, http.MethodPatch: true, http.MethodDelete: true,
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || len(call.Args) == 0 {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !methods[sel.Sel.Name] {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); !ok || x.Name != "e" {
+			return true
+		}
+		lit, ok := call.Args[0].(*ast.BasicLit)
+		require.True(t, ok && lit.Kind == token.STRING, "route path of e.%s isn't a literal", sel.Sel.Name)
+		path, err := strconv.Unquote(lit.Value)
+		require.NoError(t, err)
+		ret = append(ret, route{sel.Sel.Name, path})
+		return true
+	})
+	return
+}
+
+func TestIpExtractor(t *testing.T) {
+	request := func(remote, xff string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, "/", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = remote + ":4242"
+		req.Header.Set(echo.HeaderXForwardedFor, xff)
+		req.Header.Set(echo.HeaderXRealIP, "6.6.6.6")
+		return req
+	}
+
+	// the headers of the clients are ignored without proxies
+	extract, err := ipExtractor(nil)
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.2", extract(request("10.0.0.2", "6.6.6.6")))
+
+	extract, err = ipExtractor([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+	require.Equal(t, "203.0.113.7", extract(request("10.0.0.2", "6.6.6.6, 203.0.113.7, 192.168.1.1")))
+	require.Equal(t, "172.16.0.9", extract(request("172.16.0.9", "6.6.6.6")), "private networks aren't trusted unless listed")
+
+	_, err = ipExtractor([]string{"not an ip"})
+	require.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const redacted = "REDACTED"
+
+// AccessLog logs a JSON line per request with the "access" logger. The requests to the excluded routes
+// aren't logged, of the others the failed ones are always logged and the rest are sampled.
+type AccessLog struct {
+	enabled    bool
+	sampleRate float64
+	exclude    map[string]bool
+	headers    []string
+	redact     map[string]bool // lower case
+	sample     func() float64
+	logger     domain.Logger
+}
+
+func NewAccessLog(cfg *domain.Config, logger domain.Logger) *AccessLog {
+	c := cfg.AccessLog
+	s := &AccessLog{
+		enabled:    c.Enabled,
+		sampleRate: 1,
+		exclude:    map[string]bool{},
+		headers:    c.Headers,
+		redact:     map[string]bool{},
+		sample:     rand.Float64,
+		logger:     logger.Named("access"),
+	}
+	if c.SampleRate != nil {
+		s.sampleRate = *c.SampleRate // 0 logs the failed requests only
+	}
+	for _, path := range c.ExcludePaths {
+		s.exclude[path] = true
+	}
+	for _, name := range c.Redact {
+		s.redact[strings.ToLower(name)] = true
+	}
+	return s
+}
+
+func (s *AccessLog) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	if !s.enabled {
+		return next
+	}
+	return func(c echo.Context) error {
+		start := time.Now()
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		latency := time.Since(start)
+
+		res := c.Response()
+		if s.exclude[c.Path()] || res.Status < http.StatusBadRequest && s.sample() >= s.sampleRate {
+			return nil
+		}
+
+		req := c.Request()
+		fields := []zapcore.Field{
+			zap.String("method", req.Method),
+			zap.String("route", c.Path()),
+			zap.Int("status", res.Status),
+			zap.Duration("latency", latency),
+			zap.Int64("bytes_in", req.ContentLength),
+			zap.Int64("bytes_out", res.Size),
+			zap.String("ip", c.RealIP()),
+			zap.String("request_id", domain.RequestId(req.Context())),
+		}
+		if req.URL.RawQuery != "" {
+			fields = append(fields, zap.String("query", s.query(req.URL.Query())))
+		}
+		if p, ok := c.Get(domain.PrincipalKey).(*domain.Principal); ok {
+			fields = append(fields, zap.Int("user_id", p.UserId))
+		}
+		for _, name := range s.headers {
+			if v := req.Header.Get(name); v != "" {
+				fields = append(fields, zap.String("header."+strings.ToLower(name), s.value(name, v)))
+			}
+		}
+
+		if res.Status >= http.StatusInternalServerError {
+			s.logger.Warn("request", fields...)
+		} else {
+			s.logger.Info("request", fields...)
+		}
+		return nil
+	}
+}
+
+func (s *AccessLog) query(q url.Values) string {
+	for name, values := range q {
+		if s.redact[strings.ToLower(name)] {
+			for i := range values {
+				values[i] = redacted
+			}
+		}
+	}
+	return q.Encode()
+}
+
+func (s *AccessLog) value(name, v string) string {
+	if s.redact[strings.ToLower(name)] {
+		return redacted
+	}
+	return v
+}
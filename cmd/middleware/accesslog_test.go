@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestAccessLog(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	cfg := &domain.Config{}
+	cfg.AccessLog.Enabled = true
+	rate := 0.5
+	cfg.AccessLog.SampleRate = &rate
+	cfg.AccessLog.ExcludePaths = []string{"/up"}
+	cfg.AccessLog.Headers = []string{"User-Agent", "Authorization"}
+	cfg.AccessLog.Redact = []string{"authorization", "Token"}
+	accessLog := NewAccessLog(cfg, zap.New(core))
+	sampled := 0.9
+	accessLog.sample = func() float64 { return sampled }
+
+	e := echo.New()
+	e.Pre(NewRequestID(zap.New(core)).Process)
+	e.Use(accessLog.Process)
+	e.GET("/up", func(c echo.Context) error { return c.String(http.StatusOK, "up") })
+	e.GET("/users/:id", func(c echo.Context) error {
+		c.Set(domain.PrincipalKey, &domain.Principal{UserId: 7})
+		if c.Param("id") == "0" {
+			return echo.ErrNotFound
+		}
+		return c.String(http.StatusOK, "Olivia")
+	})
+	serve := func(path string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "test")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set(domain.RequestIdHeader, "req-1")
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("/up")
+	serve("/users/1")
+	require.Zero(t, logs.Len(), "excluded and not sampled requests are logged")
+
+	serve("/users/0?token=secret&page=2")
+	require.Equal(t, 1, logs.Len(), "failed requests aren't logged")
+	entry := logs.TakeAll()[0]
+	require.Equal(t, "access", entry.LoggerName)
+	require.Equal(t, map[string]interface{}{
+		"method":               http.MethodGet,
+		"route":                "/users/:id",
+		"query":                "page=2&token=REDACTED",
+		"status":               int64(http.StatusNotFound),
+		"latency":              entry.ContextMap()["latency"],
+		"bytes_in":             int64(0),
+		"bytes_out":            entry.ContextMap()["bytes_out"],
+		"ip":                   "192.0.2.1",
+		"request_id":           "req-1",
+		"user_id":              int64(7),
+		"header.user-agent":    "test",
+		"header.authorization": "REDACTED",
+	}, entry.ContextMap())
+
+	sampled = 0.1
+	serve("/users/1")
+	require.Equal(t, 1, logs.Len(), "sampled requests aren't logged")
+
+	// sampleRate 0 logs the failed requests only, 1 is the default
+	rate = 0
+	require.Zero(t, NewAccessLog(cfg, zap.NewNop()).sampleRate)
+	cfg.AccessLog.SampleRate = nil
+	require.Equal(t, 1.0, NewAccessLog(cfg, zap.NewNop()).sampleRate)
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+// Auth rejects requests without a valid bearer access token or API key
+// and puts the authenticated domain.Principal into the context.
+// API keys are accepted both as bearer tokens and in the X-API-Key header.
+// Access tokens of revoked sessions are rejected even though they haven't expired yet.
+type Auth struct {
+	token       *service.Token
+	apiKeyRepo  *repo.ApiKeyRepo
+	sessionRepo *repo.SessionRepo
+	fail        ErrorResponder
+	logger      domain.Logger
+}
+
+// ErrorResponder writes the rejection of the request, routes with their own error format replace the plain text one.
+type ErrorResponder func(c echo.Context, status int, msg string) error
+
+func NewAuth(token *service.Token, apiKeyRepo *repo.ApiKeyRepo, sessionRepo *repo.SessionRepo, logger domain.Logger) *Auth {
+	return &Auth{token, apiKeyRepo, sessionRepo, httpError, logger}
+}
+
+// WithErrors returns a copy of the middleware which rejects requests with fail.
+func (s *Auth) WithErrors(fail ErrorResponder) *Auth {
+	a := *s
+	a.fail = fail
+	return &a
+}
+
+func (s *Auth) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		credential, ok := bearerToken(c.Request())
+		if key := c.Request().Header.Get(domain.ApiKeyHeader); key != "" {
+			credential, ok = key, true
+		}
+		if !ok {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="gonah"`)
+			return s.fail(c, http.StatusUnauthorized, "authorization required")
+		}
+
+		var (
+			principal *domain.Principal
+			err       error
+		)
+		logger := domain.LoggerFrom(c.Request().Context(), s.logger)
+		if service.IsApiKey(credential) {
+			principal, err = s.apiKey(credential, logger)
+		} else {
+			principal, err = s.access(credential, logger)
+		}
+		if err != nil {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="gonah", error="invalid_token"`)
+			return s.fail(c, http.StatusUnauthorized, "invalid credentials")
+		}
+		c.Set(domain.PrincipalKey, principal)
+		return next(c)
+	}
+}
+
+func (s *Auth) access(token string, logger domain.Logger) (*domain.Principal, error) {
+	p, err := s.token.ParseAccess(token)
+	if err != nil || p.SessionId == 0 {
+		return p, err
+	}
+
+	active, err := s.sessionRepo.Active(p.SessionId)
+	if err != nil {
+		logger.Error("cannot check session", zap.Error(err))
+		return nil, err
+	}
+	if !active {
+		return nil, service.ErrInvalidToken
+	}
+	if err = s.sessionRepo.Touch(p.SessionId); err != nil {
+		logger.Warn("cannot update session last seen time", zap.Error(err))
+	}
+	return p, nil
+}
+
+func (s *Auth) apiKey(key string, logger domain.Logger) (*domain.Principal, error) {
+	prefix, ok := service.ParseApiKey(key)
+	if !ok {
+		return nil, service.ErrInvalidToken
+	}
+	k, err := s.apiKeyRepo.GetByPrefix(prefix)
+	if err != nil {
+		if !errors.Is(err, domain.ErrNoRows) {
+			logger.Error("cannot get API key", zap.Error(err))
+		}
+		return nil, service.ErrInvalidToken
+	}
+	if subtle.ConstantTimeCompare([]byte(k.Hash), []byte(service.HashToken(key))) != 1 {
+		return nil, service.ErrInvalidToken
+	}
+	if k.ExpiresAt != nil && k.ExpiresAt.Before(time.Now()) {
+		return nil, service.ErrInvalidToken
+	}
+
+	if err = s.apiKeyRepo.Touch(k.Id); err != nil {
+		logger.Warn("cannot update API key usage time", zap.Error(err))
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`gonah_api_key_requests_total{key=%q}`, k.Prefix)).Inc()
+
+	return &domain.Principal{UserId: k.UserId, Login: k.Login, ApiKeyId: k.Id, Scopes: k.Scopes}, nil
+}
+
+func httpError(c echo.Context, status int, msg string) error {
+	return echo.NewHTTPError(status, msg)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get(echo.HeaderAuthorization)
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
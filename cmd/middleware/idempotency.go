@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const (
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyAbandonAfter is when a request in progress is considered lost with its replica
+	idempotencyAbandonAfter = time.Minute
+)
+
+// Idempotency replays the response of the first POST request with an Idempotency-Key to the requests
+// with the same key, so the retries of clients aren't done twice. The keys are per client and kept
+// for the TTL. The key can't be reused for another request. Transient failures, like 5xx and 429,
+// aren't kept, such requests can be retried.
+//
+// The responses are stored as they are, so it's opted in by the routes creating resources. Responses
+// carrying credentials, like tokens and secrets, must never be stored: they are marked Cache-Control: no-store
+// and aren't kept even if the route opts in.
+type Idempotency struct {
+	store  domain.IdempotencyStore
+	ttl    time.Duration
+	fail   ErrorResponder // nil for the problem details of the domain errors
+	logger domain.Logger
+}
+
+func NewIdempotency(cfg *domain.Config, store domain.IdempotencyStore, logger domain.Logger) *Idempotency {
+	s := &Idempotency{store: store, ttl: cfg.Idempotency.TTL, logger: logger}
+	if s.ttl == 0 {
+		s.ttl = idempotencyTTL
+	}
+	return s
+}
+
+// WithErrors returns a copy of the middleware which rejects requests with fail.
+func (s *Idempotency) WithErrors(fail ErrorResponder) *Idempotency {
+	i := *s
+	i.fail = fail
+	return &i
+}
+
+func (s *Idempotency) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		key := req.Header.Get(domain.IdempotencyKeyHeader)
+		if req.Method != http.MethodPost || key == "" {
+			return next(c)
+		}
+		if len(key) > domain.IdempotencyKeyMaxLength {
+			return s.reject(c, http.StatusBadRequest, domain.NewValidationError(domain.IdempotencyKeyHeader, "must be at most 255 characters"))
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "cannot read body")
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		key = clientScope(c) + ":" + key
+		fingerprint := requestFingerprint(req, body)
+
+		logger := domain.LoggerFrom(req.Context(), s.logger)
+		first, err := s.store.Start(key, fingerprint, s.ttl, idempotencyAbandonAfter)
+		if err != nil {
+			logger.Error("cannot take idempotency key", zap.Error(err))
+			return next(c)
+		}
+		if first != nil {
+			switch {
+			case first.Fingerprint != fingerprint:
+				return s.reject(c, http.StatusUnprocessableEntity, domain.ErrIdempotencyKeyReused)
+			case first.Status == 0:
+				return s.reject(c, http.StatusConflict, domain.ErrIdempotencyInProgress)
+			}
+			c.Response().Header().Set(domain.IdempotentReplayedHeader, "true")
+			return c.Blob(first.Status, first.ContentType, first.Body)
+		}
+
+		res := c.Response()
+		w := res.Writer
+		rec := &recordingWriter{ResponseWriter: w}
+		res.Writer = rec
+		if err = next(c); err != nil {
+			c.Error(err)
+		}
+		res.Writer = w
+
+		if transient(res.Status) || noStore(res.Header()) {
+			err = s.store.Release(key)
+		} else {
+			err = s.store.Complete(key, res.Status, res.Header().Get(echo.HeaderContentType), rec.body.Bytes())
+		}
+		if err != nil {
+			logger.Error("cannot store idempotent response", zap.Error(err))
+		}
+		return nil
+	}
+}
+
+func (s *Idempotency) reject(c echo.Context, status int, err error) error {
+	if s.fail != nil {
+		return s.fail(c, status, err.Error())
+	}
+	return err
+}
+
+// transient reports whether the retry of the request can succeed, so the response isn't replayed.
+func transient(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= http.StatusInternalServerError
+}
+
+func noStore(h http.Header) bool {
+	return strings.Contains(strings.ToLower(h.Get(echo.HeaderCacheControl)), "no-store")
+}
+
+// clientScope scopes the keys, so clients can't get the responses of others or take their keys.
+// It's the user or the API key of the authenticated requests, so it outlives the access tokens,
+// and the IP of the anonymous ones.
+func clientScope(c echo.Context) string {
+	scope := "ip:" + c.RealIP()
+	if p, ok := c.Get(domain.PrincipalKey).(*domain.Principal); ok {
+		scope = fmt.Sprintf("user:%d", p.UserId)
+		if p.ApiKeyId != 0 {
+			scope = fmt.Sprintf("apikey:%d", p.ApiKeyId)
+		}
+	}
+	sum := sha256.Sum256([]byte(scope))
+	return hex.EncodeToString(sum[:])
+}
+
+func requestFingerprint(req *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + req.URL.RequestURI()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response body.
+type recordingWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// memoryIdempotency is the store of the tests, the keys don't expire.
+type memoryIdempotency struct {
+	mu   sync.Mutex
+	keys map[string]*domain.IdempotentRequest
+}
+
+func (m *memoryIdempotency) Start(key, fingerprint string, _, _ time.Duration) (*domain.IdempotentRequest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if first, ok := m.keys[key]; ok {
+		r := *first
+		return &r, nil
+	}
+	m.keys[key] = &domain.IdempotentRequest{Fingerprint: fingerprint}
+	return nil, nil
+}
+
+func (m *memoryIdempotency) Complete(key string, status int, contentType string, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.keys[key]
+	r.Status, r.ContentType, r.Body = status, contentType, body
+	return nil
+}
+
+func (m *memoryIdempotency) Release(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys[key].Status == 0 {
+		delete(m.keys, key)
+	}
+	return nil
+}
+
+func TestIdempotency(t *testing.T) {
+	store := &memoryIdempotency{keys: map[string]*domain.IdempotentRequest{}}
+	// httpError, the problem details of the domain errors are made by the API error handler
+	idempotent := NewIdempotency(&domain.Config{}, store, zap.NewNop()).WithErrors(httpError).Process
+
+	// the first try is rate limited, the next ones get through
+	created := 0
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	e.POST("/users", func(c echo.Context) error {
+		created++
+		if created == 1 {
+			return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+		}
+		return c.JSON(http.StatusCreated, map[string]int{"id": created})
+	}, idempotent)
+	groups := 0
+	authenticated := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(domain.PrincipalKey, &domain.Principal{UserId: 7})
+			return next(c)
+		}
+	}
+	e.POST("/groups", func(c echo.Context) error {
+		groups++
+		return c.JSON(http.StatusCreated, map[string]int{"id": groups})
+	}, authenticated, idempotent)
+	e.POST("/tokens", func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.JSON(http.StatusOK, map[string]string{"token": "secret"})
+	}, idempotent)
+
+	serveFrom := func(ip, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+ip) // the access tokens are refreshed
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(domain.IdempotencyKeyHeader, key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	serve := func(path, key, body string) *httptest.ResponseRecorder {
+		return serveFrom("192.0.2.1", path, key, body)
+	}
+
+	rec := serve("/users", "k1", `{"login":"alice"}`)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// the 429 isn't replayed, the retry is done
+	rec = serve("/users", "k1", `{"login":"alice"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Empty(t, rec.Header().Get(domain.IdempotentReplayedHeader))
+	require.JSONEq(t, `{"id":2}`, rec.Body.String())
+
+	rec = serve("/users", "k1", `{"login":"alice"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "true", rec.Header().Get(domain.IdempotentReplayedHeader))
+	require.JSONEq(t, `{"id":2}`, rec.Body.String())
+	require.Equal(t, 2, created)
+
+	rec = serve("/users", "k1", `{"login":"bob"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// the anonymous clients have the keys of their IPs
+	rec = serveFrom("192.0.2.2", "/users", "k1", `{"login":"bob"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Empty(t, rec.Header().Get(domain.IdempotentReplayedHeader))
+	require.Equal(t, 3, created)
+
+	// the users have theirs whatever the token and the IP
+	rec = serveFrom("192.0.2.1", "/groups", "k1", `{"name":"ops"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = serveFrom("192.0.2.3", "/groups", "k1", `{"name":"ops"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	require.Equal(t, "true", rec.Header().Get(domain.IdempotentReplayedHeader))
+	require.Equal(t, 1, groups)
+
+	// credentials are never stored
+	store.keys = map[string]*domain.IdempotentRequest{}
+	serve("/tokens", "k2", `{}`)
+	require.Empty(t, store.keys)
+}
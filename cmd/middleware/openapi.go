@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func init() {
+	// SCIM bodies and problem details are JSON under other names
+	decoder := openapi3filter.RegisteredBodyDecoder(echo.MIMEApplicationJSON)
+	openapi3filter.RegisterBodyDecoder(domain.SCIMContentType, decoder)
+	openapi3filter.RegisterBodyDecoder(domain.ProblemContentType, decoder)
+}
+
+// OpenAPI validates the requests against the OpenAPI document, routes missing from it are let through.
+// The responses are validated too if told so, it's meant for the tests: the response is buffered,
+// and the one not matching the document is replaced with 500.
+type OpenAPI struct {
+	router    routers.Router
+	options   *openapi3filter.Options
+	responses bool
+	fails     map[string]ErrorResponder // by path prefix
+	logger    domain.Logger
+}
+
+func NewOpenAPI(doc *openapi3.T, validateResponses bool, logger domain.Logger) (*OpenAPI, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	options := &openapi3filter.Options{
+		// Auth is checked by the route middlewares
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		// Clients send back what they've got, read-only properties included
+		ExcludeReadOnlyValidations: true,
+		SkipSettingDefaults:        true,
+		IncludeResponseStatus:      true,
+	}
+	return &OpenAPI{router, options, validateResponses, map[string]ErrorResponder{}, logger}, nil
+}
+
+// ErrorsFor returns the middleware rejecting the requests with the path prefix by fail instead of the problem details.
+func (s *OpenAPI) ErrorsFor(prefix string, fail ErrorResponder) *OpenAPI {
+	o := *s
+	o.fails = map[string]ErrorResponder{prefix: fail}
+	for p, f := range s.fails {
+		o.fails[p] = f
+	}
+	return &o
+}
+
+func (s *OpenAPI) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		route, params, err := s.router.FindRoute(req)
+		if err != nil {
+			return next(c)
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: params,
+			Route:      route,
+			Options:    s.options,
+		}
+		if err = openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+			if fail := s.fail(c); fail != nil {
+				return fail(c, http.StatusBadRequest, validationMessage(err))
+			}
+			return validationError(err)
+		}
+		if !s.responses {
+			return next(c)
+		}
+		return s.validateResponse(c, next, input)
+	}
+}
+
+func (s *OpenAPI) validateResponse(c echo.Context, next echo.HandlerFunc, input *openapi3filter.RequestValidationInput) error {
+	res := c.Response()
+	w := res.Writer
+	buf := &bufferedWriter{ResponseWriter: w}
+	res.Writer = buf
+	if err := next(c); err != nil {
+		c.Error(err)
+	}
+	res.Writer = w
+
+	status := buf.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	// net/http would sniff it on the first write
+	if w.Header().Get(echo.HeaderContentType) == "" && buf.body.Len() > 0 {
+		w.Header().Set(echo.HeaderContentType, http.DetectContentType(buf.body.Bytes()))
+	}
+
+	err := openapi3filter.ValidateResponse(c.Request().Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 status,
+		Header:                 w.Header(),
+		Body:                   io.NopCloser(bytes.NewReader(buf.body.Bytes())),
+		Options:                s.options,
+	})
+	if err != nil {
+		domain.LoggerFrom(c.Request().Context(), s.logger).Error("response doesn't match the OpenAPI document",
+			zap.String("method", c.Request().Method), zap.String("path", c.Path()), zap.Int("status", status), zap.Error(err))
+		// Nothing has been written yet
+		w.Header().Del(echo.HeaderContentType)
+		w.Header().Del(echo.HeaderContentLength)
+		res.Committed, res.Size = false, 0
+		return echo.ErrInternalServerError
+	}
+
+	if buf.status != 0 {
+		w.WriteHeader(buf.status)
+	}
+	_, err = w.Write(buf.body.Bytes())
+	return err
+}
+
+func (s *OpenAPI) fail(c echo.Context) ErrorResponder {
+	for prefix, fail := range s.fails {
+		if strings.HasPrefix(c.Request().URL.Path, prefix) {
+			return fail
+		}
+	}
+	return nil
+}
+
+// validationError tells the fields not matching the schema, the other errors are about the whole request.
+func validationError(err error) error {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var field, msg string
+	var schemaErr *openapi3.SchemaError
+	switch {
+	case errors.As(err, &schemaErr):
+		field, msg = strings.Join(schemaErr.JSONPointer(), "."), schemaErr.Reason
+		if schemaErr.SchemaField == "required" {
+			msg = "is required"
+		}
+	case reqErr.Parameter != nil && reqErr.Err != nil:
+		msg = reqErr.Err.Error()
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if reqErr.Parameter != nil {
+		field = reqErr.Parameter.Name
+	} else if field == "" {
+		field = "body"
+	}
+	return domain.NewValidationError(field, msg)
+}
+
+// validationMessage drops the dump of the schema, the field and the reason are enough.
+func validationMessage(err error) string {
+	var ve *domain.ValidationError
+	if errors.As(validationError(err), &ve) {
+		return ve.Fields[0].Field + ": " + ve.Fields[0].Message
+	}
+	return err.Error()
+}
+
+// bufferedWriter holds the response until it's validated, the headers are written to the real writer.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// rateLimitRemainingKey keeps the remaining requests reported in the headers, the most restrictive policy is reported.
+const rateLimitRemainingKey = "rateLimitRemaining"
+
+var rateLimitErrors = metrics.NewCounter(`gonah_rate_limit_errors_total`)
+
+// RateLimit rejects the requests of the clients exhausting the token buckets of the policies with 429.
+// Process limits the requests by IP, the middleware of ForClients limits the authenticated ones
+// by API key or user, so it goes after Auth. The requests are let through if the store fails.
+type RateLimit struct {
+	store    domain.RateLimitStore
+	enabled  bool
+	policies []*rateLimitPolicy
+	clients  bool
+	fails    map[string]ErrorResponder // by path prefix
+	logger   domain.Logger
+}
+
+type rateLimitPolicy struct {
+	domain.RateLimitPolicy
+	rate    float64 // tokens per second
+	routes  map[string]bool
+	limited *metrics.Counter
+}
+
+func NewRateLimit(cfg *domain.Config, store domain.RateLimitStore, logger domain.Logger) (*RateLimit, error) {
+	s := &RateLimit{store: store, enabled: cfg.RateLimit.Enabled, fails: map[string]ErrorResponder{}, logger: logger}
+	for _, p := range cfg.RateLimit.Policies {
+		if p.By != domain.RateLimitByIP && p.By != domain.RateLimitByClient {
+			return nil, fmt.Errorf("rate limit policy %q: unknown client %q", p.Name, p.By)
+		}
+		if p.Requests <= 0 || p.Per <= 0 {
+			return nil, fmt.Errorf("rate limit policy %q: requests and per must be positive", p.Name)
+		}
+		if p.Burst <= 0 {
+			p.Burst = p.Requests
+		}
+		policy := &rateLimitPolicy{
+			RateLimitPolicy: p,
+			rate:            float64(p.Requests) / p.Per.Seconds(),
+			routes:          map[string]bool{},
+			limited:         metrics.GetOrCreateCounter(fmt.Sprintf(`gonah_rate_limited_total{policy=%q}`, p.Name)),
+		}
+		for _, route := range p.Routes {
+			policy.routes[route] = true
+		}
+		s.policies = append(s.policies, policy)
+	}
+	return s, nil
+}
+
+// ForClients returns the middleware limiting the authenticated requests.
+func (s *RateLimit) ForClients() *RateLimit {
+	r := *s
+	r.clients = true
+	return &r
+}
+
+// ErrorsFor returns the middleware rejecting the requests with the path prefix by fail instead of the problem details.
+func (s *RateLimit) ErrorsFor(prefix string, fail ErrorResponder) *RateLimit {
+	r := *s
+	r.fails = map[string]ErrorResponder{prefix: fail}
+	for p, f := range s.fails {
+		r.fails[p] = f
+	}
+	return &r
+}
+
+func (s *RateLimit) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	if !s.enabled {
+		return next
+	}
+	return func(c echo.Context) error {
+		client := s.client(c)
+		for _, p := range s.policies {
+			if (p.By == domain.RateLimitByClient) != s.clients || !p.matches(c) {
+				continue
+			}
+
+			l, err := s.store.Take(p.Name+":"+client, p.rate, p.Burst)
+			if err != nil {
+				rateLimitErrors.Inc()
+				domain.LoggerFrom(c.Request().Context(), s.logger).Error("cannot take rate limit token",
+					zap.String("policy", p.Name), zap.Error(err))
+				continue
+			}
+			p.report(c, l)
+			if !l.Allowed {
+				p.limited.Inc()
+				retryAfter := math.Ceil((1 - l.Tokens) / p.rate)
+				c.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(int(retryAfter)))
+				return s.fail(c)(c, http.StatusTooManyRequests, "rate limit exceeded")
+			}
+		}
+		return next(c)
+	}
+}
+
+// client identifies the client, the principal is only known after Auth.
+func (s *RateLimit) client(c echo.Context) string {
+	if p, ok := c.Get(domain.PrincipalKey).(*domain.Principal); ok && s.clients {
+		if p.ApiKeyId != 0 {
+			return "apikey:" + strconv.Itoa(p.ApiKeyId)
+		}
+		return "user:" + strconv.Itoa(p.UserId)
+	}
+	return domain.IPKey(c.RealIP())
+}
+
+func (s *RateLimit) fail(c echo.Context) ErrorResponder {
+	for prefix, fail := range s.fails {
+		if strings.HasPrefix(c.Request().URL.Path, prefix) {
+			return fail
+		}
+	}
+	return httpError
+}
+
+func (p *rateLimitPolicy) matches(c echo.Context) bool {
+	return len(p.routes) == 0 || p.routes[c.Request().Method+" "+c.Path()] || p.routes[c.Path()]
+}
+
+// report sets the RateLimit headers of the IETF draft unless a policy with fewer remaining requests already did.
+func (p *rateLimitPolicy) report(c echo.Context, l domain.RateLimit) {
+	remaining := int(math.Floor(l.Tokens))
+	if reported, ok := c.Get(rateLimitRemainingKey).(int); ok && reported <= remaining {
+		return
+	}
+	c.Set(rateLimitRemainingKey, remaining)
+
+	h := c.Response().Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(p.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil((float64(p.Burst)-l.Tokens)/p.rate))))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d;name=%q", p.Requests, int(p.Per/time.Second), p.Burst, p.Name))
+}
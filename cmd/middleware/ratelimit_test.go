@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+func TestRateLimit(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.Policies = []domain.RateLimitPolicy{
+		{Name: "ip", By: domain.RateLimitByIP, Requests: 100, Per: time.Minute},
+		{Name: "login", By: domain.RateLimitByIP, Routes: []string{"POST /login"}, Requests: 2, Per: time.Minute},
+		{Name: "client", By: domain.RateLimitByClient, Requests: 60, Per: time.Minute, Burst: 3},
+	}
+	rateLimit, err := NewRateLimit(cfg, service.NewMemoryRateLimits(), zap.NewNop())
+	require.NoError(t, err)
+
+	// the principal is set by Auth
+	authenticated := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(domain.PrincipalKey, &domain.Principal{UserId: 7})
+			return next(c)
+		}
+	}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	e := echo.New()
+	e.Use(rateLimit.Process)
+	e.POST("/login", ok)
+	e.GET("/users", ok, authenticated, rateLimit.ForClients().Process)
+
+	serve := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(echo.HeaderXRealIP, ip)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/login", "10.0.0.1").Code)
+	}
+	rec := serve(http.MethodPost, "/login", "10.0.0.1")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "30", rec.Header().Get(echo.HeaderRetryAfter))
+	require.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, `2;w=60;burst=2;name="login"`, rec.Header().Get("RateLimit-Policy"))
+
+	// other clients have their own buckets
+	require.Equal(t, http.StatusNoContent, serve(http.MethodPost, "/login", "10.0.0.2").Code)
+
+	// the user is limited wherever the requests come from
+	for i, ip := range []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		rec = serve(http.MethodGet, "/users", ip)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Equal(t, "3", rec.Header().Get("RateLimit-Limit"), "the most restrictive policy is reported")
+		require.Equal(t, strconv.Itoa(2-i), rec.Header().Get("RateLimit-Remaining"))
+	}
+	rec = serve(http.MethodGet, "/users", "10.0.0.6")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get(echo.HeaderRetryAfter))
+
+	cfg.RateLimit.Policies = []domain.RateLimitPolicy{{Name: "typo", By: "login", Requests: 1, Per: time.Second}}
+	_, err = NewRateLimit(cfg, service.NewMemoryRateLimits(), zap.NewNop())
+	require.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/api"
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+)
+
+// RBAC checks the permissions of the principal set by Auth, so it must go after it.
+// Requests made with API keys need the permissions both in the key scopes and in the owner's roles.
+type RBAC struct {
+	roleRepo  *repo.RoleRepo
+	auditRepo *repo.AuditRepo
+	fail      ErrorResponder
+	logger    domain.Logger
+}
+
+func NewRBAC(roleRepo *repo.RoleRepo, auditRepo *repo.AuditRepo, logger domain.Logger) *RBAC {
+	return &RBAC{roleRepo, auditRepo, httpError, logger}
+}
+
+// WithErrors returns a copy of the middleware which rejects requests with fail.
+func (s *RBAC) WithErrors(fail ErrorResponder) *RBAC {
+	r := *s
+	r.fail = fail
+	return &r
+}
+
+// Require allows the request only if the principal has all the permissions.
+func (s *RBAC) Require(perms ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p, _ := c.Get(domain.PrincipalKey).(*domain.Principal)
+			if p == nil {
+				return s.fail(c, http.StatusUnauthorized, "authorization required")
+			}
+
+			allowed := p.ApiKeyId == 0 || hasScopes(p.Scopes, perms)
+			if allowed {
+				var err error
+				allowed, err = s.roleRepo.HasPermissions(p.UserId, perms)
+				if err != nil {
+					domain.LoggerFrom(c.Request().Context(), s.logger).Error("cannot check permissions", zap.Error(err))
+					return s.fail(c, http.StatusInternalServerError, "Internal Server Error")
+				}
+			}
+			if !allowed {
+				s.deny(c, p, perms)
+				return s.fail(c, http.StatusForbidden, "permission denied")
+			}
+			return next(c)
+		}
+	}
+}
+
+func (s *RBAC) deny(c echo.Context, p *domain.Principal, perms []string) {
+	e := api.NewAuditEntry(c, domain.AuditAccessDenied, c.Request().Method+" "+c.Path())
+	e.Details = map[string]interface{}{"permissions": perms}
+	if p.ApiKeyId != 0 {
+		e.Details["apiKeyId"] = p.ApiKeyId
+	}
+	if err := s.auditRepo.Create(e); err != nil {
+		domain.LoggerFrom(c.Request().Context(), s.logger).Error("cannot write audit log", zap.String("action", e.Action), zap.Error(err))
+	}
+}
+
+func hasScopes(scopes, perms []string) bool {
+	for _, perm := range perms {
+		found := false
+		for _, scope := range scopes {
+			if scope == perm {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const requestIdMaxLen = 128
+
+// RequestID takes the X-Request-ID of the caller or generates one, echoes it in the response
+// and puts it into the request context along with the logger of the request.
+type RequestID struct {
+	logger domain.Logger
+}
+
+func NewRequestID(logger domain.Logger) *RequestID {
+	return &RequestID{logger}
+}
+
+func (s *RequestID) Process(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := c.Request().Header.Get(domain.RequestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+			c.Request().Header.Set(domain.RequestIdHeader, id)
+		}
+		c.Response().Header().Set(domain.RequestIdHeader, id)
+
+		ctx := domain.WithRequestId(c.Request().Context(), id)
+		ctx = domain.WithLogger(ctx, s.logger.With(zap.String("request_id", id)))
+		c.SetRequest(c.Request().WithContext(ctx))
+		return next(c)
+	}
+}
+
+// validRequestId accepts the IDs safe to log and to pass on, UUIDs and the like.
+func validRequestId(id string) bool {
+	if id == "" || len(id) > requestIdMaxLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,35 +2,115 @@ package middleware
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/labstack/echo/v4"
 )
 
-type Victoria struct{}
+// unmatchedPath labels the requests not matching any route, so scanners can't create series with their paths.
+const unmatchedPath = "unmatched"
+
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// Victoria exports the request metrics labeled by the route template and the method.
+// The metrics of a route are created on its first request and cached.
+type Victoria struct {
+	set        *metrics.Set
+	inFlight   int64
+	routes     sync.Map // routeKey of the request -> *routeMetrics
+	once       sync.Once
+	registered map[routeKey]bool // the routes are known by the first request
+}
+
+type routeKey struct {
+	path, method string
+}
+
+type routeMetrics struct {
+	path, method string
+	duration     *metrics.Histogram
+	requestSize  *metrics.Summary
+	responseSize *metrics.Summary
+	set          *metrics.Set
+	statuses     sync.Map // int -> *metrics.Counter
+}
+
+func NewVictoria(set *metrics.Set) *Victoria {
+	s := &Victoria{set: set}
+	set.GetOrCreateGauge(`gonah_requests_in_flight`, func() float64 {
+		return float64(atomic.LoadInt64(&s.inFlight))
+	})
+	return s
+}
 
 func (s *Victoria) Process(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		start := time.Now()
-		md := fmt.Sprintf(
-			`gonah_request_duration_seconds{path=%q, method=%q}`,
-			c.Request().URL.Path,
-			c.Request().Method,
-		)
-		dur := metrics.GetOrCreateHistogram(md) // todo: cache histogram
+		atomic.AddInt64(&s.inFlight, 1)
+		defer atomic.AddInt64(&s.inFlight, -1)
 
 		if err := next(c); err != nil {
 			c.Error(err)
 		}
-		dur.UpdateDuration(start)
-		re := fmt.Sprintf(
-			`gonah_requests_total{path=%q, method=%q, status="%d"}`,
-			c.Request().URL.Path,
-			c.Request().Method,
-			c.Response().Status,
-		)
-		metrics.GetOrCreateCounter(re).Inc() // todo: cache counter
+
+		m := s.route(c)
+		m.duration.UpdateDuration(start)
+		if size := c.Request().ContentLength; size > 0 {
+			m.requestSize.Update(float64(size))
+		}
+		m.responseSize.Update(float64(c.Response().Size))
+		m.status(c.Response().Status).Inc()
 		return nil
 	}
 }
+
+// route returns the metrics of the route of the request. Echo sets the path of a partly matching route
+// for 404 and 405, these requests are labeled unmatched.
+func (s *Victoria) route(c echo.Context) *routeMetrics {
+	key := routeKey{c.Path(), c.Request().Method}
+	if !knownMethods[key.method] {
+		key.method = "OTHER"
+	}
+	if m, ok := s.routes.Load(key); ok {
+		return m.(*routeMetrics)
+	}
+
+	s.once.Do(func() {
+		s.registered = map[routeKey]bool{}
+		for _, r := range c.Echo().Routes() {
+			s.registered[routeKey{r.Path, r.Method}] = true
+		}
+	})
+	path, method := key.path, key.method
+	if !s.registered[key] {
+		path = unmatchedPath
+	}
+
+	labels := fmt.Sprintf(`path=%q, method=%q`, path, method)
+	m, _ := s.routes.LoadOrStore(key, &routeMetrics{
+		path:         path,
+		method:       method,
+		duration:     s.set.GetOrCreateHistogram(`gonah_request_duration_seconds{` + labels + `}`),
+		requestSize:  s.set.GetOrCreateSummary(`gonah_request_size_bytes{` + labels + `}`),
+		responseSize: s.set.GetOrCreateSummary(`gonah_response_size_bytes{` + labels + `}`),
+		set:          s.set,
+	})
+	return m.(*routeMetrics)
+}
+
+func (m *routeMetrics) status(status int) *metrics.Counter {
+	if c, ok := m.statuses.Load(status); ok {
+		return c.(*metrics.Counter)
+	}
+	c, _ := m.statuses.LoadOrStore(status, m.set.GetOrCreateCounter(
+		fmt.Sprintf(`gonah_requests_total{path=%q, method=%q, status="%d"}`, m.path, m.method, status),
+	))
+	return c.(*metrics.Counter)
+}
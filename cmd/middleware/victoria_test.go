@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func newVictoriaEcho(set *metrics.Set) *echo.Echo {
+	e := echo.New()
+	e.Use(NewVictoria(set).Process)
+	e.GET("/users/:id", func(c echo.Context) error {
+		return c.String(http.StatusOK, "Olivia")
+	})
+	e.POST("/users", func(c echo.Context) error {
+		return echo.ErrBadRequest
+	})
+	return e
+}
+
+func TestVictoria(t *testing.T) {
+	set := metrics.NewSet()
+	e := newVictoriaEcho(set)
+	serve := func(method, path, body string) {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, strings.NewReader(body)))
+	}
+	serve(http.MethodGet, "/users/1", "")
+	serve(http.MethodGet, "/users/2", "")
+	serve(http.MethodPost, "/users", `{"login":"Olivia"}`)
+	serve(http.MethodGet, "/wp-login.php", "")
+	serve(http.MethodDelete, "/users/1", "")
+	serve("PROPFIND", "/users/1", "")
+
+	out := &bytes.Buffer{}
+	set.WritePrometheus(out)
+	got := out.String()
+	for _, series := range []string{
+		`gonah_requests_total{path="/users/:id", method="GET", status="200"} 2`,
+		`gonah_requests_total{path="/users", method="POST", status="400"} 1`,
+		`gonah_requests_total{path="unmatched", method="GET", status="404"} 1`,
+		`gonah_requests_total{path="unmatched", method="DELETE", status="405"} 1`,
+		`gonah_requests_total{path="unmatched", method="OTHER", status="405"} 1`,
+		`gonah_request_duration_seconds_count{path="/users/:id", method="GET"} 2`,
+		`gonah_request_size_bytes_count{path="/users", method="POST"} 1`,
+		`gonah_request_size_bytes_sum{path="/users", method="POST"} 18`,
+		`gonah_response_size_bytes_sum{path="/users/:id", method="GET"} 12`,
+		`gonah_requests_in_flight 0`,
+	} {
+		require.Contains(t, got, series)
+	}
+	require.NotContains(t, got, "/users/1")
+	require.NotContains(t, got, "wp-login")
+}
+
+func BenchmarkVictoria(b *testing.B) {
+	for _, bc := range []struct {
+		name    string
+		handler http.Handler
+	}{
+		{"without", func() http.Handler {
+			e := echo.New()
+			e.GET("/users/:id", func(c echo.Context) error { return c.String(http.StatusOK, "Olivia") })
+			return e
+		}()},
+		{"with", newVictoriaEcho(metrics.NewSet())},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					bc.handler.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+)
+
+// The roles command bootstraps the first admin, who can manage the rest via API.
+var rolesCmd = &cobra.Command{
+	Use:   "roles",
+	Short: "Manage user roles",
+}
+
+var rolesGrantCmd = &cobra.Command{
+	Use:   "grant <login> <role>",
+	Short: "Assign the role to the user",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeRole(args[0], args[1], domain.AuditRoleGrant)
+	},
+}
+
+var rolesRevokeCmd = &cobra.Command{
+	Use:   "revoke <login> <role>",
+	Short: "Take the role away from the user",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return changeRole(args[0], args[1], domain.AuditRoleRevoke)
+	},
+}
+
+func init() {
+	rolesCmd.AddCommand(rolesGrantCmd, rolesRevokeCmd)
+	rootCmd.AddCommand(rolesCmd)
+}
+
+func changeRole(login, role, action string) error {
+	userRepo := diContainer.Get("repo.user").(*repo.UserRepo)
+	roleRepo := diContainer.Get("repo.role").(*repo.RoleRepo)
+	logger := diContainer.Get("logger").(domain.Logger)
+	defer diContainer.DeleteWithSubContainers()
+
+	user, err := userRepo.GetCredentials(login)
+	if err != nil {
+		return fmt.Errorf("cannot find user %q: %w", login, err)
+	}
+
+	audit := &domain.AuditEntry{
+		Action:  action,
+		Details: map[string]interface{}{"via": "cli"},
+	}
+	if action == domain.AuditRoleGrant {
+		err = roleRepo.Grant(user.Id, role, audit)
+	} else {
+		err = roleRepo.Revoke(user.Id, role, audit)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot change role %q of user %q: %w", role, login, err)
+	}
+	logger.Info("role changed", zap.String("login", login), zap.String("role", role), zap.String("action", action))
+	return nil
+}
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN password_hash text;
+---- create above / drop below ----
+ALTER TABLE users DROP COLUMN password_hash;
@@ -0,0 +1,14 @@
+CREATE TABLE refresh_tokens(
+    id bigserial PRIMARY KEY,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family text NOT NULL,
+    token_hash text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    revoked_at timestamptz,
+    UNIQUE (token_hash)
+);
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens(user_id);
+---- create above / drop below ----
+DROP TABLE refresh_tokens;
@@ -0,0 +1,16 @@
+CREATE TABLE api_keys(
+    id serial PRIMARY KEY,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name text NOT NULL,
+    prefix text NOT NULL,
+    key_hash text NOT NULL,
+    scopes text[] NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    UNIQUE (prefix)
+);
+CREATE INDEX api_keys_user_id_idx ON api_keys(user_id);
+---- create above / drop below ----
+DROP TABLE api_keys;
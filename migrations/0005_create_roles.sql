@@ -0,0 +1,60 @@
+CREATE TABLE roles(
+    id serial PRIMARY KEY,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    UNIQUE (name)
+);
+
+CREATE TABLE permissions(
+    id serial PRIMARY KEY,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    UNIQUE (name)
+);
+
+CREATE TABLE role_permissions(
+    role_id int NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id int NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE user_roles(
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id int NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE TABLE audit_log(
+    id bigserial PRIMARY KEY,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    actor_id int,
+    action text NOT NULL,
+    target text NOT NULL DEFAULT '',
+    request_id text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    details jsonb
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Manages users and their roles'),
+    ('user', 'Regular user');
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'View users'),
+    ('users:delete', 'Delete users'),
+    ('roles:read', 'View roles and their assignments'),
+    ('roles:manage', 'Assign and revoke roles');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' OR r.name = 'user' AND p.name = 'users:read';
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u, roles r WHERE r.name = 'user';
+---- create above / drop below ----
+DROP TABLE audit_log;
+DROP TABLE user_roles;
+DROP TABLE role_permissions;
+DROP TABLE permissions;
+DROP TABLE roles;
@@ -0,0 +1,28 @@
+ALTER TABLE audit_log ADD COLUMN before jsonb;
+ALTER TABLE audit_log ADD COLUMN after jsonb;
+CREATE INDEX audit_log_actor_id_idx ON audit_log(actor_id);
+CREATE INDEX audit_log_target_idx ON audit_log(target);
+CREATE INDEX audit_log_created_at_idx ON audit_log(created_at);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO permissions (name, description) VALUES ('audit:read', 'View the audit log');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'audit:read';
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id = (SELECT id FROM permissions WHERE name = 'audit:read');
+DELETE FROM permissions WHERE name = 'audit:read';
+DROP TRIGGER audit_log_no_truncate ON audit_log;
+DROP TRIGGER audit_log_append_only ON audit_log;
+DROP FUNCTION audit_log_append_only();
+ALTER TABLE audit_log DROP COLUMN after;
+ALTER TABLE audit_log DROP COLUMN before;
@@ -0,0 +1,20 @@
+ALTER TABLE users ADD COLUMN email text;
+ALTER TABLE users ADD COLUMN status text NOT NULL DEFAULT 'active';
+CREATE UNIQUE INDEX users_email_idx ON users(lower(email));
+
+CREATE TABLE user_tokens(
+    id bigserial PRIMARY KEY,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose text NOT NULL,
+    token_hash text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    used_at timestamptz,
+    UNIQUE (token_hash)
+);
+CREATE INDEX user_tokens_user_id_idx ON user_tokens(user_id, purpose, created_at);
+---- create above / drop below ----
+DROP TABLE user_tokens;
+DROP INDEX users_email_idx;
+ALTER TABLE users DROP COLUMN status;
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,18 @@
+CREATE TABLE user_totp(
+    user_id int PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    secret text NOT NULL, -- encrypted
+    created_at timestamptz NOT NULL DEFAULT now(),
+    enabled_at timestamptz,
+    last_step bigint NOT NULL DEFAULT 0
+);
+
+CREATE TABLE recovery_codes(
+    id bigserial PRIMARY KEY,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash text NOT NULL,
+    used_at timestamptz,
+    UNIQUE (user_id, code_hash)
+);
+---- create above / drop below ----
+DROP TABLE recovery_codes;
+DROP TABLE user_totp;
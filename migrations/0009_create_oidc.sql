@@ -0,0 +1,20 @@
+CREATE TABLE oidc_states(
+    state_hash text PRIMARY KEY,
+    code_verifier text NOT NULL,
+    nonce text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL
+);
+
+CREATE TABLE user_identities(
+    id bigserial PRIMARY KEY,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issuer text NOT NULL,
+    subject text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    UNIQUE (issuer, subject)
+);
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+---- create above / drop below ----
+DROP TABLE user_identities;
+DROP TABLE oidc_states;
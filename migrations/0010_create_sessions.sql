@@ -0,0 +1,26 @@
+CREATE TABLE sessions(
+    id bigserial PRIMARY KEY,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family text NOT NULL, -- of the refresh tokens
+    user_agent text NOT NULL DEFAULT '',
+    ip text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    last_seen_at timestamptz NOT NULL DEFAULT now(),
+    revoked_at timestamptz,
+    UNIQUE (family)
+);
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
+
+-- Logins made before sessions existed keep working
+INSERT INTO sessions (user_id, family, created_at, last_seen_at)
+SELECT user_id, family, min(created_at), max(created_at) FROM refresh_tokens
+WHERE revoked_at IS NULL AND expires_at > now()
+GROUP BY user_id, family;
+
+INSERT INTO permissions (name, description) VALUES ('sessions:manage', 'View and revoke sessions of any user');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'sessions:manage';
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id = (SELECT id FROM permissions WHERE name = 'sessions:manage');
+DELETE FROM permissions WHERE name = 'sessions:manage';
+DROP TABLE sessions;
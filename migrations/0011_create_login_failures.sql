@@ -0,0 +1,15 @@
+-- Failed logins per account (login:<lowercase login>) and per client (ip:<address>)
+CREATE TABLE login_failures(
+    key text PRIMARY KEY,
+    failures int NOT NULL,
+    last_failure_at timestamptz NOT NULL,
+    locked_until timestamptz
+);
+
+INSERT INTO permissions (name, description) VALUES ('users:unlock', 'Unlock accounts locked after failed logins');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'users:unlock';
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id = (SELECT id FROM permissions WHERE name = 'users:unlock');
+DELETE FROM permissions WHERE name = 'users:unlock';
+DROP TABLE login_failures;
@@ -0,0 +1,38 @@
+-- version is bumped on every change of the provisioned attributes, it's the ETag of the resource
+ALTER TABLE users ADD COLUMN external_id text;
+ALTER TABLE users ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE users ADD COLUMN updated_at timestamptz NOT NULL DEFAULT now();
+ALTER TABLE users ADD COLUMN version int NOT NULL DEFAULT 1;
+CREATE INDEX users_external_id_idx ON users(external_id);
+
+CREATE TABLE groups(
+    id serial PRIMARY KEY,
+    name text NOT NULL,
+    external_id text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    version int NOT NULL DEFAULT 1,
+    UNIQUE (name)
+);
+
+CREATE TABLE group_members(
+    group_id int NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id int NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (group_id, user_id)
+);
+CREATE INDEX group_members_user_id_idx ON group_members(user_id);
+
+INSERT INTO permissions (name, description) VALUES ('scim:provision', 'Provision users and groups via SCIM');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'scim:provision';
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id = (SELECT id FROM permissions WHERE name = 'scim:provision');
+DELETE FROM permissions WHERE name = 'scim:provision';
+DROP TABLE group_members;
+DROP TABLE groups;
+DROP INDEX users_external_id_idx;
+ALTER TABLE users DROP COLUMN version;
+ALTER TABLE users DROP COLUMN updated_at;
+ALTER TABLE users DROP COLUMN created_at;
+ALTER TABLE users DROP COLUMN external_id;
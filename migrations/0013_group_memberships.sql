@@ -0,0 +1,14 @@
+ALTER TABLE groups ADD COLUMN description text NOT NULL DEFAULT '';
+ALTER TABLE group_members ADD COLUMN role text NOT NULL DEFAULT 'member';
+
+INSERT INTO permissions (name, description) VALUES
+    ('groups:read', 'View groups and their members'),
+    ('groups:manage', 'Manage any group, owners manage their groups without it');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name IN ('groups:read', 'groups:manage') OR r.name = 'user' AND p.name = 'groups:read';
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name IN ('groups:read', 'groups:manage'));
+DELETE FROM permissions WHERE name IN ('groups:read', 'groups:manage');
+ALTER TABLE group_members DROP COLUMN role;
+ALTER TABLE groups DROP COLUMN description;
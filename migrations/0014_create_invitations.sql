@@ -0,0 +1,16 @@
+CREATE TABLE invitations(
+    id bigserial PRIMARY KEY,
+    group_id int NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    email text NOT NULL,
+    role text NOT NULL DEFAULT 'member',
+    token_hash text NOT NULL UNIQUE,
+    invited_by int REFERENCES users(id) ON DELETE SET NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL,
+    accepted_at timestamptz,
+    accepted_by int REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at timestamptz
+);
+CREATE INDEX invitations_group_id_idx ON invitations(group_id, id);
+---- create above / drop below ----
+DROP TABLE invitations;
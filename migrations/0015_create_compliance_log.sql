@@ -0,0 +1,40 @@
+CREATE TABLE compliance_log(
+    id bigserial PRIMARY KEY,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    request text NOT NULL,
+    subject_id int NOT NULL, -- no reference, the subject may be erased
+    actor_id int,
+    request_id text NOT NULL DEFAULT '',
+    details jsonb
+);
+CREATE INDEX compliance_log_subject_id_idx ON compliance_log(subject_id);
+
+-- Erasure is the only change of the audit log, it's enabled for the transaction erasing the user
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND current_setting('gonah.erasure', true) = 'on' THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION '% is append-only', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER compliance_log_append_only BEFORE UPDATE OR DELETE ON compliance_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER compliance_log_no_truncate BEFORE TRUNCATE ON compliance_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:export', 'Export all the data of any user'),
+    ('users:erase', 'Erase all the data of any user');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name IN ('users:export', 'users:erase');
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name IN ('users:export', 'users:erase'));
+DELETE FROM permissions WHERE name IN ('users:export', 'users:erase');
+DROP TABLE compliance_log;
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
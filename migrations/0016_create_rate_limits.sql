@@ -0,0 +1,10 @@
+-- Token buckets of the rate limits shared by the replicas, keyed by <policy>:<client>
+CREATE TABLE rate_limits(
+    key text PRIMARY KEY,
+    tokens double precision NOT NULL,
+    allowed boolean NOT NULL, -- whether the last request got a token
+    refill_secs double precision NOT NULL, -- from empty to full, the bucket can be forgotten after it
+    updated_at timestamptz NOT NULL
+);
+---- create above / drop below ----
+DROP TABLE rate_limits;
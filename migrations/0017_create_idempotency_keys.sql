@@ -0,0 +1,13 @@
+-- Responses of the requests with Idempotency-Key, keyed by <hash of the credentials>:<key>
+CREATE TABLE idempotency_keys(
+    key text PRIMARY KEY,
+    fingerprint text NOT NULL,
+    status int, -- null while the request is in progress
+    content_type text NOT NULL DEFAULT '',
+    body bytea,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL
+);
+CREATE INDEX idempotency_keys_expires_at ON idempotency_keys(expires_at);
+---- create above / drop below ----
+DROP TABLE idempotency_keys;
@@ -0,0 +1,21 @@
+-- Last change of the tables, it's the Last-Modified of their lists, deletions included
+CREATE TABLE table_changes(
+    name text PRIMARY KEY,
+    changed_at timestamptz NOT NULL
+);
+INSERT INTO table_changes (name, changed_at) VALUES ('users', now());
+
+CREATE FUNCTION table_changed() RETURNS trigger AS $$
+BEGIN
+    UPDATE table_changes SET changed_at = now() WHERE name = TG_TABLE_NAME;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Only the columns of the API representation, password changes don't change the list
+CREATE TRIGGER users_changed AFTER INSERT OR DELETE OR TRUNCATE OR UPDATE OF login, email, status ON users
+    FOR EACH STATEMENT EXECUTE FUNCTION table_changed();
+---- create above / drop below ----
+DROP TRIGGER users_changed ON users;
+DROP FUNCTION table_changed();
+DROP TABLE table_changes;
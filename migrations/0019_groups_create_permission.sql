@@ -0,0 +1,7 @@
+-- Users create groups of their own, roles without it can't
+INSERT INTO permissions (name, description) VALUES ('groups:create', 'Create groups and own them');
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p WHERE r.name IN ('admin', 'user') AND p.name = 'groups:create';
+---- create above / drop below ----
+DELETE FROM role_permissions WHERE permission_id = (SELECT id FROM permissions WHERE name = 'groups:create');
+DELETE FROM permissions WHERE name = 'groups:create';
@@ -0,0 +1,15 @@
+-- now() is the start of the transaction, a long one committing after a shorter one would move changed_at back.
+-- The row lock serializes the changes of the table until commit, so the next change is always later.
+CREATE OR REPLACE FUNCTION table_changed() RETURNS trigger AS $$
+BEGIN
+    UPDATE table_changes SET changed_at = GREATEST(changed_at, clock_timestamp()) WHERE name = TG_TABLE_NAME;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+---- create above / drop below ----
+CREATE OR REPLACE FUNCTION table_changed() RETURNS trigger AS $$
+BEGIN
+    UPDATE table_changes SET changed_at = now() WHERE name = TG_TABLE_NAME;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+type ApiKeysAction struct {
+	apiKeyRepo *repo.ApiKeyRepo
+	roleRepo   *repo.RoleRepo
+	logger     domain.Logger
+}
+
+func NewApiKeysAction(apiKeyRepo *repo.ApiKeyRepo, roleRepo *repo.RoleRepo, logger domain.Logger) *ApiKeysAction {
+	return &ApiKeysAction{apiKeyRepo, roleRepo, logger}
+}
+
+// API keys are managed in person only, so a leaked key can't list or revoke the others.
+func (s *ApiKeysAction) GetAll(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage API keys")
+	}
+	keys, err := s.apiKeyRepo.GetAll(p.UserId)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get API keys", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+func (s *ApiKeysAction) Create(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot create API keys")
+	}
+
+	key := &domain.ApiKey{}
+	if err = c.Bind(key); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "name and scopes required")
+	}
+	if err = c.Validate(key); err != nil {
+		return err
+	}
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "expiration time is in the past")
+	}
+	// Scopes are permissions, see middleware.RBAC
+	ok, err := s.roleRepo.PermissionsExist(key.Scopes)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot check permissions", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown scope")
+	}
+
+	key.UserId = p.UserId
+	key.Key, key.Prefix, key.Hash, err = service.NewApiKey()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate API key", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if err = s.apiKeyRepo.Create(key); err != nil {
+		requestLogger(c, s.logger).Error("cannot create API key", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	// The key is shown once
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, key)
+}
+
+func (s *ApiKeysAction) Revoke(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage API keys")
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong API key ID")
+	}
+
+	err = s.apiKeyRepo.Revoke(id, p.UserId)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot revoke API key", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
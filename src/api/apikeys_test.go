@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestApiKeys(t *testing.T) {
+	client := httpClient{}
+
+	dave := signUp(t, "Dave", "daves secret 1")
+	client.login(t, "Dave", "daves secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", dave.Id), nil)
+
+	body, err := json.Marshal(domain.ApiKey{Name: "billing", Scopes: []string{"users:read"}})
+	require.NoError(t, err)
+	resp, respBody, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/api-keys", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	key := domain.ApiKey{}
+	require.NoError(t, json.Unmarshal(respBody, &key))
+	require.NotEmpty(t, key.Key)
+
+	resp, respBody, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/api-keys", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var keys []domain.ApiKey
+	require.NoError(t, json.Unmarshal(respBody, &keys))
+	require.Len(t, keys, 1)
+	require.Equal(t, key.Prefix, keys[0].Prefix)
+	require.Empty(t, keys[0].Key)
+
+	// the key works both as a bearer token and in its own header
+	service := httpClient{token: key.Key}
+	resp, _, err = service.sendJsonReq(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", dave.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", dave.Id), nil)
+	require.NoError(t, err)
+	req.Header.Set(domain.ApiKeyHeader, key.Key)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the keys are managed in person only
+	resp, _, err = service.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/api-keys", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _, err = service.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/api-keys/%d", key.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _, err = client.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/api-keys/%d", key.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _, err = service.sendJsonReq(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", dave.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+)
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 500
+)
+
+type AuditAction struct {
+	auditRepo *repo.AuditRepo
+	logger    domain.Logger
+}
+
+func NewAuditAction(auditRepo *repo.AuditRepo, logger domain.Logger) *AuditAction {
+	return &AuditAction{auditRepo, logger}
+}
+
+// GetAll returns the audit log newest first, filtered by actor_id, action, target,
+// and since/until RFC 3339 times. Pages are fetched with the next_cursor of the previous one.
+func (s *AuditAction) GetAll(c echo.Context) (err error) {
+	f := domain.AuditFilter{Limit: auditDefaultLimit}
+	var cursor string
+	err = echo.QueryParamsBinder(c).
+		Int("actor_id", &f.ActorId).
+		String("action", &f.Action).
+		String("target", &f.Target).
+		Time("since", &f.Since, time.RFC3339).
+		Time("until", &f.Until, time.RFC3339).
+		Int("limit", &f.Limit).
+		String("cursor", &cursor).
+		BindError()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong query parameters")
+	}
+	if f.Limit < 1 || f.Limit > auditMaxLimit {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(auditMaxLimit))
+	}
+	if cursor != "" {
+		if f.BeforeId, err = decodeCursor(cursor); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "wrong cursor")
+		}
+	}
+
+	// One extra entry tells if there is a next page
+	f.Limit++
+	entries, err := s.auditRepo.GetPage(f)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get audit log", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	page := domain.AuditPage{Items: entries}
+	if len(entries) == f.Limit {
+		page.Items = entries[:len(entries)-1]
+		page.NextCursor = encodeCursor(page.Items[len(page.Items)-1].Id)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// NewAuditEntry describes the action taken by the caller of the request.
+func NewAuditEntry(c echo.Context, action, target string) *domain.AuditEntry {
+	e := &domain.AuditEntry{
+		Action:    action,
+		Target:    target,
+		RequestId: requestId(c),
+		IP:        c.RealIP(),
+	}
+	if p := principal(c); p != nil {
+		e.ActorId = &p.UserId
+	}
+	return e
+}
+
+func requestId(c echo.Context) string {
+	if id := domain.RequestId(c.Request().Context()); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(domain.RequestIdHeader)
+}
+
+// requestLogger is the logger of the request, it logs the request ID along with the messages.
+func requestLogger(c echo.Context, fallback domain.Logger) domain.Logger {
+	return domain.LoggerFrom(c.Request().Context(), fallback)
+}
+
+// Cursors are opaque for clients, so the pagination may change without breaking them.
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(b))
+}
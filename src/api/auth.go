@@ -0,0 +1,454 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+// mfaTTL is how long the user has to enter the code after the password.
+const mfaTTL = 5 * time.Minute
+
+type AuthAction struct {
+	cfg           *domain.Config
+	resetTTL      time.Duration
+	resetInterval time.Duration
+	userRepo      *repo.UserRepo
+	tokenRepo     *repo.TokenRepo
+	sessionRepo   *repo.SessionRepo
+	userTokenRepo *repo.UserTokenRepo
+	totpRepo      *repo.TotpRepo
+	guard         *loginGuard
+	password      *service.Password
+	token         *service.Token
+	totp          *service.Totp
+	mail          domain.MailSender
+	lifecycle     *service.Lifecycle
+	logger        domain.Logger
+}
+
+func NewAuthAction(
+	cfg *domain.Config,
+	userRepo *repo.UserRepo,
+	tokenRepo *repo.TokenRepo,
+	sessionRepo *repo.SessionRepo,
+	userTokenRepo *repo.UserTokenRepo,
+	totpRepo *repo.TotpRepo,
+	lockoutRepo *repo.LockoutRepo,
+	password *service.Password,
+	token *service.Token,
+	totp *service.Totp,
+	mail domain.MailSender,
+	lifecycle *service.Lifecycle,
+	logger domain.Logger,
+) *AuthAction {
+	s := &AuthAction{
+		cfg:           cfg,
+		resetTTL:      cfg.Auth.PasswordReset.TTL,
+		resetInterval: cfg.Auth.PasswordReset.Interval,
+		userRepo:      userRepo,
+		tokenRepo:     tokenRepo,
+		sessionRepo:   sessionRepo,
+		userTokenRepo: userTokenRepo,
+		totpRepo:      totpRepo,
+		guard:         newLoginGuard(cfg, lockoutRepo, logger),
+		password:      password,
+		token:         token,
+		totp:          totp,
+		mail:          mail,
+		lifecycle:     lifecycle,
+		logger:        logger,
+	}
+	if s.resetTTL == 0 {
+		s.resetTTL = time.Hour
+	}
+	if s.resetInterval == 0 {
+		s.resetInterval = time.Minute
+	}
+	return s
+}
+
+func (s *AuthAction) Login(c echo.Context) (err error) {
+	creds := &domain.Credentials{}
+	if err = c.Bind(creds); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "login and password required")
+	}
+	if err = c.Validate(creds); err != nil {
+		return err
+	}
+
+	wait, err := s.guard.wait(creds.Login, c.RealIP())
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get failed logins", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
+	user, err := s.userRepo.GetCredentials(creds.Login)
+	if errors.Is(err, domain.ErrNoRows) {
+		// Spend the same time as for an existing user, so logins can't be enumerated by timing
+		s.password.VerifyDummy(creds.Password)
+		s.guard.failed(creds.Login, c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid login or password")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user credentials", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	ok, rehash, err := s.password.Verify(creds.Password, user.PasswordHash)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot verify password", zap.Int("userId", user.Id), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if !ok {
+		s.guard.failed(creds.Login, c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid login or password")
+	}
+	s.guard.succeeded(creds.Login)
+	if user.Status == domain.UserPending {
+		return echo.NewHTTPError(http.StatusForbidden, "email is not verified")
+	}
+
+	if rehash {
+		hash, err := s.password.Hash(creds.Password)
+		if err == nil {
+			audit := NewAuditEntry(c, domain.AuditUserUpdate, "")
+			audit.ActorId = &user.Id
+			audit.Details = map[string]interface{}{"reason": "rehash"}
+			err = s.userRepo.UpdatePasswordHash(user.Id, hash, audit)
+		}
+		if err != nil {
+			requestLogger(c, s.logger).Warn("cannot upgrade password hash", zap.Int("userId", user.Id), zap.Error(err))
+		}
+	}
+
+	return s.completeLogin(c, user)
+}
+
+// completeLogin starts the session of the authenticated user or asks for the second factor if 2FA is on.
+func (s *AuthAction) completeLogin(c echo.Context, user domain.User) error {
+	if user.Status == domain.UserDisabled {
+		return echo.NewHTTPError(http.StatusForbidden, "account is disabled")
+	}
+	mfa, err := s.totpRepo.IsEnabled(user.Id)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot check if 2FA is enabled", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if mfa {
+		return s.mfaChallenge(c, user)
+	}
+	return s.startSession(c, user)
+}
+
+// mfaChallenge answers the password step of the login with a token to pass along with the code.
+func (s *AuthAction) mfaChallenge(c echo.Context, user domain.User) error {
+	token, hash, err := service.NewSecret()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate MFA token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	err = s.userTokenRepo.Create(&domain.UserToken{
+		UserId:    user.Id,
+		Purpose:   domain.TokenMFALogin,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(mfaTTL),
+	})
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot store MFA token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, domain.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(mfaTTL.Seconds()),
+	})
+}
+
+// LoginMFA is the second step of the login for users with 2FA enabled.
+func (s *AuthAction) LoginMFA(c echo.Context) (err error) {
+	req := &domain.MFALogin{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "MFA token and code required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	hash := service.HashToken(req.MFAToken)
+	userId, err := s.userTokenRepo.Owner(domain.TokenMFALogin, hash)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired MFA token")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get MFA token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	user, err := s.userRepo.GetById(userId)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	// Codes are guessed far easier than passwords, so they are counted against the account too
+	wait, err := s.guard.wait(user.Login, c.RealIP())
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get failed logins", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
+	ok, err := checkSecondFactor(s.totpRepo, s.totp, userId, req.Code, requestLogger(c, s.logger))
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot check TOTP code", zap.Int("userId", userId), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if !ok {
+		s.guard.failed(user.Login, c.RealIP())
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid code")
+	}
+	s.guard.succeeded(user.Login)
+
+	err = s.userTokenRepo.Use(userId, domain.TokenMFALogin, hash)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or expired MFA token")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot use MFA token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return s.startSession(c, user)
+}
+
+// startSession records the device the user logged in from and issues the tokens of a new refresh token family.
+func (s *AuthAction) startSession(c echo.Context, user domain.User) error {
+	refresh, hash, err := s.token.NewRefresh()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate refresh token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	rt := &domain.RefreshToken{
+		UserId:    user.Id,
+		Family:    hash, // the first token of a family names it
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(s.token.RefreshTTL()),
+	}
+	session := &domain.Session{
+		UserId:    user.Id,
+		Family:    rt.Family,
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	}
+	if err = s.sessionRepo.Create(session, rt); err != nil {
+		requestLogger(c, s.logger).Error("cannot store session", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return s.respondTokens(c, user, session.Id, refresh)
+}
+
+func (s *AuthAction) Refresh(c echo.Context) (err error) {
+	req := &domain.RefreshRequest{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "refresh token required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	refresh, hash, err := s.token.NewRefresh()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate refresh token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	next := &domain.RefreshToken{Hash: hash, ExpiresAt: time.Now().Add(s.token.RefreshTTL())}
+	err = s.tokenRepo.RotateRefresh(service.HashToken(req.RefreshToken), next)
+	if errors.Is(err, domain.ErrTokenReused) {
+		requestLogger(c, s.logger).Warn("refresh token reused, token family revoked", zap.Int("userId", next.UserId))
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+	} else if errors.Is(err, domain.ErrNoRows) || errors.Is(err, domain.ErrTokenExpired) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot rotate refresh token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	sessionId, err := s.sessionRepo.Refreshed(next.Family, c.RealIP())
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid refresh token")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot update session", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	user, err := s.userRepo.GetById(next.UserId)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return s.respondTokens(c, user, sessionId, refresh)
+}
+
+func (s *AuthAction) Logout(c echo.Context) (err error) {
+	req := &domain.RefreshRequest{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "refresh token required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	if err = s.tokenRepo.RevokeRefreshFamily(service.HashToken(req.RefreshToken)); err != nil {
+		requestLogger(c, s.logger).Error("cannot revoke refresh token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+// RequestPasswordReset always answers 202 Accepted, so it can't be used to find out
+// whether there is an account with the email. The mail is sent in the background
+// for the same reason: the response time doesn't depend on the account existence either.
+func (s *AuthAction) RequestPasswordReset(c echo.Context) (err error) {
+	req := &domain.PasswordResetRequest{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "email required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	// The echo context is reused once the handler returns, the shutdown waits for the mail
+	logger := requestLogger(c, s.logger)
+	s.lifecycle.Go(func() {
+		if err := s.sendPasswordReset(req.Email, logger); err != nil {
+			logger.Error("cannot send password reset mail", zap.Error(err))
+		}
+	})
+	return c.JSON(http.StatusAccepted, "Accepted")
+}
+
+func (s *AuthAction) sendPasswordReset(email string, logger domain.Logger) error {
+	user, err := s.userRepo.FindByEmail(email)
+	if errors.Is(err, domain.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, last, err := s.userTokenRepo.Issued(user.Id, domain.TokenPasswordReset, time.Now().Add(-s.resetInterval))
+	if err != nil {
+		return err
+	}
+	if last != nil {
+		logger.Info("password reset mail has been sent recently", zap.Int("userId", user.Id))
+		return nil
+	}
+
+	token, hash, err := service.NewSecret()
+	if err != nil {
+		return err
+	}
+	err = s.userTokenRepo.Create(&domain.UserToken{
+		UserId:    user.Id,
+		Purpose:   domain.TokenPasswordReset,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(s.resetTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(domain.Mail{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nfollow the link to set a new password:\n%s/reset-password?token=%s\n\n"+
+				"The link expires in %s. If you didn't ask for the reset, just ignore this mail.\n",
+			user.Login, s.cfg.Mail.BaseURL, token, s.resetTTL,
+		),
+	})
+}
+
+// ConfirmPasswordReset sets the new password and logs the user out everywhere.
+func (s *AuthAction) ConfirmPasswordReset(c echo.Context) (err error) {
+	req := &domain.PasswordResetConfirm{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "token and password required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	tokenHash := service.HashToken(req.Token)
+	userId, err := s.userTokenRepo.Owner(domain.TokenPasswordReset, tokenHash)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get password reset token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	user, err := s.userRepo.GetById(userId)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	if err = s.password.Validate(req.Password, user.Login); err != nil {
+		return domain.NewValidationError("password", err.Error())
+	}
+	hash, err := s.password.Hash(req.Password)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot hash password", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	audit := NewAuditEntry(c, domain.AuditUserUpdate, "")
+	audit.ActorId = &user.Id
+	audit.Details = map[string]interface{}{"reason": "reset"}
+	err = s.userRepo.ResetPassword(user.Id, tokenHash, hash, audit)
+	if errors.Is(err, domain.ErrNoRows) {
+		// the token has been used by a concurrent request
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot reset password", zap.Int("userId", user.Id), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+func (s *AuthAction) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, s.token.JWKS())
+}
+
+func (s *AuthAction) respondTokens(c echo.Context, user domain.User, sessionId int, refresh string) error {
+	access, ttl, err := s.token.IssueAccess(&domain.Principal{UserId: user.Id, Login: user.Login, SessionId: sessionId})
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot issue access token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, domain.TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(ttl.Seconds()),
+		RefreshToken: refresh,
+	})
+}
+
+// principal returns the caller authenticated by middleware.Auth.
+func principal(c echo.Context) *domain.Principal {
+	p, _ := c.Get(domain.PrincipalKey).(*domain.Principal)
+	return p
+}
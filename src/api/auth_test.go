@@ -0,0 +1,203 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestLogin(t *testing.T) {
+	client := httpClient{}
+
+	// weak password is rejected
+	body, err := json.Marshal(domain.User{Login: "Bob", Email: "bob@example.com", Password: "short"})
+	require.NoError(t, err)
+	resp, _, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/users", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	body, err = json.Marshal(domain.User{Login: "Bob", Email: "bob@example.com", Password: "bobs secret 1"})
+	require.NoError(t, err)
+	resp, respBody, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/users", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotContains(t, string(respBody), "password")
+	bob := domain.User{}
+	require.NoError(t, json.Unmarshal(respBody, &bob))
+	require.Equal(t, domain.UserPending, bob.Status)
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", bob.Id), nil)
+
+	creds, err := json.Marshal(domain.Credentials{Login: "Bob", Password: "bobs secret 1"})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", creds)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode, "email is not verified yet")
+
+	verifyURL := fmt.Sprintf("http://localhost:8877/api/v1/users/%d/verify", bob.Id)
+	token, err := mailedToken(bob.Email, verifyLink)
+	require.NoError(t, err)
+	body, err = json.Marshal(domain.VerifyRequest{Token: "wrong" + token})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, verifyURL, body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the first mail was sent on sign up
+	resp, _, err = client.sendJsonReq(http.MethodPost, verifyURL+"/resend", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	body, err = json.Marshal(domain.VerifyRequest{Token: token})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, verifyURL, body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// tokens are single-use
+	resp, _, err = client.sendJsonReq(http.MethodPost, verifyURL, body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, tc := range []struct {
+		login, password string
+		status          int
+	}{
+		{"Bob", "bobs secret 1", http.StatusOK},
+		{"Bob", "bobs secret 2", http.StatusUnauthorized},
+		{"Nobody", "bobs secret 1", http.StatusUnauthorized},
+	} {
+		body, err = json.Marshal(domain.Credentials{Login: tc.login, Password: tc.password})
+		require.NoError(t, err)
+		resp, _, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", body)
+		require.NoError(t, err)
+		require.Equal(t, tc.status, resp.StatusCode, tc.login+"/"+tc.password)
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	client := httpClient{}
+
+	carol := signUp(t, "Carol", "carols secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", carol.Id), nil)
+
+	first := client.login(t, "Carol", "carols secret 1")
+	resp, _, err := client.sendJsonReq(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", carol.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	refresh := func(token string) (int, domain.TokenPair) {
+		body, err := json.Marshal(domain.RefreshRequest{RefreshToken: token})
+		require.NoError(t, err)
+		resp, respBody, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/refresh", body)
+		require.NoError(t, err)
+		tokens := domain.TokenPair{}
+		_ = json.Unmarshal(respBody, &tokens)
+		return resp.StatusCode, tokens
+	}
+
+	status, second := refresh(first.RefreshToken)
+	require.Equal(t, http.StatusOK, status)
+	require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+
+	// replaying the rotated token revokes the whole family
+	status, _ = refresh(first.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, status)
+	status, _ = refresh(second.RefreshToken)
+	require.Equal(t, http.StatusUnauthorized, status)
+
+	resp, respBody, err := client.sendJsonReq(http.MethodGet, "http://localhost:8877/.well-known/jwks.json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	jwks := domain.JWKS{}
+	require.NoError(t, json.Unmarshal(respBody, &jwks))
+	require.NotEmpty(t, jwks.Keys)
+}
+
+func TestPasswordReset(t *testing.T) {
+	client := httpClient{}
+
+	grace := signUp(t, "Grace", "graces secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", grace.Id), nil)
+	session := client.login(t, "Grace", "graces secret 1")
+
+	// unknown emails are accepted just like the known ones
+	for _, email := range []string{"nobody@example.com", grace.Email} {
+		body, err := json.Marshal(domain.PasswordResetRequest{Email: email})
+		require.NoError(t, err)
+		resp, _, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/password-reset", body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusAccepted, resp.StatusCode, email)
+	}
+
+	var token string
+	require.Eventually(t, func() bool {
+		var err error
+		token, err = mailedToken(grace.Email, resetLink)
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+
+	confirm := func(token, password string) int {
+		body, err := json.Marshal(domain.PasswordResetConfirm{Token: token, Password: password})
+		require.NoError(t, err)
+		resp, _, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/password-reset/confirm", body)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusBadRequest, confirm(token, "weak"))
+	require.Equal(t, http.StatusBadRequest, confirm("wrong"+token, "graces secret 2"))
+	require.Equal(t, http.StatusOK, confirm(token, "graces secret 2"))
+	require.Equal(t, http.StatusBadRequest, confirm(token, "graces secret 3"), "tokens are single-use")
+
+	// every session is logged out
+	body, err := json.Marshal(domain.RefreshRequest{RefreshToken: session.RefreshToken})
+	require.NoError(t, err)
+	resp, _, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/refresh", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	body, err = json.Marshal(domain.Credentials{Login: "Grace", Password: "graces secret 1"})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	client.login(t, "Grace", "graces secret 2")
+}
+
+func TestLockout(t *testing.T) {
+	client := httpClient{}
+	mallory := signUp(t, "Mallory", "mallorys secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", mallory.Id), nil)
+
+	login := func(password string) *http.Response {
+		body, err := json.Marshal(domain.Credentials{Login: "Mallory", Password: password})
+		require.NoError(t, err)
+		resp, _, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", body)
+		require.NoError(t, err)
+		return resp
+	}
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusUnauthorized, login("guess").StatusCode)
+	}
+
+	// the right password doesn't help until the delay is over
+	resp := login("mallorys secret 1")
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	url := fmt.Sprintf("http://localhost:8877/api/v1/users/%d/lockout", mallory.Id)
+	resp, _, err := client.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _, err = admin.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Equal(t, http.StatusOK, login("mallorys secret 1").StatusCode)
+}
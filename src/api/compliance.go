@@ -0,0 +1,131 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+// ComplianceAction handles data subject requests, both of them are recorded in the compliance log.
+type ComplianceAction struct {
+	complianceRepo *repo.ComplianceRepo
+	erasureEvents  *service.Events
+	groupEvents    *service.Events
+	logger         domain.Logger
+}
+
+func NewComplianceAction(
+	complianceRepo *repo.ComplianceRepo,
+	erasureEvents *service.Events,
+	groupEvents *service.Events,
+	logger domain.Logger,
+) *ComplianceAction {
+	return &ComplianceAction{complianceRepo, erasureEvents, groupEvents, logger}
+}
+
+// Export returns everything stored about the user as JSON, or as ZIP with a file per part if format=zip.
+func (s *ComplianceAction) Export(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	format := c.QueryParam("format")
+	if format != "" && format != "json" && format != "zip" {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be json or zip")
+	}
+
+	export, err := s.complianceRepo.Export(id, newComplianceEntry(c))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot export user", zap.Int("userId", id), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	name := fmt.Sprintf("user-%d-export", id)
+	if format != "zip" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.json"`, name))
+		return c.JSON(http.StatusOK, export)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	c.Response().WriteHeader(http.StatusOK)
+	if err = writeExportZip(c.Response(), export); err != nil {
+		// It's too late to respond with an error, the client gets a broken archive
+		requestLogger(c, s.logger).Error("cannot write user export", zap.Int("userId", id), zap.Error(err))
+	}
+	return nil
+}
+
+// Erase deletes the user and anonymizes what has to be kept. Consumers of the users.erasure topic
+// get a tombstone for the user, the groups topic gets the removal of the memberships.
+func (s *ComplianceAction) Erase(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+
+	erasure, err := s.complianceRepo.Erase(id, newComplianceEntry(c), NewAuditEntry(c, domain.AuditUserErase, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot erase user", zap.Int("userId", id), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	s.erasureEvents.Tombstone(c.Request().Context(), strconv.Itoa(id))
+	for _, groupId := range erasure.Groups {
+		publishMembership(s.groupEvents, c, domain.EventMemberRemoved, groupId, id, "")
+	}
+	return c.JSON(http.StatusOK, erasure)
+}
+
+func newComplianceEntry(c echo.Context) *domain.ComplianceEntry {
+	e := &domain.ComplianceEntry{RequestId: requestId(c)}
+	if p := principal(c); p != nil {
+		e.ActorId = &p.UserId
+	}
+	return e
+}
+
+func writeExportZip(w http.ResponseWriter, export domain.UserExport) error {
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"roles.json", export.Roles},
+		{"memberships.json", export.Memberships},
+		{"sessions.json", export.Sessions},
+		{"api_keys.json", export.ApiKeys},
+		{"identities.json", export.Identities},
+		{"security.json", map[string]interface{}{"totp_enabled": export.TOTPEnabled}},
+		{"invitations.json", export.Invitations},
+		{"audit_entries.json", export.AuditEntries},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: f.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(f.v); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestCompliance(t *testing.T) {
+	quentin := signUp(t, "Quentin", "quentins secret 1")
+	client := httpClient{}
+	client.login(t, "Quentin", "quentins secret 1")
+	userUrl := fmt.Sprintf("http://localhost:8877/api/v1/users/%d", quentin.Id)
+
+	resp, _, err := client.sendJsonReq(http.MethodGet, userUrl+"/export", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, respBody, err := admin.sendJsonReq(http.MethodGet, userUrl+"/export", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	export := domain.UserExport{}
+	require.NoError(t, json.Unmarshal(respBody, &export))
+	require.Equal(t, "Quentin", export.Profile.Login)
+	require.Equal(t, "quentin@example.com", export.Profile.Email)
+	require.Len(t, export.Sessions, 1)
+	require.NotEmpty(t, export.AuditEntries)
+
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, userUrl+"/export?format=zip", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	archive, err := zip.NewReader(bytes.NewReader(respBody), int64(len(respBody)))
+	require.NoError(t, err)
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	require.Contains(t, names, "profile.json")
+	require.Contains(t, names, "audit_entries.json")
+
+	resp, respBody, err = admin.sendJsonReq(http.MethodPost, userUrl+"/erasure", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	erasure := domain.Erasure{}
+	require.NoError(t, json.Unmarshal(respBody, &erasure))
+	require.Equal(t, quentin.Id, erasure.UserId)
+	require.NotZero(t, erasure.AnonymizedAudit)
+
+	// the sessions are gone with the user
+	resp, _, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/sessions", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp, _, err = admin.sendJsonReq(http.MethodGet, userUrl+"/export", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// conditionalJSON responds with v, its strong ETag and modified as Last-Modified, unless modified is zero.
+// GET requests get 304 if the client has the representation already.
+func conditionalJSON(c echo.Context, status int, v interface{}, modified time.Time) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	etag := strongETag(body)
+	h := c.Response().Header()
+	h.Set("ETag", etag)
+	if !modified.IsZero() {
+		h.Set(echo.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(c.Request(), etag, modified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(status, body)
+}
+
+// checkIfMatch returns domain.ErrVersionMismatch if If-Match doesn't list the ETag of current,
+// the representation conditionalJSON responds with. The write goes on without If-Match.
+func checkIfMatch(c echo.Context, current interface{}) error {
+	h := c.Request().Header.Get("If-Match")
+	if h == "" {
+		return nil
+	}
+	body, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if !etagListed(h, strongETag(body), false) {
+		return domain.ErrVersionMismatch
+	}
+	return nil
+}
+
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified evaluates If-None-Match, or If-Modified-Since without it (RFC 9110 13.2.2).
+func notModified(req *http.Request, etag string, modified time.Time) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if h := req.Header.Get("If-None-Match"); h != "" {
+		return etagListed(h, etag, true)
+	}
+	since, err := http.ParseTime(req.Header.Get(echo.HeaderIfModifiedSince))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
+
+// etagListed reports whether the header lists etag or is "*". If-None-Match compares weakly,
+// so W/ prefixes are ignored, and If-Match strongly, so weak ETags never match.
+func etagListed(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
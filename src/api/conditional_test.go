@@ -0,0 +1,63 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	send := func(method, url string, header map[string]string) *http.Response {
+		req, err := http.NewRequest(method, url, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+admin.token)
+		for name, v := range header {
+			req.Header.Set(name, v)
+		}
+		resp, err := admin.parent.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	rupert := signUp(t, "Rupert", "ruperts secret 1")
+	url := fmt.Sprintf("http://localhost:8877/api/v1/users/%d", rupert.Id)
+	resp := send(http.MethodGet, url, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	etag, modified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	require.Regexp(t, `^"[0-9a-f]{32}"$`, etag)
+	require.NotEmpty(t, modified)
+
+	// the client has the user already
+	resp = send(http.MethodGet, url, map[string]string{"If-None-Match": `"stale", ` + etag})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	require.Equal(t, etag, resp.Header.Get("ETag"))
+	resp = send(http.MethodGet, url, map[string]string{"If-Modified-Since": modified})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	// If-None-Match wins over If-Modified-Since
+	resp = send(http.MethodGet, url, map[string]string{"If-None-Match": `"stale"`, "If-Modified-Since": modified})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	list := "http://localhost:8877/api/v1/users"
+	resp = send(http.MethodGet, list, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	listETag := resp.Header.Get("ETag")
+	require.NotEmpty(t, resp.Header.Get("Last-Modified"))
+	resp = send(http.MethodGet, list, map[string]string{"If-Modified-Since": resp.Header.Get("Last-Modified")})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// writes are done only if the client has seen the current user
+	resp = send(http.MethodDelete, url, map[string]string{"If-Match": `"stale"`})
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	resp = send(http.MethodDelete, url, map[string]string{"If-Match": "W/" + etag})
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode, "weak ETags don't match strongly")
+	resp = send(http.MethodDelete, url, map[string]string{"If-Match": etag})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// deletions change the list
+	resp = send(http.MethodGet, list, map[string]string{"If-None-Match": listETag})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NotEqual(t, listETag, resp.Header.Get("ETag"))
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// problems maps the domain errors handlers may return as they are.
+var problems = []struct {
+	err    error
+	status int
+	typ    string
+	detail string // the message of the error if empty
+}{
+	{domain.ErrNoRows, http.StatusNotFound, domain.ProblemNotFound, "resource not found"},
+	{domain.ErrConflict, http.StatusConflict, domain.ProblemConflict, ""},
+	{domain.ErrVersionMismatch, http.StatusPreconditionFailed, domain.ProblemModified, ""},
+	{domain.ErrLastOwner, http.StatusConflict, domain.ProblemConflict, ""},
+	{domain.ErrUnknownMember, http.StatusNotFound, domain.ProblemNotFound, ""},
+	{domain.ErrTotpEnabled, http.StatusConflict, domain.ProblemConflict, ""},
+	{domain.ErrLoginRequired, http.StatusBadRequest, domain.ProblemValidation, ""},
+	{domain.ErrUserDisabled, http.StatusForbidden, domain.ProblemForbidden, ""},
+	{domain.ErrNotPending, http.StatusConflict, domain.ProblemConflict, ""},
+	{domain.ErrOIDCDisabled, http.StatusNotFound, domain.ProblemNotFound, ""},
+	{domain.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity, domain.ProblemKeyReused, ""},
+	{domain.ErrIdempotencyInProgress, http.StatusConflict, domain.ProblemConflict, ""},
+}
+
+// ErrorHandler responds to the errors of the handlers and middlewares with problem details, see RFC 7807.
+// Errors it doesn't know are logged and become 500.
+type ErrorHandler struct {
+	logger domain.Logger
+}
+
+func NewErrorHandler(logger domain.Logger) *ErrorHandler {
+	return &ErrorHandler{logger}
+}
+
+func (s *ErrorHandler) Handle(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	p := s.problem(err, c)
+	p.Instance = c.Request().URL.Path
+	p.RequestId = requestId(c)
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, domain.ProblemContentType)
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(p.Status)
+	} else {
+		err = c.JSON(p.Status, p)
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot respond with problem", zap.Error(err))
+	}
+}
+
+func (s *ErrorHandler) problem(err error, c echo.Context) domain.Problem {
+	var ve *domain.ValidationError
+	if errors.As(err, &ve) {
+		return domain.Problem{
+			Type:   domain.ProblemValidation,
+			Title:  "Invalid request",
+			Status: http.StatusBadRequest,
+			Detail: fmt.Sprintf("%d field(s) failed validation", len(ve.Fields)),
+			Errors: ve.Fields,
+		}
+	}
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		p := domain.Problem{Type: domain.ProblemBlank, Status: he.Code}
+		if msg := fmt.Sprint(he.Message); msg != http.StatusText(he.Code) {
+			p.Detail = msg
+		}
+		if he.Internal != nil {
+			requestLogger(c, s.logger).Warn("request failed", zap.String("path", c.Path()), zap.Int("status", he.Code), zap.Error(he.Internal))
+		}
+		return p
+	}
+
+	for _, m := range problems {
+		if errors.Is(err, m.err) {
+			p := domain.Problem{Type: m.typ, Status: m.status, Detail: m.detail}
+			if p.Detail == "" {
+				p.Detail = err.Error()
+			}
+			return p
+		}
+	}
+
+	requestLogger(c, s.logger).Error("unhandled error", zap.String("method", c.Request().Method), zap.String("path", c.Path()), zap.Error(err))
+	return domain.Problem{Type: domain.ProblemBlank, Status: http.StatusInternalServerError}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestProblems(t *testing.T) {
+	client := httpClient{}
+
+	problem := func(resp *http.Response, body []byte) domain.Problem {
+		require.Equal(t, domain.ProblemContentType, resp.Header.Get("Content-Type"))
+		p := domain.Problem{}
+		require.NoError(t, json.Unmarshal(body, &p))
+		require.Equal(t, resp.StatusCode, p.Status)
+		return p
+	}
+
+	// the validator names the fields as they are in JSON
+	resp, respBody, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/users", []byte(`{"login":"","email":"nope"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	p := problem(resp, respBody)
+	require.Equal(t, domain.ProblemValidation, p.Type)
+	require.Equal(t, "/api/v1/users", p.Instance)
+	require.Equal(t, []domain.FieldError{
+		{Field: "login", Message: "is required"},
+		{Field: "email", Message: "must be a valid email"},
+	}, p.Errors)
+
+	// so does the OpenAPI document
+	resp, respBody, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/users", []byte(`{"login":"Olivia"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	p = problem(resp, respBody)
+	require.Equal(t, []domain.FieldError{{Field: "email", Message: "is required"}}, p.Errors)
+
+	resp, respBody, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/users", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	p = problem(resp, respBody)
+	require.Equal(t, domain.ProblemBlank, p.Type)
+	require.Equal(t, "Unauthorized", p.Title)
+	require.Equal(t, "authorization required", p.Detail)
+
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/users/1000000", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	p = problem(resp, respBody)
+	require.Equal(t, "user not found", p.Detail)
+}
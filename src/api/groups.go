@@ -0,0 +1,330 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+const (
+	groupsDefaultLimit = 50
+	groupsMaxLimit     = 500
+)
+
+// GroupsAction manages groups and their members. Users with groups:manage manage any group,
+// owners manage their own ones, and every member may leave.
+// Membership changes are published to the groups Kafka topic.
+type GroupsAction struct {
+	groupRepo *repo.GroupRepo
+	roleRepo  *repo.RoleRepo
+	auditRepo *repo.AuditRepo
+	events    *service.Events
+	logger    domain.Logger
+}
+
+func NewGroupsAction(
+	groupRepo *repo.GroupRepo,
+	roleRepo *repo.RoleRepo,
+	auditRepo *repo.AuditRepo,
+	events *service.Events,
+	logger domain.Logger,
+) *GroupsAction {
+	return &GroupsAction{groupRepo, roleRepo, auditRepo, events, logger}
+}
+
+func (s *GroupsAction) GetAll(c echo.Context) (err error) {
+	offset, limit, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+	groups, total, err := s.groupRepo.Find(domain.GroupFilter{}, offset, limit)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get groups", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, domain.GroupPage{Items: groups, Total: total})
+}
+
+func (s *GroupsAction) GetById(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	group, err := s.groupRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get group by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, group)
+}
+
+// Create makes the caller the owner of the new group.
+func (s *GroupsAction) Create(c echo.Context) (err error) {
+	group := &domain.Group{}
+	if err = c.Bind(group); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "name required")
+	}
+	if err = c.Validate(group); err != nil {
+		return err
+	}
+
+	owner := domain.Membership{UserId: principal(c).UserId, Role: domain.GroupOwner}
+	err = s.groupRepo.Create(group, []domain.Membership{owner}, NewAuditEntry(c, domain.AuditGroupCreate, ""))
+	if errors.Is(err, domain.ErrConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "group with such name already exists")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot create group", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	publishMembership(s.events, c, domain.EventMemberAdded, group.Id, owner.UserId, owner.Role)
+	return c.JSON(http.StatusOK, group)
+}
+
+// Update changes the name and description, the members are managed separately.
+func (s *GroupsAction) Update(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	req := &domain.Group{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "name required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+	if ok, err := s.canManage(c, id); err != nil || !ok {
+		return s.denied(c, err)
+	}
+
+	group, err := s.groupRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get group by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	group.Name, group.Description = req.Name, req.Description
+
+	_, _, err = s.groupRepo.Update(&group, nil, 0, NewAuditEntry(c, domain.AuditGroupUpdate, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if errors.Is(err, domain.ErrConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "group with such name already exists")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot update group", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, group)
+}
+
+func (s *GroupsAction) Delete(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	if ok, err := s.canManage(c, id); err != nil || !ok {
+		return s.denied(c, err)
+	}
+
+	members, err := s.groupRepo.Delete(id, 0, NewAuditEntry(c, domain.AuditGroupDelete, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot delete group", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	for _, userId := range members {
+		publishMembership(s.events, c, domain.EventMemberRemoved, id, userId, "")
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+// GetMembers lists the members page by page with offset and limit.
+func (s *GroupsAction) GetMembers(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	offset, limit, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.groupRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get group by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	members, total, err := s.groupRepo.FindMembers(id, offset, limit)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get group members", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, domain.MembershipPage{Items: members, Total: total})
+}
+
+// SetMember adds the user to the group or changes the role of the member.
+func (s *GroupsAction) SetMember(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	userId, err := strconv.Atoi(c.Param("uid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	req := &domain.Membership{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "role required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+	if ok, err := s.canManage(c, id); err != nil || !ok {
+		return s.denied(c, err)
+	}
+
+	previous, err := s.groupRepo.SetMember(id, userId, req.Role, NewAuditEntry(c, domain.AuditGroupMemberSet, ""))
+	switch {
+	case errors.Is(err, domain.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	case errors.Is(err, domain.ErrUnknownMember):
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	case errors.Is(err, domain.ErrLastOwner):
+		return err
+	case err != nil:
+		requestLogger(c, s.logger).Error("cannot set group member", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	if previous == "" {
+		publishMembership(s.events, c, domain.EventMemberAdded, id, userId, req.Role)
+	} else if previous != req.Role {
+		publishMembership(s.events, c, domain.EventMemberRoleChanged, id, userId, req.Role)
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+// RemoveMember takes the user out of the group, members may leave on their own.
+func (s *GroupsAction) RemoveMember(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	userId, err := strconv.Atoi(c.Param("uid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	if p := principal(c); p.ApiKeyId != 0 || p.UserId != userId {
+		if ok, err := s.canManage(c, id); err != nil || !ok {
+			return s.denied(c, err)
+		}
+	}
+
+	_, err = s.groupRepo.RemoveMember(id, userId, NewAuditEntry(c, domain.AuditGroupMemberRemove, ""))
+	switch {
+	case errors.Is(err, domain.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "membership not found")
+	case errors.Is(err, domain.ErrLastOwner):
+		return err
+	case err != nil:
+		requestLogger(c, s.logger).Error("cannot remove group member", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	publishMembership(s.events, c, domain.EventMemberRemoved, id, userId, "")
+	return c.JSON(http.StatusOK, "OK")
+}
+
+func (s *GroupsAction) canManage(c echo.Context, groupId int) (bool, error) {
+	return canManageGroup(c, s.roleRepo, s.groupRepo, groupId)
+}
+
+func (s *GroupsAction) denied(c echo.Context, err error) error {
+	return denied(c, s.auditRepo, s.logger, err)
+}
+
+// canManageGroup tells if the caller has groups:manage or owns the group.
+// API keys need the scope either way, owners manage their groups in person only.
+func canManageGroup(c echo.Context, roleRepo *repo.RoleRepo, groupRepo *repo.GroupRepo, groupId int) (bool, error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 && !hasScope(p.Scopes, domain.PermGroupsManage) {
+		return false, nil
+	}
+	ok, err := roleRepo.HasPermissions(p.UserId, []string{domain.PermGroupsManage})
+	if err != nil || ok || p.ApiKeyId != 0 {
+		return ok, err
+	}
+
+	role, err := groupRepo.MemberRole(groupId, p.UserId)
+	if errors.Is(err, domain.ErrNoRows) {
+		return false, nil
+	}
+	return role == domain.GroupOwner, err
+}
+
+// denied responds with 403 and records the denial like the RBAC middleware does,
+// or responds with 500 if the permissions couldn't be checked.
+func denied(c echo.Context, auditRepo *repo.AuditRepo, logger domain.Logger, err error) error {
+	logger = requestLogger(c, logger)
+	if err != nil {
+		logger.Error("cannot check group permissions", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	e := NewAuditEntry(c, domain.AuditAccessDenied, c.Request().Method+" "+c.Path())
+	e.Details = map[string]interface{}{"permissions": []string{domain.PermGroupsManage}}
+	if p := principal(c); p.ApiKeyId != 0 {
+		e.Details["apiKeyId"] = p.ApiKeyId
+	}
+	if err = auditRepo.Create(e); err != nil {
+		logger.Error("cannot write audit log", zap.String("action", e.Action), zap.Error(err))
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "permission denied")
+}
+
+func hasScope(scopes []string, perm string) bool {
+	for _, scope := range scopes {
+		if scope == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// publishMembership sends the event on behalf of the caller, it's done after the change is committed.
+func publishMembership(events *service.Events, c echo.Context, event string, groupId, userId int, role string) {
+	e := domain.MembershipEvent{Type: event, GroupId: groupId, UserId: userId, Role: role, At: time.Now().UTC()}
+	if p := principal(c); p != nil {
+		e.ActorId = &p.UserId
+	}
+	events.Publish(c.Request().Context(), e)
+}
+
+// pageParams reads offset and limit of the list endpoints.
+func pageParams(c echo.Context) (offset, limit int, err error) {
+	limit = groupsDefaultLimit
+	err = echo.QueryParamsBinder(c).Int("offset", &offset).Int("limit", &limit).BindError()
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "wrong query parameters")
+	}
+	if offset < 0 {
+		return 0, 0, domain.NewValidationError("offset", "must not be negative")
+	}
+	if limit < 1 || limit > groupsMaxLimit {
+		return 0, 0, domain.NewValidationError("limit", "must be between 1 and "+strconv.Itoa(groupsMaxLimit))
+	}
+	return
+}
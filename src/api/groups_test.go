@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestGroups(t *testing.T) {
+	peggy := signUp(t, "Peggy", "peggys secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", peggy.Id), nil)
+	victor := signUp(t, "Victor", "victors secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", victor.Id), nil)
+
+	owner := httpClient{}
+	owner.login(t, "Peggy", "peggys secret 1")
+	member := httpClient{}
+	member.login(t, "Victor", "victors secret 1")
+
+	body, err := json.Marshal(domain.Group{Name: "Reporting", Description: "Monthly reports"})
+	require.NoError(t, err)
+	resp, respBody, err := owner.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/groups", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	group := domain.Group{}
+	require.NoError(t, json.Unmarshal(respBody, &group))
+	groupUrl := fmt.Sprintf("http://localhost:8877/api/v1/groups/%d", group.Id)
+	defer admin.sendJsonReq(http.MethodDelete, groupUrl, nil)
+
+	resp, _, err = owner.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/groups", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	body, err = json.Marshal(domain.Membership{Role: domain.GroupMember})
+	require.NoError(t, err)
+	resp, _, err = owner.sendJsonReq(http.MethodPut, fmt.Sprintf("%s/members/%d", groupUrl, victor.Id), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// members can't manage the group
+	resp, _, err = member.sendJsonReq(http.MethodPut, fmt.Sprintf("%s/members/%d", groupUrl, peggy.Id), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, _, err = member.sendJsonReq(http.MethodDelete, groupUrl, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// the denials are audited like the ones of the RBAC middleware
+	url := fmt.Sprintf("http://localhost:8877/api/v1/audit?actor_id=%d&action=%s&limit=1", victor.Id, domain.AuditAccessDenied)
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	audit := domain.AuditPage{}
+	require.NoError(t, json.Unmarshal(respBody, &audit))
+	require.Len(t, audit.Items, 1)
+	require.Equal(t, "DELETE /api/v1/groups/:id", audit.Items[0].Target)
+
+	resp, respBody, err = member.sendJsonReq(http.MethodGet, groupUrl+"/members?limit=1", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page := domain.MembershipPage{}
+	require.NoError(t, json.Unmarshal(respBody, &page))
+	require.Equal(t, 2, page.Total)
+	require.Len(t, page.Items, 1)
+	require.Equal(t, "Peggy", page.Items[0].Login)
+	require.Equal(t, domain.GroupOwner, page.Items[0].Role)
+
+	resp, _, err = member.sendJsonReq(http.MethodGet, groupUrl+"/members?limit=1000", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the last owner neither leaves nor steps down
+	resp, _, err = owner.sendJsonReq(http.MethodDelete, fmt.Sprintf("%s/members/%d", groupUrl, peggy.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _, err = owner.sendJsonReq(http.MethodPut, fmt.Sprintf("%s/members/%d", groupUrl, peggy.Id), body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, _, err = member.sendJsonReq(http.MethodDelete, fmt.Sprintf("%s/members/%d", groupUrl, victor.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _, err = owner.sendJsonReq(http.MethodDelete, groupUrl, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _, err = owner.sendJsonReq(http.MethodGet, groupUrl, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
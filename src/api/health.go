@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+// HealthAction serves the probes, they answer 503 with the same report if a check fails.
+type HealthAction struct {
+	health *service.Health
+}
+
+func NewHealthAction(health *service.Health) *HealthAction {
+	return &HealthAction{health}
+}
+
+func (s *HealthAction) Livez(c echo.Context) error {
+	return healthReport(c, s.health.Live(c.Request().Context()))
+}
+
+func (s *HealthAction) Readyz(c echo.Context) error {
+	return healthReport(c, s.health.Ready(c.Request().Context()))
+}
+
+func healthReport(c echo.Context, r domain.HealthReport) error {
+	if r.Status != domain.HealthUp {
+		return c.JSON(http.StatusServiceUnavailable, r)
+	}
+	return c.JSON(http.StatusOK, r)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestHealth(t *testing.T) {
+	client := httpClient{}
+
+	resp, respBody, err := client.sendJsonReq(http.MethodGet, "http://localhost:8877/livez", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, respBody, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/readyz", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(respBody))
+	report := domain.HealthReport{}
+	require.NoError(t, json.Unmarshal(respBody, &report))
+	require.Equal(t, domain.HealthUp, report.Status)
+	for _, name := range []string{"db", "kafka", "migrations", "shutdown"} {
+		require.Equal(t, domain.HealthUp, report.Checks[name].Status, name)
+	}
+}
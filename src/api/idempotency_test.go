@@ -0,0 +1,49 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	signUp := func(key string, user domain.User) (*http.Response, []byte) {
+		body, err := json.Marshal(user)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, "http://localhost:8877/api/v1/users", bytes.NewBuffer(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(domain.IdempotencyKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, respBody
+	}
+
+	user := domain.User{Login: "Wendy", Email: "wendy@example.com", Password: "wendys secret 1"}
+	resp, first := signUp("signup-wendy", user)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Empty(t, resp.Header.Get(domain.IdempotentReplayedHeader))
+
+	// the retry gets the first response instead of a conflict
+	resp, retried := signUp("signup-wendy", user)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "true", resp.Header.Get(domain.IdempotentReplayedHeader))
+	require.JSONEq(t, string(first), string(retried))
+
+	// the key can't be reused for another request
+	user.Login, user.Email = "Wendy2", "wendy2@example.com"
+	resp, body := signUp("signup-wendy", user)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	p := domain.Problem{}
+	require.NoError(t, json.Unmarshal(body, &p))
+	require.Equal(t, domain.ProblemKeyReused, p.Type)
+}
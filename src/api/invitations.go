@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+// InvitationsAction invites people into groups by email, before they have an account.
+// Invitations are managed by the ones managing the group, the mailed token is the only thing needed to accept.
+type InvitationsAction struct {
+	cfg            *domain.Config
+	ttl            time.Duration
+	invitationRepo *repo.InvitationRepo
+	groupRepo      *repo.GroupRepo
+	roleRepo       *repo.RoleRepo
+	auditRepo      *repo.AuditRepo
+	password       *service.Password
+	mail           domain.MailSender
+	events         *service.Events
+	logger         domain.Logger
+}
+
+func NewInvitationsAction(
+	cfg *domain.Config,
+	invitationRepo *repo.InvitationRepo,
+	groupRepo *repo.GroupRepo,
+	roleRepo *repo.RoleRepo,
+	auditRepo *repo.AuditRepo,
+	password *service.Password,
+	mail domain.MailSender,
+	events *service.Events,
+	logger domain.Logger,
+) *InvitationsAction {
+	s := &InvitationsAction{
+		cfg:            cfg,
+		ttl:            cfg.Auth.Invitation.TTL,
+		invitationRepo: invitationRepo,
+		groupRepo:      groupRepo,
+		roleRepo:       roleRepo,
+		auditRepo:      auditRepo,
+		password:       password,
+		mail:           mail,
+		events:         events,
+		logger:         logger,
+	}
+	if s.ttl == 0 {
+		s.ttl = 7 * 24 * time.Hour
+	}
+	return s
+}
+
+func (s *InvitationsAction) GetAll(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	offset, limit, err := pageParams(c)
+	if err != nil {
+		return err
+	}
+	if ok, err := canManageGroup(c, s.roleRepo, s.groupRepo, id); err != nil || !ok {
+		return denied(c, s.auditRepo, s.logger, err)
+	}
+
+	invitations, total, err := s.invitationRepo.Find(id, offset, limit)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get invitations", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, domain.InvitationPage{Items: invitations, Total: total})
+}
+
+// Create mails the invitation, the role is member unless told otherwise.
+func (s *InvitationsAction) Create(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	inv := &domain.Invitation{}
+	if err = c.Bind(inv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "email required")
+	}
+	if inv.Role == "" {
+		inv.Role = domain.GroupMember
+	}
+	if err = c.Validate(inv); err != nil {
+		return err
+	}
+	if ok, err := canManageGroup(c, s.roleRepo, s.groupRepo, id); err != nil || !ok {
+		return denied(c, s.auditRepo, s.logger, err)
+	}
+
+	group, err := s.groupRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get group by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	token, hash, err := service.NewSecret()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate invitation token", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	inv.GroupId, inv.Hash = id, hash
+	inv.InvitedBy = &principal(c).UserId
+	inv.ExpiresAt = time.Now().Add(s.ttl)
+	err = s.invitationRepo.Create(inv, NewAuditEntry(c, domain.AuditInvitationCreate, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "group not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot create invitation", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	// The invitation can be sent again if the mail fails
+	if err = s.sendInvitation(inv, group, token); err != nil {
+		requestLogger(c, s.logger).Error("cannot send invitation mail", zap.Int("invitationId", inv.Id), zap.Error(err))
+	}
+	return c.JSON(http.StatusOK, inv)
+}
+
+func (s *InvitationsAction) Revoke(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong group ID")
+	}
+	invitationId, err := strconv.Atoi(c.Param("iid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong invitation ID")
+	}
+	if ok, err := canManageGroup(c, s.roleRepo, s.groupRepo, id); err != nil || !ok {
+		return denied(c, s.auditRepo, s.logger, err)
+	}
+
+	err = s.invitationRepo.Revoke(id, invitationId, NewAuditEntry(c, domain.AuditInvitationRevoke, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "pending invitation not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot revoke invitation", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+// Accept joins the invitee to the group. People without an account send login and password to create it,
+// the email is verified by the token already.
+func (s *InvitationsAction) Accept(c echo.Context) (err error) {
+	req := &domain.InvitationAccept{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "token required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	var newUser *domain.User
+	if req.Login != "" || req.Password != "" {
+		if err = s.password.Validate(req.Password, req.Login); err != nil {
+			return domain.NewValidationError("password", err.Error())
+		}
+		newUser = &domain.User{Login: req.Login}
+		newUser.PasswordHash, err = s.password.Hash(req.Password)
+		if err != nil {
+			requestLogger(c, s.logger).Error("cannot hash password", zap.Error(err))
+			return echo.ErrInternalServerError
+		}
+	}
+
+	ret, previous, err := s.invitationRepo.Accept(service.HashToken(req.Token), newUser, NewAuditEntry(c, domain.AuditInvitationAccept, ""))
+	switch {
+	case errors.Is(err, domain.ErrNoRows):
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	case errors.Is(err, domain.ErrConflict):
+		return echo.NewHTTPError(http.StatusConflict, "user with such login already exists")
+	case errors.Is(err, domain.ErrLoginRequired), errors.Is(err, domain.ErrUserDisabled):
+		return err
+	case err != nil:
+		requestLogger(c, s.logger).Error("cannot accept invitation", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	if previous == "" {
+		publishMembership(s.events, c, domain.EventMemberAdded, ret.GroupId, ret.User.Id, ret.Role)
+	} else if previous != ret.Role {
+		publishMembership(s.events, c, domain.EventMemberRoleChanged, ret.GroupId, ret.User.Id, ret.Role)
+	}
+	return c.JSON(http.StatusOK, ret)
+}
+
+func (s *InvitationsAction) sendInvitation(inv *domain.Invitation, group domain.Group, token string) error {
+	return s.mail.Send(domain.Mail{
+		To:      inv.Email,
+		Subject: fmt.Sprintf("You are invited to %s", group.Name),
+		Body: fmt.Sprintf(
+			"Hi,\n\nyou are invited to join %s as %s. Accept the invitation by following the link:\n%s/invitations/accept?token=%s\n\nThe link expires in %s.\n",
+			group.Name, inv.Role, s.cfg.Mail.BaseURL, token, s.ttl,
+		),
+	})
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+var invitationLink = regexp.MustCompile(`/invitations/accept\?token=([\w-]+)`)
+
+func TestInvitations(t *testing.T) {
+	body, err := json.Marshal(domain.Group{Name: "Onboarding"})
+	require.NoError(t, err)
+	resp, respBody, err := admin.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/groups", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	group := domain.Group{}
+	require.NoError(t, json.Unmarshal(respBody, &group))
+	invitationsUrl := fmt.Sprintf("http://localhost:8877/api/v1/groups/%d/invitations", group.Id)
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/groups/%d", group.Id), nil)
+
+	invite := func() domain.Invitation {
+		body, err := json.Marshal(domain.Invitation{Email: "trent@example.com"})
+		require.NoError(t, err)
+		resp, respBody, err := admin.sendJsonReq(http.MethodPost, invitationsUrl, body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		inv := domain.Invitation{}
+		require.NoError(t, json.Unmarshal(respBody, &inv))
+		require.Equal(t, domain.InvitationPending, inv.Status)
+		require.Equal(t, domain.GroupMember, inv.Role)
+		return inv
+	}
+	anonymous := httpClient{}
+	accept := func(req domain.InvitationAccept) (*http.Response, []byte) {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+		resp, respBody, err := anonymous.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/invitations/accept", body)
+		require.NoError(t, err)
+		return resp, respBody
+	}
+
+	// revoked invitations can't be accepted
+	inv := invite()
+	token, err := mailedToken(inv.Email, invitationLink)
+	require.NoError(t, err)
+	resp, _, err = admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("%s/%d", invitationsUrl, inv.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = accept(domain.InvitationAccept{Token: token, Login: "Trent", Password: "trents secret 1"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	invite()
+	token, err = mailedToken(inv.Email, invitationLink)
+	require.NoError(t, err)
+	resp, _ = accept(domain.InvitationAccept{Token: token})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, respBody = accept(domain.InvitationAccept{Token: token, Login: "Trent", Password: "trents secret 1"})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	accepted := domain.AcceptedInvitation{}
+	require.NoError(t, json.Unmarshal(respBody, &accepted))
+	require.True(t, accepted.Created)
+	require.Equal(t, domain.UserActive, accepted.User.Status)
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", accepted.User.Id), nil)
+
+	resp, _ = accept(domain.InvitationAccept{Token: token})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	trent := httpClient{}
+	trent.login(t, "Trent", "trents secret 1")
+	resp, respBody, err = trent.sendJsonReq(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/groups/%d/members", group.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page := domain.MembershipPage{}
+	require.NoError(t, json.Unmarshal(respBody, &page))
+	require.Equal(t, 2, page.Total)
+
+	// members don't see who else is invited
+	resp, _, err = trent.sendJsonReq(http.MethodGet, invitationsUrl, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, invitationsUrl, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	invitations := domain.InvitationPage{}
+	require.NoError(t, json.Unmarshal(respBody, &invitations))
+	require.Equal(t, 2, invitations.Total)
+	require.Equal(t, domain.InvitationAccepted, invitations.Items[0].Status)
+	require.Equal(t, domain.InvitationRevoked, invitations.Items[1].Status)
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+)
+
+var (
+	loginFailures   = metrics.NewCounter(`gonah_auth_login_failures_total`)
+	accountLockouts = metrics.NewCounter(`gonah_auth_lockouts_total{scope="account"}`)
+	ipLockouts      = metrics.NewCounter(`gonah_auth_lockouts_total{scope="ip"}`)
+)
+
+// loginGuard slows down and then locks out password guessing. Failures are counted
+// per account and per client IP: after the free attempts every next try of the account
+// has to wait twice as long as the previous one, reaching the limit locks the account
+// or the client for the lockout period.
+type loginGuard struct {
+	window       time.Duration
+	freeAttempts int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	accountLimit int
+	ipLimit      int
+	period       time.Duration
+	lockoutRepo  *repo.LockoutRepo
+	logger       domain.Logger
+}
+
+func newLoginGuard(cfg *domain.Config, lockoutRepo *repo.LockoutRepo, logger domain.Logger) *loginGuard {
+	lc := cfg.Auth.Lockout
+	g := &loginGuard{
+		window:       lc.Window,
+		freeAttempts: lc.FreeAttempts,
+		baseDelay:    lc.BaseDelay,
+		maxDelay:     lc.MaxDelay,
+		accountLimit: lc.AccountLimit,
+		ipLimit:      lc.IPLimit,
+		period:       lc.LockoutPeriod,
+		lockoutRepo:  lockoutRepo,
+		logger:       logger,
+	}
+	if g.window == 0 {
+		g.window = 15 * time.Minute
+	}
+	if g.freeAttempts == 0 {
+		g.freeAttempts = 3
+	}
+	if g.baseDelay == 0 {
+		g.baseDelay = time.Second
+	}
+	if g.maxDelay == 0 {
+		g.maxDelay = time.Minute
+	}
+	if g.accountLimit == 0 {
+		g.accountLimit = 10
+	}
+	if g.ipLimit == 0 {
+		g.ipLimit = 100
+	}
+	if g.period == 0 {
+		g.period = 15 * time.Minute
+	}
+	return g
+}
+
+// wait returns how long the login attempt has to wait, zero means it may go on.
+func (g *loginGuard) wait(login, ip string) (time.Duration, error) {
+	now := time.Now()
+	account, err := g.lockoutRepo.Get(domain.LoginKey(login), g.window)
+	if err != nil {
+		return 0, err
+	}
+	client, err := g.lockoutRepo.Get(domain.IPKey(ip), g.window)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, f := range []domain.LoginFailures{account, client} {
+		if f.LockedUntil != nil && f.LockedUntil.After(now) {
+			wait = maxDuration(wait, f.LockedUntil.Sub(now))
+		}
+	}
+	if account.Failures >= g.freeAttempts {
+		delay := g.maxDelay
+		if shift := account.Failures - g.freeAttempts; shift < 30 {
+			delay = minDuration(g.baseDelay<<shift, g.maxDelay)
+		}
+		wait = maxDuration(wait, account.LastFailureAt.Add(delay).Sub(now))
+	}
+	return wait, nil
+}
+
+// failed counts the failure, errors are only logged: the login has failed anyway.
+func (g *loginGuard) failed(login, ip string) {
+	loginFailures.Inc()
+
+	account, err := g.lockoutRepo.Fail(domain.LoginKey(login), g.window, g.accountLimit, g.period)
+	if err != nil {
+		g.logger.Error("cannot count failed login", zap.Error(err))
+	} else if account.Failures == g.accountLimit {
+		accountLockouts.Inc()
+		g.logger.Warn("account locked after failed logins", zap.String("login", login), zap.String("ip", ip))
+	}
+
+	client, err := g.lockoutRepo.Fail(domain.IPKey(ip), g.window, g.ipLimit, g.period)
+	if err != nil {
+		g.logger.Error("cannot count failed login", zap.Error(err))
+	} else if client.Failures == g.ipLimit {
+		ipLockouts.Inc()
+		g.logger.Warn("client locked after failed logins", zap.String("ip", ip))
+	}
+}
+
+func (g *loginGuard) succeeded(login string) {
+	if err := g.lockoutRepo.Reset(domain.LoginKey(login)); err != nil {
+		g.logger.Error("cannot reset failed logins", zap.Error(err))
+	}
+}
+
+func tooManyAttempts(c echo.Context, wait time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed login attempts, try again later")
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -3,10 +3,16 @@ package api
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"testing"
@@ -16,15 +22,29 @@ import (
 	"github.com/jackc/pgx/v4"
 	"github.com/ory/dockertest/v3"
 	"github.com/ory/dockertest/v3/docker"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/service/oidctest"
 )
 
 const containersExpireSec = 30
 
+var (
+	// admin is logged in with the admin role, it also cleans up after the tests
+	admin httpClient
+	// mailDir gets the mails sent by the API
+	mailDir string
+	// idp is the OIDC provider the API trusts
+	idp *oidctest.Server
+	// kafkaBroker is where the API produces its messages
+	kafkaBroker string
+)
+
 type httpClient struct {
 	parent http.Client
+	token  string
 }
 
 func TestMain(m *testing.M) {
@@ -71,11 +91,34 @@ func startAPI(m *testing.M, logger domain.Logger, dbConn, kafkaConn string) {
 		logger.Panic("os.Chdir failed", zap.Error(err))
 	}
 
+	kafkaBroker = kafkaConn
+	mailDir, err = os.MkdirTemp("", "gonah-mail")
+	if err != nil {
+		logger.Panic("cannot create mail directory", zap.Error(err))
+	}
+	defer os.RemoveAll(mailDir)
+
+	idp, err = oidctest.NewServer("gonah", "gonah secret")
+	if err != nil {
+		logger.Panic("cannot start OIDC provider", zap.Error(err))
+	}
+	defer idp.Close()
+
+	env := os.Environ()
+	env = append(env, domain.EnvPrefix+"_APIPORT=8877")
+	env = append(env, domain.EnvPrefix+"_OPENAPI_VALIDATERESPONSES=true")
+	// the tests log in and sign up way more often than the policies allow
+	env = append(env, domain.EnvPrefix+"_RATELIMIT_ENABLED=false")
+	env = append(env, domain.EnvPrefix+"_MAIL_DRIVER=file")
+	env = append(env, domain.EnvPrefix+"_MAIL_DIR="+mailDir)
+	env = append(env, domain.EnvPrefix+"_AUTH_OIDC_ISSUER="+idp.Issuer())
+	env = append(env, domain.EnvPrefix+"_AUTH_OIDC_CLIENTID="+idp.ClientId)
+	env = append(env, domain.EnvPrefix+"_AUTH_OIDC_CLIENTSECRET="+idp.ClientSecret)
+	env = append(env, domain.EnvPrefix+"_DB_DSN="+dbConn)
+	env = append(env, domain.EnvPrefix+"_KAFKA_HOST="+kafkaConn)
+
 	cmd := exec.Command("./gonah", "api")
-	cmd.Env = os.Environ()
-	cmd.Env = append(cmd.Env, domain.EnvPrefix+"_APIPORT=8877")
-	cmd.Env = append(cmd.Env, domain.EnvPrefix+"_DB_DSN="+dbConn)
-	cmd.Env = append(cmd.Env, domain.EnvPrefix+"_KAFKA_HOST="+kafkaConn)
+	cmd.Env = env
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	err = cmd.Start()
@@ -83,10 +126,13 @@ func startAPI(m *testing.M, logger domain.Logger, dbConn, kafkaConn string) {
 		logger.Panic("failed to start api", zap.Error(err))
 	}
 	waitAPI(cmd, logger)
+	createAdmin(cmd, env, logger)
 
 	// Run all tests
 	code := m.Run()
 	_ = cmd.Process.Signal(syscall.SIGKILL)
+	_ = os.RemoveAll(mailDir)
+	idp.Close()
 	os.Exit(code)
 }
 
@@ -113,6 +159,95 @@ func waitAPI(cmd *exec.Cmd, logger domain.Logger) {
 	}
 }
 
+// createAdmin signs up a user and grants it the admin role with the CLI, like it's done in production.
+func createAdmin(api *exec.Cmd, env []string, logger domain.Logger) {
+	_, err := signUpUser("admin", "admin secret 1")
+	if err == nil {
+		cmd := exec.Command("./gonah", "roles", "grant", "admin", domain.RoleAdmin)
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+	}
+	if err == nil {
+		body, _ := json.Marshal(domain.Credentials{Login: "admin", Password: "admin secret 1"})
+		resp, respBody, err := admin.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", body)
+		tokens := domain.TokenPair{}
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		if err == nil {
+			err = json.Unmarshal(respBody, &tokens)
+		}
+		admin.token = tokens.AccessToken
+	}
+	if err != nil {
+		_ = api.Process.Kill()
+		logger.Panic("cannot create admin", zap.Error(err))
+	}
+}
+
+// signUp creates the user with login@example.com email and verifies it.
+func signUp(t *testing.T, login, password string) domain.User {
+	user, err := signUpUser(login, password)
+	require.NoError(t, err)
+	return user
+}
+
+func signUpUser(login, password string) (user domain.User, err error) {
+	client := httpClient{}
+	body, _ := json.Marshal(domain.User{Login: login, Email: strings.ToLower(login) + "@example.com", Password: password})
+	resp, respBody, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/users", body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return user, fmt.Errorf("cannot create user: %d %s", resp.StatusCode, respBody)
+	}
+	if err = json.Unmarshal(respBody, &user); err != nil {
+		return
+	}
+
+	token, err := mailedToken(user.Email, verifyLink)
+	if err != nil {
+		return
+	}
+	body, _ = json.Marshal(domain.VerifyRequest{Token: token})
+	resp, respBody, err = client.sendJsonReq(http.MethodPost, fmt.Sprintf("http://localhost:8877/api/v1/users/%d/verify", user.Id), body)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot verify user: %d %s", resp.StatusCode, respBody)
+	}
+	user.Status = domain.UserActive
+	return
+}
+
+var (
+	verifyLink = regexp.MustCompile(`/verify\?user=\d+&token=([\w-]+)`)
+	resetLink  = regexp.MustCompile(`/reset-password\?token=([\w-]+)`)
+)
+
+// mailedToken extracts the token from the link of the last mail sent to the address.
+func mailedToken(to string, link *regexp.Regexp) (string, error) {
+	files, err := filepath.Glob(filepath.Join(mailDir, "*.eml"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files) // names start with the time they were sent
+	for i := len(files) - 1; i >= 0; i-- {
+		msg, err := os.ReadFile(files[i])
+		if err != nil {
+			return "", err
+		}
+		if !bytes.Contains(msg, []byte("\r\nTo: "+to+"\r\n")) {
+			continue
+		}
+		if m := link.FindSubmatch(msg); m != nil {
+			return string(m[1]), nil
+		}
+	}
+	return "", fmt.Errorf("no mail with the link for %s", to)
+}
+
 func startPostgreSQL(pool *dockertest.Pool, logger domain.Logger) string {
 	resource, err := pool.RunWithOptions(&dockertest.RunOptions{
 		Repository: "postgres",
@@ -204,6 +339,9 @@ func (cl *httpClient) sendJsonReq(method, url string, reqBody []byte) (resp *htt
 		return nil, nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if cl.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cl.token)
+	}
 
 	resp, err = cl.parent.Do(req)
 	if err != nil {
@@ -214,3 +352,17 @@ func (cl *httpClient) sendJsonReq(method, url string, reqBody []byte) (resp *htt
 	resBody, err = io.ReadAll(resp.Body)
 	return resp, resBody, err
 }
+
+// login authenticates the client, so the following requests carry its access token.
+func (cl *httpClient) login(t *testing.T, login, password string) domain.TokenPair {
+	body, err := json.Marshal(domain.Credentials{Login: login, Password: password})
+	require.NoError(t, err)
+	resp, respBody, err := cl.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	tokens := domain.TokenPair{}
+	require.NoError(t, json.Unmarshal(respBody, &tokens))
+	cl.token = tokens.AccessToken
+	return tokens
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+// oidcStateTTL is how long the user has to log in at the provider.
+const oidcStateTTL = 10 * time.Minute
+
+type OIDCAction struct {
+	oidcRepo *repo.OIDCRepo
+	userRepo *repo.UserRepo
+	oidc     *service.OIDC
+	auth     *AuthAction
+	logger   domain.Logger
+}
+
+func NewOIDCAction(
+	oidcRepo *repo.OIDCRepo,
+	userRepo *repo.UserRepo,
+	oidc *service.OIDC,
+	auth *AuthAction,
+	logger domain.Logger,
+) *OIDCAction {
+	return &OIDCAction{oidcRepo, userRepo, oidc, auth, logger}
+}
+
+// Login redirects the user to the provider.
+func (s *OIDCAction) Login(c echo.Context) (err error) {
+	if !s.oidc.Enabled() {
+		return domain.ErrOIDCDisabled
+	}
+
+	var st domain.OIDCState
+	state, hash, err := service.NewSecret()
+	if err == nil {
+		st.Nonce, _, err = service.NewSecret()
+	}
+	if err == nil {
+		st.CodeVerifier, _, err = service.NewSecret()
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate OIDC state", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	authURL, err := s.oidc.AuthURL(state, st.Nonce, st.CodeVerifier)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot build OIDC authorization URL", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadGateway, "identity provider is unavailable")
+	}
+
+	st.Hash = hash
+	st.ExpiresAt = time.Now().Add(oidcStateTTL)
+	if err = s.oidcRepo.CreateState(&st); err != nil {
+		requestLogger(c, s.logger).Error("cannot store OIDC state", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the login at the provider. Identities are linked to the users
+// by email on the first login, only if the provider says the email is verified.
+func (s *OIDCAction) Callback(c echo.Context) (err error) {
+	if !s.oidc.Enabled() {
+		return domain.ErrOIDCDisabled
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, "identity provider refused the login: "+e)
+	}
+
+	st, err := s.oidcRepo.TakeState(service.HashToken(c.QueryParam("state")))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired state")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get OIDC state", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	claims, err := s.oidc.Exchange(c.QueryParam("code"), st.CodeVerifier, st.Nonce)
+	if err != nil {
+		requestLogger(c, s.logger).Warn("OIDC code exchange failed", zap.Error(err))
+		return echo.NewHTTPError(http.StatusUnauthorized, "cannot verify the identity")
+	}
+
+	var user domain.User
+	userId, err := s.oidcRepo.GetUserId(claims.Issuer, claims.Subject)
+	if err == nil {
+		user, err = s.userRepo.GetById(userId)
+	} else if errors.Is(err, domain.ErrNoRows) {
+		return s.link(c, claims)
+	}
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by identity", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return s.auth.completeLogin(c, user)
+}
+
+func (s *OIDCAction) link(c echo.Context, claims *domain.OIDCClaims) error {
+	if claims.Email == "" || !claims.EmailVerified {
+		return echo.NewHTTPError(http.StatusForbidden, "email is not verified by the identity provider")
+	}
+	user, err := s.userRepo.FindByEmail(claims.Email)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusForbidden, "no user with such email")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by email", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	// Disabled accounts get nothing linked or activated
+	if user.Status == domain.UserDisabled {
+		return echo.NewHTTPError(http.StatusForbidden, "account is disabled")
+	}
+
+	audit := NewAuditEntry(c, domain.AuditIdentityLink, "")
+	audit.ActorId = &user.Id
+	if err = s.oidcRepo.Link(user.Id, claims.Issuer, claims.Subject, audit); err != nil {
+		requestLogger(c, s.logger).Error("cannot link identity", zap.Int("userId", user.Id), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if user.Status == domain.UserPending {
+		user.Status = domain.UserActive
+	}
+	return s.auth.completeLogin(c, user)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/service/oidctest"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	// The client follows the redirects to the provider and back to the callback like a browser
+	client := httpClient{}
+	ivan := signUp(t, "Ivan", "ivans secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", ivan.Id), nil)
+
+	login := func(id oidctest.Identity) (int, domain.TokenPair) {
+		idp.SetIdentity(id)
+		resp, respBody, err := client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/auth/oidc/login", nil)
+		require.NoError(t, err)
+		tokens := domain.TokenPair{}
+		_ = json.Unmarshal(respBody, &tokens)
+		return resp.StatusCode, tokens
+	}
+
+	status, _ := login(oidctest.Identity{Subject: "ivan", Email: ivan.Email, EmailVerified: false})
+	require.Equal(t, http.StatusForbidden, status, "unverified emails aren't linked")
+	status, _ = login(oidctest.Identity{Subject: "nobody", Email: "nobody@example.com", EmailVerified: true})
+	require.Equal(t, http.StatusForbidden, status, "users aren't created")
+
+	status, tokens := login(oidctest.Identity{Subject: "ivan", Email: ivan.Email, EmailVerified: true})
+	require.Equal(t, http.StatusOK, status)
+	require.NotEmpty(t, tokens.AccessToken)
+
+	// the linked identity is found by the subject even if the email changes at the provider
+	status, tokens = login(oidctest.Identity{Subject: "ivan", Email: "ivan@corp.example.com"})
+	require.Equal(t, http.StatusOK, status)
+	client.token = tokens.AccessToken
+	resp, respBody, err := client.sendJsonReq(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", ivan.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, string(respBody), `"login":"Ivan"`)
+
+	client.token = ""
+	resp, _, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/auth/oidc/callback?code=x&state=forged", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// swaggerUI is the docs page with the Swagger UI assets of the swagger-ui-dist package,
+// they are built in by make swagger-ui, so the page needs no CDN.
+//
+//go:embed swagger-ui
+var swaggerUI embed.FS
+
+// LoadOpenAPI parses and validates the OpenAPI document of the API.
+func LoadOpenAPI() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openAPISpec)
+	if err != nil {
+		return nil, err
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// OpenAPIAction serves the OpenAPI document and its docs page.
+type OpenAPIAction struct {
+	doc    *openapi3.T
+	spec   []byte
+	assets fs.FS
+}
+
+func NewOpenAPIAction() (*OpenAPIAction, error) {
+	doc, err := LoadOpenAPI()
+	if err != nil {
+		return nil, err
+	}
+	spec, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := fs.Sub(swaggerUI, "swagger-ui")
+	if err != nil {
+		return nil, err
+	}
+	return &OpenAPIAction{doc, spec, assets}, nil
+}
+
+// Doc is the document the requests are validated against.
+func (s *OpenAPIAction) Doc() *openapi3.T {
+	return s.doc
+}
+
+func (s *OpenAPIAction) Spec(c echo.Context) error {
+	return c.JSONBlob(http.StatusOK, s.spec)
+}
+
+func (s *OpenAPIAction) Docs(c echo.Context) error {
+	if _, err := fs.Stat(s.assets, "swagger-ui-bundle.js"); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Swagger UI isn't built in, run make swagger-ui")
+	}
+	return echo.StaticFileHandler("index.html", s.assets)(c)
+}
+
+// DocsAsset serves a Swagger UI asset of the docs page.
+func (s *OpenAPIAction) DocsAsset(c echo.Context) error {
+	return echo.StaticFileHandler(c.Param("asset"), s.assets)(c)
+}
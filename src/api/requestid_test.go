@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestRequestId(t *testing.T) {
+	get := func(id string) (*http.Response, domain.Problem) {
+		req, err := http.NewRequest(http.MethodGet, "http://localhost:8877/api/v1/users", nil)
+		require.NoError(t, err)
+		if id != "" {
+			req.Header.Set(domain.RequestIdHeader, id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		p := domain.Problem{}
+		require.NoError(t, json.Unmarshal(body, &p))
+		return resp, p
+	}
+
+	// the ID of the caller is echoed in the response and its errors
+	resp, p := get("c0ffee-42")
+	require.Equal(t, "c0ffee-42", resp.Header.Get(domain.RequestIdHeader))
+	require.Equal(t, "c0ffee-42", p.RequestId)
+
+	// missing and unsafe ones are replaced
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	for _, id := range []string{"", "bad id\twith spaces"} {
+		resp, p = get(id)
+		require.Regexp(t, generated, resp.Header.Get(domain.RequestIdHeader))
+		require.Equal(t, resp.Header.Get(domain.RequestIdHeader), p.RequestId)
+	}
+}
+
+func TestRequestIdInKafka(t *testing.T) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers": kafkaBroker,
+		"group.id":          "gonah-test-request-id",
+		"auto.offset.reset": "earliest",
+	})
+	require.NoError(t, err)
+	defer consumer.Close()
+	require.NoError(t, consumer.SubscribeTopics([]string{"users"}, nil))
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8877/api/v1/users", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+admin.token)
+	req.Header.Set(domain.RequestIdHeader, "users-listed-1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		msg, err := consumer.ReadMessage(time.Second)
+		if err != nil {
+			continue
+		}
+		for _, h := range msg.Headers {
+			if h.Key == domain.RequestIdHeader && string(h.Value) == "users-listed-1" {
+				return
+			}
+		}
+	}
+	t.Fatal("no message with the request ID has been produced")
+}
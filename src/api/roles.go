@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+)
+
+type RolesAction struct {
+	roleRepo *repo.RoleRepo
+	userRepo *repo.UserRepo
+	logger   domain.Logger
+}
+
+func NewRolesAction(roleRepo *repo.RoleRepo, userRepo *repo.UserRepo, logger domain.Logger) *RolesAction {
+	return &RolesAction{roleRepo, userRepo, logger}
+}
+
+func (s *RolesAction) GetAll(c echo.Context) (err error) {
+	roles, err := s.roleRepo.GetAll()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get roles", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (s *RolesAction) GetByUser(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+
+	roles, err := s.roleRepo.GetByUser(id)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user roles", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+func (s *RolesAction) Grant(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	role := c.Param("role")
+
+	_, err = s.userRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	err = s.roleRepo.Grant(id, role, NewAuditEntry(c, domain.AuditRoleGrant, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "role not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot grant role", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+func (s *RolesAction) Revoke(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	role := c.Param("role")
+
+	err = s.roleRepo.Revoke(id, role, NewAuditEntry(c, domain.AuditRoleRevoke, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "role is not assigned")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot revoke role", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
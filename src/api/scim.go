@@ -0,0 +1,320 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 500
+)
+
+// scimFilter is the only filter form supported: attribute eq "value".
+var scimFilter = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// SCIMAction provisions users and groups for the IdP, see RFC 7644.
+// Users and groups are the ones of the REST API, ids are the same.
+type SCIMAction struct {
+	userRepo  *repo.UserRepo
+	groupRepo *repo.GroupRepo
+	events    *service.Events
+	logger    domain.Logger
+}
+
+func NewSCIMAction(userRepo *repo.UserRepo, groupRepo *repo.GroupRepo, events *service.Events, logger domain.Logger) *SCIMAction {
+	return &SCIMAction{userRepo, groupRepo, events, logger}
+}
+
+func (s *SCIMAction) ServiceProviderConfig(c echo.Context) error {
+	supported := func(v bool) map[string]interface{} {
+		return map[string]interface{}{"supported": v}
+	}
+	return scimJSON(c, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{domain.SCIMSchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": supported(false),
+		"sort":           supported(false),
+		"etag":           supported(true),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "API key",
+			"description": "API key with the scim:provision scope sent as a bearer token",
+		}},
+	})
+}
+
+// SCIMError responds with the SCIM error message, it's also used for the auth errors of the SCIM routes.
+func SCIMError(c echo.Context, status int, detail string) error {
+	return scimError(c, status, "", detail)
+}
+
+func scimError(c echo.Context, status int, scimType, detail string) error {
+	return scimJSON(c, status, domain.SCIMError{
+		Schemas:  []string{domain.SCIMSchemaError},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
+
+// scimFail maps the repo errors, unexpected ones are logged.
+func (s *SCIMAction) scimFail(c echo.Context, err error, msg string) error {
+	switch {
+	case errors.Is(err, domain.ErrNoRows):
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	case errors.Is(err, domain.ErrConflict):
+		return scimError(c, http.StatusConflict, domain.SCIMUniqueness, "resource with such name already exists")
+	case errors.Is(err, domain.ErrVersionMismatch):
+		return scimError(c, http.StatusPreconditionFailed, "", "resource has been modified")
+	case errors.Is(err, domain.ErrUnknownMember):
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, "unknown group member")
+	case errors.Is(err, domain.ErrLastOwner):
+		return scimError(c, http.StatusConflict, "", "the group must keep an owner")
+	}
+	requestLogger(c, s.logger).Error(msg, zap.Error(err))
+	return scimError(c, http.StatusInternalServerError, "", "Internal Server Error")
+}
+
+func scimJSON(c echo.Context, status int, v interface{}) error {
+	c.Response().Header().Set(echo.HeaderContentType, domain.SCIMContentType)
+	return c.JSON(status, v)
+}
+
+// scimResource responds with the resource and its ETag, or with 304 if the client has it already.
+func scimResource(c echo.Context, status int, v interface{}, meta *domain.SCIMMeta) error {
+	c.Response().Header().Set("ETag", meta.Version)
+	if status == http.StatusCreated {
+		c.Response().Header().Set(echo.HeaderLocation, meta.Location)
+	}
+	if c.Request().Method == http.MethodGet && c.Request().Header.Get("If-None-Match") == meta.Version {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return scimJSON(c, status, v)
+}
+
+// bindSCIM decodes the body, echo's binder doesn't know the SCIM content type.
+func bindSCIM(c echo.Context, v interface{}) error {
+	if err := json.NewDecoder(c.Request().Body).Decode(v); err != nil {
+		return &scimPatchError{domain.SCIMInvalidSyntax, "malformed JSON body"}
+	}
+	return nil
+}
+
+func scimETag(version int) string {
+	return fmt.Sprintf(`W/"%d"`, version)
+}
+
+// ifMatch returns the version from the If-Match header, zero means any version.
+// Versions which can't be ours are returned as -1, so they never match.
+func ifMatch(c echo.Context) int {
+	h := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return 0
+	}
+	v, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(h, "W/"), `"`))
+	if err != nil || v < 1 {
+		return -1
+	}
+	return v
+}
+
+// staleTries bounds the reads and writes of a resource other requests keep changing in between.
+const staleTries = 3
+
+// errStale is returned by the writes of the resources changed since the request has read them.
+var errStale = errors.New("changed since read")
+
+// writeVersion is the version the write is done against: the If-Match one, or the one read
+// by the request, so the changes of other requests in between aren't lost.
+func writeVersion(c echo.Context, read int) int {
+	if v := ifMatch(c); v != 0 {
+		return v
+	}
+	return read
+}
+
+// stale tells whether the write failed only because another request changed the resource after
+// it was read. Without If-Match the client didn't ask for a version, so the change is done again.
+func stale(c echo.Context, err error) bool {
+	return errors.Is(err, domain.ErrVersionMismatch) && ifMatch(c) == 0
+}
+
+// retryStale runs the read, change and write of a resource again while it turns out stale.
+func (s *SCIMAction) retryStale(c echo.Context, readWrite func() error) error {
+	for i := 0; i < staleTries; i++ {
+		if err := readWrite(); err != errStale {
+			return err
+		}
+	}
+	return scimError(c, http.StatusConflict, "", "the resource is being changed by other requests")
+}
+
+func scimLocation(c echo.Context, resource string, id int) string {
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%d", c.Scheme(), c.Request().Host, resource, id)
+}
+
+// scimPage reads startIndex and count, startIndex is 1-based.
+func scimPage(c echo.Context) (offset, limit int, err error) {
+	start, count := 1, scimDefaultCount
+	err = echo.QueryParamsBinder(c).Int("startIndex", &start).Int("count", &count).BindError()
+	if err != nil {
+		return 0, 0, err
+	}
+	if start < 1 {
+		start = 1
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	return start - 1, count, nil
+}
+
+// parseFilter sets the value of the filtered attribute, attribute names are case-insensitive.
+func parseFilter(filter string, attrs map[string]*string) error {
+	if filter == "" {
+		return nil
+	}
+	m := scimFilter.FindStringSubmatch(filter)
+	if m == nil {
+		return errors.New(`only filters like attribute eq "value" are supported`)
+	}
+	attr, ok := attrs[strings.ToLower(m[1])]
+	if !ok {
+		return fmt.Errorf("filtering by %s is not supported", m[1])
+	}
+	value, err := strconv.Unquote(m[2])
+	if err != nil {
+		return err
+	}
+	*attr = value
+	return nil
+}
+
+func scimList(c echo.Context, resources interface{}, total, offset, qnt int) error {
+	return scimJSON(c, http.StatusOK, domain.SCIMListResponse{
+		Schemas:      []string{domain.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   offset + 1,
+		ItemsPerPage: qnt,
+		Resources:    resources,
+	})
+}
+
+func scimId(c echo.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	return id, err == nil
+}
+
+// scimPatchError is a request the resource can't take, the PATCH ones mostly.
+type scimPatchError struct {
+	scimType string
+	detail   string
+}
+
+func (e *scimPatchError) Error() string {
+	return e.detail
+}
+
+func invalidPath(path string) error {
+	return &scimPatchError{domain.SCIMInvalidPath, "unsupported attribute path: " + path}
+}
+
+func invalidValue(path string) error {
+	return &scimPatchError{domain.SCIMInvalidValue, "invalid value of " + path}
+}
+
+// decodePatch validates the PATCH request, op names are lowercased.
+func decodePatch(c echo.Context) (*domain.SCIMPatch, error) {
+	patch := &domain.SCIMPatch{}
+	if err := bindSCIM(c, patch); err != nil {
+		return nil, err
+	}
+	if len(patch.Operations) == 0 {
+		return nil, &scimPatchError{domain.SCIMInvalidSyntax, "no operations"}
+	}
+	for i, op := range patch.Operations {
+		op.Op = strings.ToLower(op.Op)
+		switch {
+		case op.Op != "add" && op.Op != "replace" && op.Op != "remove":
+			return nil, &scimPatchError{domain.SCIMInvalidSyntax, "unknown operation " + op.Op}
+		case op.Op == "remove" && op.Path == "":
+			return nil, &scimPatchError{domain.SCIMNoTarget, "remove requires a path"}
+		case op.Op != "remove" && len(op.Value) == 0:
+			return nil, &scimPatchError{domain.SCIMInvalidValue, op.Op + " requires a value"}
+		}
+		patch.Operations[i] = op
+	}
+	return patch, nil
+}
+
+// applyPatch runs the operations, ones without a path are split into an operation per attribute.
+func applyPatch(ops []domain.SCIMPatchOp, apply func(op, path string, value json.RawMessage) error) error {
+	for _, op := range ops {
+		if op.Path != "" {
+			if err := apply(op.Op, op.Path, op.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return invalidValue("the operation")
+		}
+		for path, value := range attrs {
+			if err := apply(op.Op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// badRequest responds with the scimType of the error.
+func badRequest(c echo.Context, err error) error {
+	var pe *scimPatchError
+	if errors.As(err, &pe) {
+		return scimError(c, http.StatusBadRequest, pe.scimType, pe.detail)
+	}
+	return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, err.Error())
+}
+
+func unmarshalString(value json.RawMessage, path string) (string, error) {
+	var v string
+	if err := json.Unmarshal(value, &v); err != nil {
+		return "", invalidValue(path)
+	}
+	return v, nil
+}
+
+// unmarshalBool also takes "True" and "False", some IdPs send booleans as strings.
+func unmarshalBool(value json.RawMessage, path string) (bool, error) {
+	var v bool
+	if err := json.Unmarshal(value, &v); err == nil {
+		return v, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if v, err = strconv.ParseBool(s); err == nil {
+			return v, nil
+		}
+	}
+	return false, invalidValue(path)
+}
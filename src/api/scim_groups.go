@@ -0,0 +1,283 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// scimMemberPath selects a member to remove: members[value eq "42"].
+var scimMemberPath = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+"(\d+)"\s*\]$`)
+
+// GetGroups lists the groups without members, the only filters supported are displayName eq and externalId eq.
+func (s *SCIMAction) GetGroups(c echo.Context) error {
+	offset, limit, err := scimPage(c)
+	if err != nil {
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, "wrong startIndex or count")
+	}
+	var f domain.GroupFilter
+	err = parseFilter(c.QueryParam("filter"), map[string]*string{"displayname": &f.Name, "externalid": &f.ExternalId})
+	if err != nil {
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidFilter, err.Error())
+	}
+
+	groups, total, err := s.groupRepo.Find(f, offset, limit)
+	if err != nil {
+		return s.scimFail(c, err, "cannot find groups")
+	}
+	ret := make([]domain.SCIMGroup, 0, len(groups))
+	for _, g := range groups {
+		ret = append(ret, scimGroup(c, g, nil))
+	}
+	return scimList(c, ret, total, offset, len(ret))
+}
+
+func (s *SCIMAction) GetGroup(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	group, err := s.groupRepo.GetById(id)
+	if err != nil {
+		return s.scimFail(c, err, "cannot get group by ID")
+	}
+	return s.respondGroup(c, http.StatusOK, group)
+}
+
+func (s *SCIMAction) CreateGroup(c echo.Context) error {
+	sg := &domain.SCIMGroup{}
+	if err := bindSCIM(c, sg); err != nil {
+		return badRequest(c, err)
+	}
+	var group domain.Group
+	ids, err := fromSCIMGroup(sg, &group)
+	if err != nil {
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, err.Error())
+	}
+	members := make([]domain.Membership, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, domain.Membership{UserId: id, Role: domain.GroupMember})
+	}
+
+	if err = s.groupRepo.Create(&group, members, NewAuditEntry(c, domain.AuditGroupCreate, "")); err != nil {
+		return s.scimFail(c, err, "cannot create group")
+	}
+	for _, m := range members {
+		publishMembership(s.events, c, domain.EventMemberAdded, group.Id, m.UserId, m.Role)
+	}
+	return s.respondGroup(c, http.StatusCreated, group)
+}
+
+// ReplaceGroup is PUT, the members are replaced too.
+func (s *SCIMAction) ReplaceGroup(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	sg := &domain.SCIMGroup{}
+	if err := bindSCIM(c, sg); err != nil {
+		return badRequest(c, err)
+	}
+	return s.retryStale(c, func() error {
+		// The description isn't a SCIM attribute, it's kept
+		group, err := s.groupRepo.GetById(id)
+		if err != nil {
+			return s.scimFail(c, err, "cannot get group by ID")
+		}
+		members, err := fromSCIMGroup(sg, &group)
+		if err != nil {
+			return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, err.Error())
+		}
+		return s.updateGroup(c, group, members)
+	})
+}
+
+func (s *SCIMAction) PatchGroup(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	patch, err := decodePatch(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+	return s.retryStale(c, func() error {
+		group, err := s.groupRepo.GetById(id)
+		if err != nil {
+			return s.scimFail(c, err, "cannot get group by ID")
+		}
+		current, err := s.groupRepo.Members(id)
+		if err != nil {
+			return s.scimFail(c, err, "cannot get group members")
+		}
+		members := make([]int, 0, len(current))
+		for _, m := range current {
+			members = append(members, m.UserId)
+		}
+
+		if err = applyPatch(patch.Operations, patchGroup(&group, &members)); err != nil {
+			return badRequest(c, err)
+		}
+		if strings.TrimSpace(group.Name) == "" {
+			return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, "displayName is required")
+		}
+		return s.updateGroup(c, group, members)
+	})
+}
+
+func (s *SCIMAction) DeleteGroup(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	members, err := s.groupRepo.Delete(id, ifMatch(c), NewAuditEntry(c, domain.AuditGroupDelete, ""))
+	if err != nil {
+		return s.scimFail(c, err, "cannot delete group")
+	}
+	for _, userId := range members {
+		publishMembership(s.events, c, domain.EventMemberRemoved, id, userId, "")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// updateGroup writes the group read by the request, errStale is returned if it has changed since.
+func (s *SCIMAction) updateGroup(c echo.Context, group domain.Group, members []int) error {
+	version := writeVersion(c, group.Version)
+	added, removed, err := s.groupRepo.Update(&group, members, version, NewAuditEntry(c, domain.AuditGroupUpdate, ""))
+	if stale(c, err) {
+		return errStale
+	}
+	if err != nil {
+		return s.scimFail(c, err, "cannot update group")
+	}
+	for _, id := range added {
+		publishMembership(s.events, c, domain.EventMemberAdded, group.Id, id, domain.GroupMember)
+	}
+	for _, id := range removed {
+		publishMembership(s.events, c, domain.EventMemberRemoved, group.Id, id, "")
+	}
+	return s.respondGroup(c, http.StatusOK, group)
+}
+
+func (s *SCIMAction) respondGroup(c echo.Context, status int, group domain.Group) error {
+	members, err := s.groupRepo.Members(group.Id)
+	if err != nil {
+		return s.scimFail(c, err, "cannot get group members")
+	}
+	sg := scimGroup(c, group, members)
+	return scimResource(c, status, sg, sg.Meta)
+}
+
+func scimGroup(c echo.Context, g domain.Group, members []domain.Membership) domain.SCIMGroup {
+	sg := domain.SCIMGroup{
+		Schemas:     []string{domain.SCIMSchemaGroup},
+		Id:          strconv.Itoa(g.Id),
+		ExternalId:  g.ExternalId,
+		DisplayName: g.Name,
+		Meta: &domain.SCIMMeta{
+			ResourceType: "Group",
+			Created:      g.CreatedAt,
+			LastModified: g.UpdatedAt,
+			Location:     scimLocation(c, "Groups", g.Id),
+			Version:      scimETag(g.Version),
+		},
+	}
+	for _, m := range members {
+		sg.Members = append(sg.Members, domain.SCIMMember{
+			Value:   strconv.Itoa(m.UserId),
+			Display: m.Login,
+			Ref:     scimLocation(c, "Users", m.UserId),
+		})
+	}
+	return sg
+}
+
+func fromSCIMGroup(sg *domain.SCIMGroup, g *domain.Group) ([]int, error) {
+	if strings.TrimSpace(sg.DisplayName) == "" {
+		return nil, errors.New("displayName is required")
+	}
+	g.Name = sg.DisplayName
+	g.ExternalId = sg.ExternalId
+	return memberIds(sg.Members, "members")
+}
+
+func memberIds(members []domain.SCIMMember, path string) ([]int, error) {
+	ret := make([]int, 0, len(members))
+	for _, m := range members {
+		id, err := strconv.Atoi(m.Value)
+		if err != nil {
+			return nil, invalidValue(path)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+func patchGroup(g *domain.Group, members *[]int) func(op, path string, value json.RawMessage) error {
+	return func(op, path string, value json.RawMessage) (err error) {
+		switch p := strings.ToLower(path); {
+		case p == "displayname":
+			if op == "remove" {
+				return &scimPatchError{domain.SCIMMutability, "displayName is required"}
+			}
+			g.Name, err = unmarshalString(value, path)
+		case p == "externalid":
+			if op == "remove" {
+				g.ExternalId = ""
+				return nil
+			}
+			g.ExternalId, err = unmarshalString(value, path)
+		case p == "members":
+			var ids []int
+			if len(value) > 0 {
+				var ms []domain.SCIMMember
+				if json.Unmarshal(value, &ms) != nil {
+					return invalidValue(path)
+				}
+				if ids, err = memberIds(ms, path); err != nil {
+					return err
+				}
+			}
+			switch {
+			case op == "add":
+				*members = append(*members, ids...)
+			case op == "replace":
+				*members = ids
+			case len(value) == 0:
+				*members = []int{}
+			default:
+				*members = withoutInts(*members, ids)
+			}
+		case scimMemberPath.MatchString(path):
+			if op != "remove" {
+				return invalidPath(path)
+			}
+			id, _ := strconv.Atoi(scimMemberPath.FindStringSubmatch(path)[1])
+			*members = withoutInts(*members, []int{id})
+		default:
+			return invalidPath(path)
+		}
+		return
+	}
+}
+
+func withoutInts(vs, remove []int) []int {
+	skip := make(map[int]struct{}, len(remove))
+	for _, v := range remove {
+		skip[v] = struct{}{}
+	}
+	ret := make([]int, 0, len(vs))
+	for _, v := range vs {
+		if _, ok := skip[v]; !ok {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestSCIM(t *testing.T) {
+	body, err := json.Marshal(domain.ApiKey{Name: "idp", Scopes: []string{domain.PermSCIMProvision}})
+	require.NoError(t, err)
+	resp, respBody, err := admin.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/api-keys", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	key := domain.ApiKey{}
+	require.NoError(t, json.Unmarshal(respBody, &key))
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/api-keys/%d", key.Id), nil)
+
+	// scim sends the request with the IdP key, the If-Match header is set if etag isn't empty
+	scim := func(method, path string, v interface{}, etag string) (*http.Response, []byte) {
+		var body []byte
+		if v != nil {
+			var err error
+			body, err = json.Marshal(v)
+			require.NoError(t, err)
+		}
+		req, err := http.NewRequest(method, "http://localhost:8877/scim/v2"+path, bytes.NewBuffer(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", domain.SCIMContentType)
+		req.Header.Set("Authorization", "Bearer "+key.Key)
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, respBody
+	}
+
+	anonymous := httpClient{}
+	resp, respBody, err = anonymous.sendJsonReq(http.MethodGet, "http://localhost:8877/scim/v2/Users", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Equal(t, domain.SCIMContentType, resp.Header.Get("Content-Type"))
+	var scimErr domain.SCIMError
+	require.NoError(t, json.Unmarshal(respBody, &scimErr))
+	require.Equal(t, "401", scimErr.Status)
+
+	active := true
+	oscar := domain.SCIMUser{
+		Schemas:    []string{domain.SCIMSchemaUser},
+		ExternalId: "00u1oscar",
+		UserName:   "Oscar",
+		Active:     &active,
+		Emails:     []domain.SCIMEmail{{Value: "oscar@example.com", Type: "work", Primary: true}},
+	}
+	resp, respBody = scim(http.MethodPost, "/Users", oscar, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(respBody, &oscar))
+	require.NotEmpty(t, resp.Header.Get("Location"))
+	require.Equal(t, oscar.Meta.Version, resp.Header.Get("ETag"))
+	defer scim(http.MethodDelete, "/Users/"+oscar.Id, nil, "")
+
+	resp, respBody = scim(http.MethodPost, "/Users", oscar, "")
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(respBody, &scimErr))
+	require.Equal(t, domain.SCIMUniqueness, scimErr.SCIMType)
+
+	resp, respBody = scim(http.MethodGet, "/Users?filter="+url.QueryEscape(`userName eq "oscar"`), nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	list := struct {
+		TotalResults int               `json:"totalResults"`
+		Resources    []domain.SCIMUser `json:"Resources"`
+	}{}
+	require.NoError(t, json.Unmarshal(respBody, &list))
+	require.Equal(t, 1, list.TotalResults)
+	require.Equal(t, oscar.Id, list.Resources[0].Id)
+
+	resp, respBody = scim(http.MethodGet, "/Users?filter="+url.QueryEscape(`emails co "example"`), nil, "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(respBody, &scimErr))
+	require.Equal(t, domain.SCIMInvalidFilter, scimErr.SCIMType)
+
+	req, err := http.NewRequest(http.MethodGet, "http://localhost:8877/scim/v2/Users/"+oscar.Id, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	req.Header.Set("If-None-Match", oscar.Meta.Version)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// deprovisioning, Azure AD style
+	deactivate := domain.SCIMPatch{
+		Schemas:    []string{domain.SCIMSchemaPatchOp},
+		Operations: []domain.SCIMPatchOp{{Op: "Replace", Path: "active", Value: json.RawMessage(`"False"`)}},
+	}
+	resp, respBody = scim(http.MethodPatch, "/Users/"+oscar.Id, deactivate, oscar.Meta.Version)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var patched domain.SCIMUser
+	require.NoError(t, json.Unmarshal(respBody, &patched))
+	require.False(t, *patched.Active)
+	require.NotEqual(t, oscar.Meta.Version, patched.Meta.Version)
+	resp, _ = scim(http.MethodPatch, "/Users/"+oscar.Id, deactivate, oscar.Meta.Version)
+	require.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	group := domain.SCIMGroup{
+		Schemas:     []string{domain.SCIMSchemaGroup},
+		DisplayName: "Engineering",
+		Members:     []domain.SCIMMember{{Value: oscar.Id}},
+	}
+	resp, respBody = scim(http.MethodPost, "/Groups", group, "")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(respBody, &group))
+	require.Len(t, group.Members, 1)
+	defer scim(http.MethodDelete, "/Groups/"+group.Id, nil, "")
+
+	resp, respBody = scim(http.MethodGet, "/Users/"+oscar.Id, nil, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(respBody, &patched))
+	require.Len(t, patched.Groups, 1)
+	require.Equal(t, group.Id, patched.Groups[0].Value)
+
+	resp, respBody = scim(http.MethodPatch, "/Groups/"+group.Id, domain.SCIMPatch{
+		Schemas: []string{domain.SCIMSchemaPatchOp},
+		Operations: []domain.SCIMPatchOp{
+			{Op: "remove", Path: fmt.Sprintf(`members[value eq "%s"]`, oscar.Id)},
+			{Op: "replace", Value: json.RawMessage(`{"displayName": "Platform"}`)},
+		},
+	}, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	group = domain.SCIMGroup{}
+	require.NoError(t, json.Unmarshal(respBody, &group))
+	require.Equal(t, "Platform", group.DisplayName)
+	require.Empty(t, group.Members)
+
+	resp, respBody = scim(http.MethodPatch, "/Groups/"+group.Id, domain.SCIMPatch{
+		Schemas:    []string{domain.SCIMSchemaPatchOp},
+		Operations: []domain.SCIMPatchOp{{Op: "add", Path: "members", Value: json.RawMessage(`[{"value": "999999"}]`)}},
+	}, "")
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.NoError(t, json.Unmarshal(respBody, &scimErr))
+	require.Equal(t, domain.SCIMInvalidValue, scimErr.SCIMType)
+
+	resp, _ = scim(http.MethodDelete, "/Groups/"+group.Id, nil, "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// groups created by users keep an owner
+	body, err = json.Marshal(domain.Group{Name: "Owned"})
+	require.NoError(t, err)
+	resp, respBody, err = admin.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/groups", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	owned := domain.Group{}
+	require.NoError(t, json.Unmarshal(respBody, &owned))
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/groups/%d", owned.Id), nil)
+	resp, _ = scim(http.MethodPatch, fmt.Sprintf("/Groups/%d", owned.Id), domain.SCIMPatch{
+		Schemas: []string{domain.SCIMSchemaPatchOp},
+		Operations: []domain.SCIMPatchOp{
+			{Op: "replace", Path: "members", Value: json.RawMessage(fmt.Sprintf(`[{"value": "%s"}]`, oscar.Id))},
+		},
+	}, "")
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, _ = scim(http.MethodDelete, "/Users/"+oscar.Id, nil, "")
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = scim(http.MethodGet, "/Users/"+oscar.Id, nil, "")
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// GetUsers lists the users, the only filters supported are userName eq and externalId eq.
+func (s *SCIMAction) GetUsers(c echo.Context) error {
+	offset, limit, err := scimPage(c)
+	if err != nil {
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, "wrong startIndex or count")
+	}
+	var f domain.UserFilter
+	err = parseFilter(c.QueryParam("filter"), map[string]*string{"username": &f.Login, "externalid": &f.ExternalId})
+	if err != nil {
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidFilter, err.Error())
+	}
+
+	users, total, err := s.userRepo.Find(f, offset, limit)
+	if err != nil {
+		return s.scimFail(c, err, "cannot find users")
+	}
+	ret := make([]domain.SCIMUser, 0, len(users))
+	for _, u := range users {
+		ret = append(ret, scimUser(c, u, nil))
+	}
+	return scimList(c, ret, total, offset, len(ret))
+}
+
+func (s *SCIMAction) GetUser(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	user, err := s.userRepo.GetById(id)
+	if err != nil {
+		return s.scimFail(c, err, "cannot get user by ID")
+	}
+	return s.respondUser(c, http.StatusOK, user)
+}
+
+// CreateUser provisions an active user without a password, the user logs in with the IdP.
+func (s *SCIMAction) CreateUser(c echo.Context) error {
+	su := &domain.SCIMUser{}
+	if err := bindSCIM(c, su); err != nil {
+		return badRequest(c, err)
+	}
+	user := domain.User{Status: domain.UserActive}
+	if err := fromSCIMUser(su, &user); err != nil {
+		return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, err.Error())
+	}
+
+	if err := s.userRepo.Create(&user, NewAuditEntry(c, domain.AuditUserCreate, "")); err != nil {
+		return s.scimFail(c, err, "cannot create user")
+	}
+	return s.respondUser(c, http.StatusCreated, user)
+}
+
+// ReplaceUser is PUT, active is kept as is if it's omitted.
+func (s *SCIMAction) ReplaceUser(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	su := &domain.SCIMUser{}
+	if err := bindSCIM(c, su); err != nil {
+		return badRequest(c, err)
+	}
+	return s.retryStale(c, func() error {
+		user, err := s.userRepo.GetById(id)
+		if err != nil {
+			return s.scimFail(c, err, "cannot get user by ID")
+		}
+		if err = fromSCIMUser(su, &user); err != nil {
+			return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, err.Error())
+		}
+		return s.updateUser(c, user)
+	})
+}
+
+func (s *SCIMAction) PatchUser(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	patch, err := decodePatch(c)
+	if err != nil {
+		return badRequest(c, err)
+	}
+	return s.retryStale(c, func() error {
+		user, err := s.userRepo.GetById(id)
+		if err != nil {
+			return s.scimFail(c, err, "cannot get user by ID")
+		}
+		if err = applyPatch(patch.Operations, patchUser(&user)); err != nil {
+			return badRequest(c, err)
+		}
+		if err = validateUser(&user); err != nil {
+			return scimError(c, http.StatusBadRequest, domain.SCIMInvalidValue, err.Error())
+		}
+		return s.updateUser(c, user)
+	})
+}
+
+// DeleteUser deletes the user for good, IdPs which only deactivate users PATCH active instead.
+func (s *SCIMAction) DeleteUser(c echo.Context) error {
+	id, ok := scimId(c)
+	if !ok {
+		return scimError(c, http.StatusNotFound, "", "resource not found")
+	}
+	if err := s.userRepo.Delete(id, ifMatch(c), NewAuditEntry(c, domain.AuditUserDelete, "")); err != nil {
+		return s.scimFail(c, err, "cannot delete user")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// updateUser writes the user read by the request, errStale is returned if it has changed since.
+func (s *SCIMAction) updateUser(c echo.Context, user domain.User) error {
+	err := s.userRepo.Update(&user, writeVersion(c, user.Version), NewAuditEntry(c, domain.AuditUserUpdate, ""))
+	if stale(c, err) {
+		return errStale
+	}
+	if err != nil {
+		return s.scimFail(c, err, "cannot update user")
+	}
+	return s.respondUser(c, http.StatusOK, user)
+}
+
+func (s *SCIMAction) respondUser(c echo.Context, status int, user domain.User) error {
+	groups, err := s.groupRepo.GetByUser(user.Id)
+	if err != nil {
+		return s.scimFail(c, err, "cannot get user groups")
+	}
+	su := scimUser(c, user, groups)
+	return scimResource(c, status, su, su.Meta)
+}
+
+func scimUser(c echo.Context, u domain.User, groups []domain.Group) domain.SCIMUser {
+	active := u.Status == domain.UserActive
+	su := domain.SCIMUser{
+		Schemas:    []string{domain.SCIMSchemaUser},
+		Id:         strconv.Itoa(u.Id),
+		ExternalId: u.ExternalId,
+		UserName:   u.Login,
+		Active:     &active,
+		Meta: &domain.SCIMMeta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			LastModified: u.UpdatedAt,
+			Location:     scimLocation(c, "Users", u.Id),
+			Version:      scimETag(u.Version),
+		},
+	}
+	if u.Email != "" {
+		su.Emails = []domain.SCIMEmail{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	for _, g := range groups {
+		su.Groups = append(su.Groups, domain.SCIMMember{
+			Value:   strconv.Itoa(g.Id),
+			Display: g.Name,
+			Ref:     scimLocation(c, "Groups", g.Id),
+		})
+	}
+	return su
+}
+
+func fromSCIMUser(su *domain.SCIMUser, u *domain.User) error {
+	u.Login = su.UserName
+	u.ExternalId = su.ExternalId
+	u.Email = primaryEmail(su.Emails)
+	if su.Active != nil {
+		u.Status = userStatus(*su.Active)
+	}
+	return validateUser(u)
+}
+
+func validateUser(u *domain.User) error {
+	if strings.TrimSpace(u.Login) == "" {
+		return errors.New("userName is required")
+	}
+	if u.Email != "" {
+		if _, err := mail.ParseAddress(u.Email); err != nil {
+			return errors.New("invalid email")
+		}
+	}
+	return nil
+}
+
+// primaryEmail picks the primary email or the first one, users have only one.
+func primaryEmail(emails []domain.SCIMEmail) string {
+	for _, e := range emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// userStatus maps active, inactive users are disabled even if their email isn't verified yet.
+func userStatus(active bool) string {
+	if active {
+		return domain.UserActive
+	}
+	return domain.UserDisabled
+}
+
+func patchUser(u *domain.User) func(op, path string, value json.RawMessage) error {
+	return func(op, path string, value json.RawMessage) (err error) {
+		switch p := strings.ToLower(path); {
+		case p == "username":
+			if op == "remove" {
+				return &scimPatchError{domain.SCIMMutability, "userName is required"}
+			}
+			u.Login, err = unmarshalString(value, path)
+		case p == "externalid":
+			if op == "remove" {
+				u.ExternalId = ""
+				return nil
+			}
+			u.ExternalId, err = unmarshalString(value, path)
+		case p == "active":
+			if op == "remove" {
+				return &scimPatchError{domain.SCIMMutability, "active can't be removed"}
+			}
+			var active bool
+			active, err = unmarshalBool(value, path)
+			u.Status = userStatus(active)
+		case p == "emails":
+			if op == "remove" {
+				u.Email = ""
+				return nil
+			}
+			var emails []domain.SCIMEmail
+			if json.Unmarshal(value, &emails) != nil {
+				return invalidValue(path)
+			}
+			u.Email = primaryEmail(emails)
+		case strings.HasPrefix(p, "emails[") && strings.HasSuffix(p, "].value"):
+			// The only email is whatever type the IdP filters by
+			if op == "remove" {
+				u.Email = ""
+				return nil
+			}
+			u.Email, err = unmarshalString(value, path)
+		case p == "name" || strings.HasPrefix(p, "name.") || p == "displayname":
+			// Names aren't stored, IdPs send them anyway
+		default:
+			return invalidPath(path)
+		}
+		return
+	}
+}
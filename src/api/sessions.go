@@ -0,0 +1,122 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+)
+
+type SessionsAction struct {
+	sessionRepo *repo.SessionRepo
+	userRepo    *repo.UserRepo
+	logger      domain.Logger
+}
+
+func NewSessionsAction(sessionRepo *repo.SessionRepo, userRepo *repo.UserRepo, logger domain.Logger) *SessionsAction {
+	return &SessionsAction{sessionRepo, userRepo, logger}
+}
+
+// GetAll lists the sessions of the caller, the one of the request is marked as current.
+func (s *SessionsAction) GetAll(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys have no sessions")
+	}
+	return s.list(c, p.UserId)
+}
+
+// Revoke logs the caller out on one device, access tokens of the session stop working at once.
+func (s *SessionsAction) Revoke(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys have no sessions")
+	}
+	return s.revoke(c, p.UserId)
+}
+
+// RevokeAll logs the caller out everywhere, the current session included.
+func (s *SessionsAction) RevokeAll(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys have no sessions")
+	}
+	return s.revokeAll(c, p.UserId)
+}
+
+func (s *SessionsAction) GetByUser(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	return s.list(c, id)
+}
+
+func (s *SessionsAction) RevokeByUser(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	return s.revoke(c, id)
+}
+
+// RevokeAllByUser force-logs the user out.
+func (s *SessionsAction) RevokeAllByUser(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+
+	_, err = s.userRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return s.revokeAll(c, id)
+}
+
+func (s *SessionsAction) list(c echo.Context, userId int) error {
+	sessions, err := s.sessionRepo.GetByUser(userId)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get sessions", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if p := principal(c); p.UserId == userId {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].Id == p.SessionId
+		}
+	}
+	return c.JSON(http.StatusOK, sessions)
+}
+
+func (s *SessionsAction) revoke(c echo.Context, userId int) error {
+	id, err := strconv.Atoi(c.Param("sid"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong session ID")
+	}
+
+	err = s.sessionRepo.Revoke(id, userId, NewAuditEntry(c, domain.AuditSessionRevoke, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "session not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot revoke session", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+func (s *SessionsAction) revokeAll(c echo.Context, userId int) error {
+	_, err := s.sessionRepo.RevokeAll(userId, NewAuditEntry(c, domain.AuditSessionRevoke, ""))
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot revoke sessions", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestSessions(t *testing.T) {
+	laptop, phone := httpClient{}, httpClient{}
+	judy := signUp(t, "Judy", "judys secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", judy.Id), nil)
+	laptop.login(t, "Judy", "judys secret 1")
+	phoneTokens := phone.login(t, "Judy", "judys secret 1")
+
+	resp, respBody, err := laptop.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/sessions", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var sessions []domain.Session
+	require.NoError(t, json.Unmarshal(respBody, &sessions))
+	require.Len(t, sessions, 2)
+	var phoneSession domain.Session
+	for _, s := range sessions {
+		if !s.Current {
+			phoneSession = s
+		}
+	}
+	require.NotZero(t, phoneSession.Id)
+	require.NotEmpty(t, phoneSession.UserAgent)
+
+	// the phone is logged out at once, its access token hasn't expired yet
+	url := fmt.Sprintf("http://localhost:8877/api/v1/sessions/%d", phoneSession.Id)
+	resp, _, err = laptop.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _, err = phone.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/sessions", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	body, err := json.Marshal(domain.RefreshRequest{RefreshToken: phoneTokens.RefreshToken})
+	require.NoError(t, err)
+	resp, _, err = phone.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/refresh", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	url = fmt.Sprintf("http://localhost:8877/api/v1/users/%d/sessions", judy.Id)
+	resp, _, err = laptop.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, _, err = admin.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _, err = laptop.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/sessions", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.JSONEq(t, "[]", string(respBody))
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+type TotpAction struct {
+	totpRepo *repo.TotpRepo
+	totp     *service.Totp
+	logger   domain.Logger
+}
+
+func NewTotpAction(totpRepo *repo.TotpRepo, totp *service.Totp, logger domain.Logger) *TotpAction {
+	return &TotpAction{totpRepo, totp, logger}
+}
+
+// Enroll generates a new secret, 2FA is enabled once a code made with it is verified.
+func (s *TotpAction) Enroll(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage two-factor authentication")
+	}
+
+	secret, uri, err := s.totp.NewSecret(p.Login)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate TOTP secret", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	encrypted, err := s.totp.Encrypt(secret)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot encrypt TOTP secret", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+
+	err = s.totpRepo.Enroll(p.UserId, encrypted)
+	if errors.Is(err, domain.ErrTotpEnabled) {
+		return err
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot store TOTP secret", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, domain.TotpEnrollment{Secret: secret, URI: uri})
+}
+
+// Verify enables 2FA and returns the recovery codes, they are never shown again.
+func (s *TotpAction) Verify(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage two-factor authentication")
+	}
+	req := &domain.TotpCode{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "code required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	t, err := s.totpRepo.Get(p.UserId)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusBadRequest, "two-factor authentication is not enrolled")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get TOTP enrollment", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if t.Enabled {
+		return domain.ErrTotpEnabled
+	}
+	secret, err := s.totp.Decrypt(t.Secret)
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot decrypt TOTP secret", zap.Int("userId", p.UserId), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	step, ok := s.totp.Check(secret, req.Code, t.LastStep, time.Now())
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	codes, hashes, err := service.NewRecoveryCodes()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot generate recovery codes", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	err = s.totpRepo.Enable(p.UserId, step, hashes, NewAuditEntry(c, domain.AuditTotpEnable, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return domain.ErrTotpEnabled
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot enable TOTP", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, domain.RecoveryCodes{Codes: codes})
+}
+
+// Disable turns 2FA off, it takes a current code, so a stolen access token isn't enough.
+func (s *TotpAction) Disable(c echo.Context) (err error) {
+	p := principal(c)
+	if p.ApiKeyId != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "API keys cannot manage two-factor authentication")
+	}
+	req := &domain.TotpCode{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "code required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	ok, err := checkSecondFactor(s.totpRepo, s.totp, p.UserId, req.Code, requestLogger(c, s.logger))
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot check TOTP code", zap.Int("userId", p.UserId), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid code")
+	}
+
+	if err = s.totpRepo.Disable(p.UserId, NewAuditEntry(c, domain.AuditTotpDisable, "")); err != nil {
+		requestLogger(c, s.logger).Error("cannot disable TOTP", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+// checkSecondFactor accepts either a TOTP code or an unused recovery code of the user with enabled 2FA.
+// Recovery codes work even if the secret can't be decrypted, e.g. after the encryption key has changed.
+func checkSecondFactor(totpRepo *repo.TotpRepo, totp *service.Totp, userId int, code string, logger domain.Logger) (bool, error) {
+	t, err := totpRepo.Get(userId)
+	if errors.Is(err, domain.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !t.Enabled {
+		return false, nil
+	}
+
+	var (
+		step int64
+		ok   bool
+	)
+	if secret, err := totp.Decrypt(t.Secret); err != nil {
+		logger.Error("cannot decrypt TOTP secret, only recovery codes work", zap.Int("userId", userId), zap.Error(err))
+	} else {
+		step, ok = totp.Check(secret, code, t.LastStep, time.Now())
+	}
+	if ok {
+		err = totpRepo.UseStep(userId, step)
+	} else {
+		err = totpRepo.UseRecoveryCode(userId, service.HashRecoveryCode(code))
+	}
+	if errors.Is(err, domain.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
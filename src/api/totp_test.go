@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/service"
+)
+
+func TestTwoFactor(t *testing.T) {
+	client := httpClient{}
+	totp, err := service.NewTotp(&domain.Config{}, zap.NewNop())
+	require.NoError(t, err)
+
+	heidi := signUp(t, "Heidi", "heidis secret 1")
+	defer admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", heidi.Id), nil)
+	client.login(t, "Heidi", "heidis secret 1")
+
+	resp, respBody, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/2fa/enroll", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	enrollment := domain.TotpEnrollment{}
+	require.NoError(t, json.Unmarshal(respBody, &enrollment))
+	require.Contains(t, enrollment.URI, "otpauth://totp/")
+
+	code, err := totp.Code(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	body, err := json.Marshal(domain.TotpCode{Code: code})
+	require.NoError(t, err)
+	resp, respBody, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/2fa/verify", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	recovery := domain.RecoveryCodes{}
+	require.NoError(t, json.Unmarshal(respBody, &recovery))
+	require.NotEmpty(t, recovery.Codes)
+
+	// the password alone doesn't give tokens anymore
+	body, err = json.Marshal(domain.Credentials{Login: "Heidi", Password: "heidis secret 1"})
+	require.NoError(t, err)
+	resp, respBody, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	challenge := domain.MFAChallenge{}
+	require.NoError(t, json.Unmarshal(respBody, &challenge))
+	require.True(t, challenge.MFARequired)
+	require.NotContains(t, string(respBody), "access_token")
+
+	loginMFA := func(code string) int {
+		body, err := json.Marshal(domain.MFALogin{MFAToken: challenge.MFAToken, Code: code})
+		require.NoError(t, err)
+		resp, _, err := client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/login/mfa", body)
+		require.NoError(t, err)
+		return resp.StatusCode
+	}
+	require.Equal(t, http.StatusUnauthorized, loginMFA(code), "the code has been used to enable 2FA")
+	require.Equal(t, http.StatusUnauthorized, loginMFA("000000"))
+	require.Equal(t, http.StatusOK, loginMFA(recovery.Codes[0]))
+	require.Equal(t, http.StatusUnauthorized, loginMFA(recovery.Codes[1]), "MFA tokens are single-use")
+
+	body, err = json.Marshal(domain.TotpCode{Code: recovery.Codes[0]})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/2fa/disable", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode, "recovery codes are single-use")
+
+	body, err = json.Marshal(domain.TotpCode{Code: recovery.Codes[1]})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/auth/2fa/disable", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	client.login(t, "Heidi", "heidis secret 1")
+}
@@ -3,8 +3,10 @@ package api
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -15,66 +17,116 @@ import (
 )
 
 type UsersAction struct {
-	userRepo *repo.UserRepo
-	usersCh  chan []byte
-	logger   domain.Logger
+	cfg            *domain.Config
+	verifyTTL      time.Duration
+	resendInterval time.Duration
+	resendDaily    int
+	userRepo       *repo.UserRepo
+	userTokenRepo  *repo.UserTokenRepo
+	lockoutRepo    *repo.LockoutRepo
+	password       *service.Password
+	mail           domain.MailSender
+	usersCh        chan service.Message
+	lifecycle      *service.Lifecycle
+	logger         domain.Logger
 }
 
 func NewUsersAction(
+	cfg *domain.Config,
 	userRepo *repo.UserRepo,
+	userTokenRepo *repo.UserTokenRepo,
+	lockoutRepo *repo.LockoutRepo,
+	password *service.Password,
+	mail domain.MailSender,
 	kafka *service.Kafka,
+	lifecycle *service.Lifecycle,
 	logger domain.Logger,
 ) *UsersAction {
-	usersCh := make(chan []byte, 50)
-	err := kafka.GetProducer("users", 0, usersCh)
+	usersCh := make(chan service.Message, 50)
+	err := kafka.GetKeyedProducer("users", 0, usersCh)
 	if err != nil {
 		logger.Error("failed to connect to topic", zap.Error(err))
 	}
-	return &UsersAction{userRepo, usersCh, logger}
+	s := &UsersAction{
+		cfg:            cfg,
+		verifyTTL:      cfg.Auth.Verification.TTL,
+		resendInterval: cfg.Auth.Verification.ResendInterval,
+		resendDaily:    cfg.Auth.Verification.ResendDaily,
+		userRepo:       userRepo,
+		userTokenRepo:  userTokenRepo,
+		lockoutRepo:    lockoutRepo,
+		password:       password,
+		mail:           mail,
+		usersCh:        usersCh,
+		lifecycle:      lifecycle,
+		logger:         logger,
+	}
+	if s.verifyTTL == 0 {
+		s.verifyTTL = 24 * time.Hour
+	}
+	if s.resendInterval == 0 {
+		s.resendInterval = time.Minute
+	}
+	if s.resendDaily == 0 {
+		s.resendDaily = 5
+	}
+	return s
 }
 
+// Up answers 503 once the shutdown has started, so the instance gets no new requests.
 func (s *UsersAction) Up(c echo.Context) (err error) {
+	if s.lifecycle.Stopping() {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "shutting down")
+	}
 	ready, err := s.userRepo.Ready()
 	if err != nil {
-		s.logger.Error("not ready", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		requestLogger(c, s.logger).Error("not ready", zap.Error(err))
+		return echo.ErrInternalServerError
 	}
 	return c.JSON(http.StatusOK, ready)
 }
 
+// GetAll responds with the users and their ETag and Last-Modified, or with 304 if the client has them already.
 func (s *UsersAction) GetAll(c echo.Context) (err error) {
+	// Read before the users, so a change in between doesn't go unnoticed by If-Modified-Since
+	modified, err := s.userRepo.LastModified()
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot get users last modified", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
 	users, err := s.userRepo.GetAll()
 	if err != nil {
-		s.logger.Error("cannot get users", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		requestLogger(c, s.logger).Error("cannot get users", zap.Error(err))
+		return echo.ErrInternalServerError
 	}
 	u, _ := json.Marshal(users)
-	s.usersCh <- u
-	return c.JSON(http.StatusOK, users)
+	s.usersCh <- service.Message{Value: u, RequestId: requestId(c)}
+	return conditionalJSON(c, http.StatusOK, users, modified)
 }
 
+// GetById responds with the user and its ETag and Last-Modified, or with 304 if the client has it already.
 func (s *UsersAction) GetById(c echo.Context) (err error) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		s.logger.Error("wrong user ID", zap.Error(err))
-		return c.String(http.StatusBadRequest, "wrong user ID")
+		requestLogger(c, s.logger).Error("wrong user ID", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
 	}
 
 	user, err := s.userRepo.GetById(id)
 	if errors.Is(err, domain.ErrNoRows) {
-		return c.String(http.StatusNotFound, "user not found")
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
 	} else if err != nil {
-		s.logger.Error("cannot get user by ID", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
 	}
-	return c.JSON(http.StatusOK, user)
+	return conditionalJSON(c, http.StatusOK, user, user.UpdatedAt)
 }
 
 func (s *UsersAction) Create(c echo.Context) (err error) {
 	user := &domain.User{}
 
 	if err = c.Bind(user); err != nil {
-		return c.String(http.StatusBadRequest, "login required")
+		return echo.NewHTTPError(http.StatusBadRequest, "login required")
 	}
 	if err = c.Validate(user); err != nil {
 		return err
@@ -82,40 +134,191 @@ func (s *UsersAction) Create(c echo.Context) (err error) {
 
 	q, err := s.userRepo.GetByLogin(user.Login)
 	if err != nil {
-		s.logger.Error("cannot check if user exists", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		requestLogger(c, s.logger).Error("cannot check if user exists", zap.Error(err))
+		return echo.ErrInternalServerError
 	}
 	if q > 0 {
-		return c.String(http.StatusBadRequest, "user with such login already exists")
+		return echo.NewHTTPError(http.StatusBadRequest, "user with such login already exists")
 	}
-
-	err = s.userRepo.Create(user)
+	q, err = s.userRepo.GetByEmail(user.Email)
 	if err != nil {
-		s.logger.Error("cannot create user", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		requestLogger(c, s.logger).Error("cannot check if email is taken", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if q > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "user with such email already exists")
+	}
+
+	if user.Password != "" {
+		if err = s.password.Validate(user.Password, user.Login); err != nil {
+			return domain.NewValidationError("password", err.Error())
+		}
+		user.PasswordHash, err = s.password.Hash(user.Password)
+		if err != nil {
+			requestLogger(c, s.logger).Error("cannot hash password", zap.Error(err))
+			return echo.ErrInternalServerError
+		}
+		user.Password = ""
+	}
+
+	user.Status = domain.UserPending
+	err = s.userRepo.Create(user, NewAuditEntry(c, domain.AuditUserCreate, ""))
+	if errors.Is(err, domain.ErrConflict) {
+		// A concurrent signup has taken the login or email since the checks
+		return echo.NewHTTPError(http.StatusBadRequest, "user with such login or email already exists")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot create user", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	// The user can ask for another mail if this one fails
+	if err = s.sendVerification(user); err != nil {
+		requestLogger(c, s.logger).Error("cannot send verification mail", zap.Int("userId", user.Id), zap.Error(err))
 	}
 	return c.JSON(http.StatusOK, user)
 }
 
+func (s *UsersAction) Verify(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	req := &domain.VerifyRequest{}
+	if err = c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "token required")
+	}
+	if err = c.Validate(req); err != nil {
+		return err
+	}
+
+	audit := NewAuditEntry(c, domain.AuditUserUpdate, "")
+	audit.ActorId = &id
+	err = s.userRepo.Activate(id, service.HashToken(req.Token), audit)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired token")
+	} else if errors.Is(err, domain.ErrNotPending) {
+		return err
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot activate user", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+// ResendVerification mails a new verification token, it's limited per user
+// by the interval between mails and the number of mails per day.
+func (s *UsersAction) ResendVerification(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+
+	user, err := s.userRepo.GetById(id)
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if user.Status != domain.UserPending {
+		return echo.NewHTTPError(http.StatusBadRequest, "email is already verified")
+	}
+
+	qnt, last, err := s.userTokenRepo.Issued(id, domain.TokenEmailVerification, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		requestLogger(c, s.logger).Error("cannot count verification tokens", zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	if qnt >= s.resendDaily {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int((24 * time.Hour).Seconds())))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "too many verification mails, try again tomorrow")
+	}
+	if last != nil {
+		if wait := s.resendInterval - time.Since(*last); wait > 0 {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			return echo.NewHTTPError(http.StatusTooManyRequests, "verification mail has been sent recently")
+		}
+	}
+
+	if err = s.sendVerification(&user); err != nil {
+		requestLogger(c, s.logger).Error("cannot send verification mail", zap.Int("userId", user.Id), zap.Error(err))
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, "OK")
+}
+
+func (s *UsersAction) sendVerification(user *domain.User) error {
+	token, hash, err := service.NewSecret()
+	if err != nil {
+		return err
+	}
+	err = s.userTokenRepo.Create(&domain.UserToken{
+		UserId:    user.Id,
+		Purpose:   domain.TokenEmailVerification,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(s.verifyTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.mail.Send(domain.Mail{
+		To:      user.Email,
+		Subject: "Confirm your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nplease confirm your email by following the link:\n%s/verify?user=%d&token=%s\n\nThe link expires in %s.\n",
+			user.Login, s.cfg.Mail.BaseURL, user.Id, token, s.verifyTTL,
+		),
+	})
+}
+
+// Delete deletes the user, with If-Match only if the client has seen its current representation.
 func (s *UsersAction) Delete(c echo.Context) (err error) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		s.logger.Error("wrong user ID", zap.Error(err))
-		return c.String(http.StatusBadRequest, "wrong user ID")
+		requestLogger(c, s.logger).Error("wrong user ID", zap.Error(err))
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+	version := 0
+	if c.Request().Header.Get("If-Match") != "" {
+		user, err := s.userRepo.GetById(id)
+		if errors.Is(err, domain.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "user not found")
+		} else if err != nil {
+			requestLogger(c, s.logger).Error("cannot get user by ID", zap.Error(err))
+			return echo.ErrInternalServerError
+		}
+		if err = checkIfMatch(c, user); err != nil {
+			return err
+		}
+		// The ETag is of this version, the delete fails if the user changes meanwhile
+		version = user.Version
 	}
 
-	_, err = s.userRepo.GetById(id)
+	err = s.userRepo.Delete(id, version, NewAuditEntry(c, domain.AuditUserDelete, ""))
 	if errors.Is(err, domain.ErrNoRows) {
-		return c.String(http.StatusNotFound, "user not found")
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if errors.Is(err, domain.ErrVersionMismatch) {
+		return err
 	} else if err != nil {
-		s.logger.Error("cannot get user by ID", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		requestLogger(c, s.logger).Error("cannot delete user", zap.Error(err))
+		return echo.ErrInternalServerError
 	}
+	return c.JSON(http.StatusOK, "OK")
+}
 
-	err = s.userRepo.Delete(id)
+// Unlock lifts the lockout of the account after failed logins, the lockout of the client IP stays.
+func (s *UsersAction) Unlock(c echo.Context) (err error) {
+	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		s.logger.Error("cannot delete user", zap.Error(err))
-		return c.String(http.StatusInternalServerError, "Internal Server Error")
+		return echo.NewHTTPError(http.StatusBadRequest, "wrong user ID")
+	}
+
+	err = s.lockoutRepo.Unlock(id, NewAuditEntry(c, domain.AuditUserUnlock, ""))
+	if errors.Is(err, domain.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	} else if err != nil {
+		requestLogger(c, s.logger).Error("cannot unlock user", zap.Error(err))
+		return echo.ErrInternalServerError
 	}
 	return c.JSON(http.StatusOK, "OK")
 }
@@ -16,7 +16,9 @@ func TestUser(t *testing.T) {
 
 	// CREATE
 	record := domain.User{
-		Login: "Alice",
+		Login:    "Alice",
+		Email:    "alice@example.com",
+		Password: "alice secret 1",
 	}
 	httpBody, err := json.Marshal(record)
 	require.NoError(t, err)
@@ -29,13 +31,27 @@ func TestUser(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEqual(t, 0, respUser.Id)
 
+	// only authenticated users can see others
+	resp, _, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/users", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	token, err := mailedToken(record.Email, verifyLink)
+	require.NoError(t, err)
+	httpBody, err = json.Marshal(domain.VerifyRequest{Token: token})
+	require.NoError(t, err)
+	resp, _, err = client.sendJsonReq(http.MethodPost, fmt.Sprintf("http://localhost:8877/api/v1/users/%d/verify", respUser.Id), httpBody)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	client.login(t, "Alice", "alice secret 1")
+
 	// LIST
 	resp, respBody, err = client.sendJsonReq(http.MethodGet, "http://localhost:8877/api/v1/users", nil)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 	var users []domain.User
 	err = json.Unmarshal(respBody, &users)
-	require.Equal(t, 1, len(users))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(users)) // Alice and admin
 
 	// READ
 	resp, respBody, err = client.sendJsonReq(http.MethodGet, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", respUser.Id), []byte{})
@@ -46,3 +62,61 @@ func TestUser(t *testing.T) {
 	require.Equal(t, respUser.Id, record.Id)
 	require.Equal(t, "Alice", record.Login)
 }
+
+func TestDeleteUserPermission(t *testing.T) {
+	client := httpClient{}
+
+	eve := signUp(t, "Eve", "eves secret 1")
+	client.login(t, "Eve", "eves secret 1")
+
+	url := fmt.Sprintf("http://localhost:8877/api/v1/users/%d", eve.Id)
+	resp, _, err := client.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, respBody, err := admin.sendJsonReq(http.MethodGet, url+"/roles", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var roles []domain.Role
+	require.NoError(t, json.Unmarshal(respBody, &roles))
+	require.Len(t, roles, 1)
+	require.Equal(t, domain.RoleUser, roles[0].Name)
+
+	resp, _, err = admin.sendJsonReq(http.MethodDelete, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestUserAudit(t *testing.T) {
+	body, err := json.Marshal(domain.User{Login: "Frank", Email: "frank@example.com", Password: "franks secret 1"})
+	require.NoError(t, err)
+	resp, respBody, err := admin.sendJsonReq(http.MethodPost, "http://localhost:8877/api/v1/users", body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	frank := domain.User{}
+	require.NoError(t, json.Unmarshal(respBody, &frank))
+
+	resp, _, err = admin.sendJsonReq(http.MethodDelete, fmt.Sprintf("http://localhost:8877/api/v1/users/%d", frank.Id), nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	url := fmt.Sprintf("http://localhost:8877/api/v1/audit?target=user:%d&limit=1", frank.Id)
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page := domain.AuditPage{}
+	require.NoError(t, json.Unmarshal(respBody, &page))
+	require.Len(t, page.Items, 1)
+	require.Equal(t, domain.AuditUserDelete, page.Items[0].Action)
+	require.NotNil(t, page.Items[0].ActorId)
+	require.NotEmpty(t, page.NextCursor)
+
+	resp, respBody, err = admin.sendJsonReq(http.MethodGet, url+"&cursor="+page.NextCursor, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	page = domain.AuditPage{}
+	require.NoError(t, json.Unmarshal(respBody, &page))
+	require.Len(t, page.Items, 1)
+	require.Equal(t, domain.AuditUserCreate, page.Items[0].Action)
+	require.Empty(t, page.NextCursor)
+}
@@ -10,14 +10,165 @@ import (
 )
 
 var ConfigApi = []di.Def{
+	{
+		Name:  "api.health",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			return api.NewHealthAction(ctx.Get("service.health").(*service.Health)), nil
+		},
+	},
 	{
 		Name:  "api.users",
 		Scope: di.App,
 		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
 			usersRepo := ctx.Get("repo.user").(*repo.UserRepo)
+			userTokenRepo := ctx.Get("repo.usertoken").(*repo.UserTokenRepo)
+			lockoutRepo := ctx.Get("repo.lockout").(*repo.LockoutRepo)
+			password := ctx.Get("service.password").(*service.Password)
+			mail := ctx.Get("service.mail").(domain.MailSender)
 			logger := ctx.Get("logger").(domain.Logger)
 			kaf := ctx.Get("service.kafka").(*service.Kafka)
-			return api.NewUsersAction(usersRepo, kaf, logger), nil
+			lifecycle := ctx.Get("service.lifecycle").(*service.Lifecycle)
+			return api.NewUsersAction(cfg, usersRepo, userTokenRepo, lockoutRepo, password, mail, kaf, lifecycle, logger), nil
+		},
+	},
+	{
+		Name:  "api.auth",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			usersRepo := ctx.Get("repo.user").(*repo.UserRepo)
+			tokenRepo := ctx.Get("repo.token").(*repo.TokenRepo)
+			sessionRepo := ctx.Get("repo.session").(*repo.SessionRepo)
+			userTokenRepo := ctx.Get("repo.usertoken").(*repo.UserTokenRepo)
+			totpRepo := ctx.Get("repo.totp").(*repo.TotpRepo)
+			lockoutRepo := ctx.Get("repo.lockout").(*repo.LockoutRepo)
+			password := ctx.Get("service.password").(*service.Password)
+			token := ctx.Get("service.token").(*service.Token)
+			totp := ctx.Get("service.totp").(*service.Totp)
+			mail := ctx.Get("service.mail").(domain.MailSender)
+			lifecycle := ctx.Get("service.lifecycle").(*service.Lifecycle)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewAuthAction(cfg, usersRepo, tokenRepo, sessionRepo, userTokenRepo, totpRepo, lockoutRepo, password, token, totp, mail, lifecycle, logger), nil
+		},
+	},
+	{
+		Name:  "api.oidc",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			oidcRepo := ctx.Get("repo.oidc").(*repo.OIDCRepo)
+			usersRepo := ctx.Get("repo.user").(*repo.UserRepo)
+			oidc := ctx.Get("service.oidc").(*service.OIDC)
+			auth := ctx.Get("api.auth").(*api.AuthAction)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewOIDCAction(oidcRepo, usersRepo, oidc, auth, logger), nil
+		},
+	},
+	{
+		Name:  "api.scim",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			usersRepo := ctx.Get("repo.user").(*repo.UserRepo)
+			groupRepo := ctx.Get("repo.group").(*repo.GroupRepo)
+			events := ctx.Get("service.events.groups").(*service.Events)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewSCIMAction(usersRepo, groupRepo, events, logger), nil
+		},
+	},
+	{
+		Name:  "api.groups",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			groupRepo := ctx.Get("repo.group").(*repo.GroupRepo)
+			roleRepo := ctx.Get("repo.role").(*repo.RoleRepo)
+			auditRepo := ctx.Get("repo.audit").(*repo.AuditRepo)
+			events := ctx.Get("service.events.groups").(*service.Events)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewGroupsAction(groupRepo, roleRepo, auditRepo, events, logger), nil
+		},
+	},
+	{
+		Name:  "api.invitations",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			invitationRepo := ctx.Get("repo.invitation").(*repo.InvitationRepo)
+			groupRepo := ctx.Get("repo.group").(*repo.GroupRepo)
+			roleRepo := ctx.Get("repo.role").(*repo.RoleRepo)
+			auditRepo := ctx.Get("repo.audit").(*repo.AuditRepo)
+			password := ctx.Get("service.password").(*service.Password)
+			mail := ctx.Get("service.mail").(domain.MailSender)
+			events := ctx.Get("service.events.groups").(*service.Events)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewInvitationsAction(cfg, invitationRepo, groupRepo, roleRepo, auditRepo, password, mail, events, logger), nil
+		},
+	},
+	{
+		Name:  "api.compliance",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			complianceRepo := ctx.Get("repo.compliance").(*repo.ComplianceRepo)
+			erasureEvents := ctx.Get("service.events.erasure").(*service.Events)
+			groupEvents := ctx.Get("service.events.groups").(*service.Events)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewComplianceAction(complianceRepo, erasureEvents, groupEvents, logger), nil
+		},
+	},
+	{
+		Name:  "api.sessions",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			sessionRepo := ctx.Get("repo.session").(*repo.SessionRepo)
+			usersRepo := ctx.Get("repo.user").(*repo.UserRepo)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewSessionsAction(sessionRepo, usersRepo, logger), nil
+		},
+	},
+	{
+		Name:  "api.totp",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			totpRepo := ctx.Get("repo.totp").(*repo.TotpRepo)
+			totp := ctx.Get("service.totp").(*service.Totp)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewTotpAction(totpRepo, totp, logger), nil
+		},
+	},
+	{
+		Name:  "api.apikeys",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			apiKeyRepo := ctx.Get("repo.apikey").(*repo.ApiKeyRepo)
+			roleRepo := ctx.Get("repo.role").(*repo.RoleRepo)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewApiKeysAction(apiKeyRepo, roleRepo, logger), nil
+		},
+	},
+	{
+		Name:  "api.roles",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			roleRepo := ctx.Get("repo.role").(*repo.RoleRepo)
+			usersRepo := ctx.Get("repo.user").(*repo.UserRepo)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewRolesAction(roleRepo, usersRepo, logger), nil
+		},
+	},
+	{
+		Name:  "api.audit",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			auditRepo := ctx.Get("repo.audit").(*repo.AuditRepo)
+			logger := ctx.Get("logger").(domain.Logger)
+			return api.NewAuditAction(auditRepo, logger), nil
+		},
+	},
+	{
+		Name:  "api.openapi",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			return api.NewOpenAPIAction()
 		},
 	},
 }
@@ -16,4 +16,108 @@ var ConfigRepo = []di.Def{
 			return repo.NewUserRepository(db), nil
 		},
 	},
+	{
+		Name:  "repo.token",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewTokenRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.apikey",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewApiKeyRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.role",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewRoleRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.audit",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewAuditRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.usertoken",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewUserTokenRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.invitation",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewInvitationRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.compliance",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewComplianceRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.oidc",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewOIDCRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.session",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewSessionRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.totp",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewTotpRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.group",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewGroupRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.lockout",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewLockoutRepository(db), nil
+		},
+	},
+	{
+		Name:  "repo.idempotency",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			db := ctx.Get("db").(domain.DB)
+			return repo.NewIdempotencyRepository(db), nil
+		},
+	},
 }
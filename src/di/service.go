@@ -1,10 +1,16 @@
 package di
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/sarulabs/di"
 
 	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/repo"
 	"github.com/Kale-Grabovski/gonah/src/service"
+	"github.com/Kale-Grabovski/gonah/src/service/mail"
+	"github.com/Kale-Grabovski/gonah/src/service/migrate"
 )
 
 var ConfigService = []di.Def{
@@ -16,9 +22,119 @@ var ConfigService = []di.Def{
 			logger := ctx.Get("logger").(domain.Logger)
 			return service.NewKafka(cfg, logger), nil
 		},
+		// runApi closes it before the DB, it's done once
 		Close: func(obj interface{}) error {
-			obj.(*service.Kafka).Close()
-			return nil
+			ctx, cancel := context.WithTimeout(context.Background(), service.KafkaCloseTimeout)
+			defer cancel()
+			return obj.(*service.Kafka).Close(ctx)
+		},
+	},
+	{
+		Name:  "service.lifecycle",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			return service.NewLifecycle(), nil
+		},
+	},
+	{
+		Name:  "service.events.groups",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			kafka := ctx.Get("service.kafka").(*service.Kafka)
+			logger := ctx.Get("logger").(domain.Logger)
+			return service.NewEvents(kafka, "groups", logger), nil
+		},
+	},
+	{
+		Name:  "service.events.erasure",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			kafka := ctx.Get("service.kafka").(*service.Kafka)
+			logger := ctx.Get("logger").(domain.Logger)
+			// apart from the users topic, its consumers get the user lists
+			return service.NewEvents(kafka, "users.erasure", logger), nil
+		},
+	},
+	{
+		Name:  "service.password",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return service.NewPassword(cfg)
+		},
+	},
+	{
+		Name:  "service.token",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			logger := ctx.Get("logger").(domain.Logger)
+			return service.NewToken(cfg, logger)
+		},
+	},
+	{
+		Name:  "service.totp",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			logger := ctx.Get("logger").(domain.Logger)
+			// An ephemeral key can't decrypt the secrets of the enrolled users
+			if cfg.Auth.TOTP.EncryptionKey == "" {
+				enrolled, err := ctx.Get("repo.totp").(*repo.TotpRepo).AnyEnrolled()
+				if err != nil {
+					return nil, err
+				}
+				if enrolled {
+					return nil, fmt.Errorf("auth.totp.encryptionKey isn't configured, but users have enrolled in 2FA")
+				}
+			}
+			return service.NewTotp(cfg, logger)
+		},
+	},
+	{
+		Name:  "service.oidc",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			return service.NewOIDC(cfg), nil
+		},
+	},
+	{
+		Name:  "service.mail",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			logger := ctx.Get("logger").(domain.Logger)
+			return mail.New(cfg, logger)
+		},
+	},
+	{
+		Name:  "service.ratelimit",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			switch cfg.RateLimit.Store {
+			case "postgres":
+				return repo.NewRateLimitRepository(ctx.Get("db").(domain.DB)), nil
+			case "", "memory":
+				return service.NewMemoryRateLimits(), nil
+			}
+			return nil, fmt.Errorf("unknown rate limit store %q", cfg.RateLimit.Store)
+		},
+	},
+	{
+		Name:  "service.health",
+		Scope: di.App,
+		Build: func(ctx di.Container) (interface{}, error) {
+			cfg := ctx.Get("config").(*domain.Config)
+			db := ctx.Get("db").(domain.DB)
+			health := service.NewHealth(cfg)
+			health.AddReadiness("db", service.DBCheck(db))
+			// the producers queue the messages while the broker is away, the requests don't wait for it
+			health.AddOptionalReadiness("kafka", ctx.Get("service.kafka").(*service.Kafka).Ping)
+			health.AddReadiness("migrations", migrate.Check("./migrations", db))
+			health.AddReadiness("shutdown", ctx.Get("service.lifecycle").(*service.Lifecycle).Check)
+			return health, nil
 		},
 	},
 }
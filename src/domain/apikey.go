@@ -0,0 +1,19 @@
+package domain
+
+import "time"
+
+const ApiKeyHeader = "X-API-Key"
+
+type ApiKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name" validate:"required"`
+	Prefix     string     `json:"prefix"`
+	Key        string     `json:"key,omitempty"` // only returned once on creation
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes" validate:"required,dive,required"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Login      string     `json:"-"` // owner's login
+}
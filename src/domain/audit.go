@@ -0,0 +1,46 @@
+package domain
+
+import "time"
+
+const (
+	AuditAccessDenied = "access.denied"
+	AuditRoleGrant    = "role.grant"
+	AuditRoleRevoke   = "role.revoke"
+	AuditUserCreate   = "user.create"
+	AuditUserUpdate   = "user.update"
+	AuditUserDelete   = "user.delete"
+	AuditTotpEnable   = "totp.enable"
+	AuditTotpDisable  = "totp.disable"
+
+	PermAuditRead = "audit:read"
+)
+
+// AuditEntry is a record of the append-only audit log.
+// Before and After keep only the fields changed by the action.
+type AuditEntry struct {
+	Id        int                    `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	ActorId   *int                   `json:"actor_id"` // nil for anonymous requests and the CLI
+	Action    string                 `json:"action"`
+	Target    string                 `json:"target"`
+	RequestId string                 `json:"request_id"`
+	IP        string                 `json:"ip"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Before    map[string]interface{} `json:"before,omitempty"`
+	After     map[string]interface{} `json:"after,omitempty"`
+}
+
+type AuditFilter struct {
+	ActorId  int
+	Action   string
+	Target   string
+	Since    time.Time
+	Until    time.Time
+	BeforeId int // cursor, entries are returned newest first
+	Limit    int
+}
+
+type AuditPage struct {
+	Items      []AuditEntry `json:"items"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
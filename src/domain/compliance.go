@@ -0,0 +1,72 @@
+package domain
+
+import "time"
+
+const (
+	AuditUserExport = "user.export"
+	AuditUserErase  = "user.erase"
+
+	PermUsersExport = "users:export"
+	PermUsersErase  = "users:erase"
+
+	ComplianceExport  = "export"
+	ComplianceErasure = "erasure"
+)
+
+// ComplianceEntry records a handled data subject request. Unlike the audit log it has
+// no personal data, so it outlives the erasure of the subject.
+type ComplianceEntry struct {
+	Id        int                    `json:"id"`
+	CreatedAt time.Time              `json:"created_at"`
+	Request   string                 `json:"request"`
+	SubjectId int                    `json:"subject_id"`
+	ActorId   *int                   `json:"actor_id"`
+	RequestId string                 `json:"request_id"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// UserExport is everything stored about the user.
+type UserExport struct {
+	ExportedAt   time.Time        `json:"exported_at"`
+	Profile      UserProfile      `json:"profile"`
+	Roles        []string         `json:"roles"`
+	Memberships  []UserMembership `json:"memberships"`
+	Sessions     []Session        `json:"sessions"`
+	ApiKeys      []ApiKey         `json:"api_keys"`
+	Identities   []UserIdentity   `json:"identities"`
+	TOTPEnabled  bool             `json:"totp_enabled"`
+	Invitations  []Invitation     `json:"invitations"`
+	AuditEntries []AuditEntry     `json:"audit_entries"` // made by the user or about them
+}
+
+type UserProfile struct {
+	Id         int       `json:"id"`
+	Login      string    `json:"login"`
+	Email      string    `json:"email"`
+	Status     string    `json:"status"`
+	ExternalId string    `json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type UserMembership struct {
+	GroupId   int       `json:"group_id"`
+	GroupName string    `json:"group_name"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// UserIdentity is the account of the user at the OIDC provider.
+type UserIdentity struct {
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Erasure tells what has been done to erase the user.
+type Erasure struct {
+	UserId             int   `json:"user_id"`
+	Groups             []int `json:"groups"` // the user has been removed from
+	AnonymizedAudit    int   `json:"anonymized_audit_entries"`
+	DeletedInvitations int   `json:"deleted_invitations"`
+}
@@ -1,14 +1,118 @@
 package domain
 
+import "time"
+
 const EnvPrefix = "GONAH"
 
 type Config struct {
 	LogLevel string `yaml:"logLevel"`
 	ApiPort  string `yaml:"apiPort"`
-	DB       struct {
+	// IPs or CIDRs of the proxies setting X-Forwarded-For, the client IP is the one of the connection without them
+	TrustedProxies []string `yaml:"trustedProxies"`
+	Shutdown       struct {
+		Timeout       time.Duration `yaml:"timeout"`       // for the requests and Kafka deliveries in flight
+		NotReadyDelay time.Duration `yaml:"notReadyDelay"` // for the load balancers to stop sending requests
+	} `yaml:"shutdown"`
+	Health struct {
+		CacheTTL time.Duration `yaml:"cacheTtl"` // of the check results
+		Timeout  time.Duration `yaml:"timeout"`  // of a check
+	} `yaml:"health"`
+	DB struct {
 		DSN string `yaml:"dsn"`
 	} `yaml:"db"`
 	Kafka struct {
 		Host string `yaml:"host"`
 	} `yaml:"kafka"`
+	AccessLog struct {
+		Enabled      bool     `yaml:"enabled"`
+		SampleRate   *float64 `yaml:"sampleRate"`   // share of the successful requests logged, failed ones always are; 1 if unset
+		ExcludePaths []string `yaml:"excludePaths"` // route templates, like /api/v1/users/:id
+		Headers      []string `yaml:"headers"`      // of the requests to log
+		Redact       []string `yaml:"redact"`       // query parameters and headers logged as REDACTED
+	} `yaml:"accessLog"`
+	RateLimit struct {
+		Enabled  bool              `yaml:"enabled"`
+		Store    string            `yaml:"store"` // memory or postgres, the buckets are shared by the replicas with postgres
+		Policies []RateLimitPolicy `yaml:"policies"`
+	} `yaml:"rateLimit"`
+	Idempotency struct {
+		TTL time.Duration `yaml:"ttl"` // of the keys and the responses kept for the retries
+	} `yaml:"idempotency"`
+	OpenAPI struct {
+		ValidateResponses bool `yaml:"validateResponses"` // for the tests, mismatching responses become 500
+	} `yaml:"openapi"`
+	Auth struct {
+		Password struct {
+			Algorithm  string `yaml:"algorithm"` // argon2id or bcrypt
+			MinLength  int    `yaml:"minLength"`
+			MaxLength  int    `yaml:"maxLength"`
+			BcryptCost int    `yaml:"bcryptCost"`
+			Argon2     struct {
+				Memory      uint32 `yaml:"memory"` // KiB
+				Iterations  uint32 `yaml:"iterations"`
+				Parallelism uint8  `yaml:"parallelism"`
+			} `yaml:"argon2"`
+		} `yaml:"password"`
+		JWT struct {
+			Issuer     string        `yaml:"issuer"`
+			Audience   string        `yaml:"audience"`
+			AccessTTL  time.Duration `yaml:"accessTtl"`
+			RefreshTTL time.Duration `yaml:"refreshTtl"`
+			ActiveKey  string        `yaml:"activeKey"` // id of the key signing new tokens
+			Keys       []JWTKey      `yaml:"keys"`
+		} `yaml:"jwt"`
+		Verification struct {
+			TTL            time.Duration `yaml:"ttl"`
+			ResendInterval time.Duration `yaml:"resendInterval"`
+			ResendDaily    int           `yaml:"resendDaily"`
+		} `yaml:"verification"`
+		PasswordReset struct {
+			TTL      time.Duration `yaml:"ttl"`
+			Interval time.Duration `yaml:"interval"` // between reset mails to the same user
+		} `yaml:"passwordReset"`
+		Invitation struct {
+			TTL time.Duration `yaml:"ttl"`
+		} `yaml:"invitation"`
+		TOTP struct {
+			Issuer        string `yaml:"issuer"`        // shown by authenticator apps
+			EncryptionKey string `yaml:"encryptionKey"` // base64 encoded 32 bytes AES key for the secrets
+		} `yaml:"totp"`
+		Lockout struct {
+			Window        time.Duration `yaml:"window"`       // failures older than it are forgotten
+			FreeAttempts  int           `yaml:"freeAttempts"` // failures before the delays start
+			BaseDelay     time.Duration `yaml:"baseDelay"`    // doubles with every next failure
+			MaxDelay      time.Duration `yaml:"maxDelay"`
+			AccountLimit  int           `yaml:"accountLimit"` // failures locking the account
+			IPLimit       int           `yaml:"ipLimit"`      // failures locking the client
+			LockoutPeriod time.Duration `yaml:"lockoutPeriod"`
+		} `yaml:"lockout"`
+		OIDC struct {
+			Issuer       string   `yaml:"issuer"` // the login is disabled if empty
+			ClientId     string   `yaml:"clientId"`
+			ClientSecret string   `yaml:"clientSecret"`
+			RedirectURL  string   `yaml:"redirectUrl"`
+			Scopes       []string `yaml:"scopes"`
+		} `yaml:"oidc"`
+	} `yaml:"auth"`
+	Mail struct {
+		Driver  string `yaml:"driver"` // smtp, log or file
+		From    string `yaml:"from"`
+		BaseURL string `yaml:"baseUrl"` // of the frontend the links in mails lead to
+		Dir     string `yaml:"dir"`     // for the file driver
+		SMTP    struct {
+			Host     string        `yaml:"host"`
+			Port     int           `yaml:"port"`
+			Username string        `yaml:"username"`
+			Password string        `yaml:"password"`
+			StartTLS bool          `yaml:"startTls"`
+			Timeout  time.Duration `yaml:"timeout"` // of the whole exchange with the server
+		} `yaml:"smtp"`
+	} `yaml:"mail"`
+}
+
+type JWTKey struct {
+	Id   string `yaml:"id"`
+	Alg  string `yaml:"alg"`  // RS256 or EdDSA, guessed from the key if empty
+	File string `yaml:"file"` // PEM encoded private key file
+	PEM  string `yaml:"pem"`  // or the PEM itself
 }
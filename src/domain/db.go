@@ -2,12 +2,19 @@ package domain
 
 import (
 	"context"
+	"errors"
 
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 )
 
-var ErrNoRows = pgx.ErrNoRows
+var (
+	ErrNoRows = pgx.ErrNoRows
+	// ErrConflict is returned when a unique attribute is already taken.
+	ErrConflict = errors.New("already exists")
+	// ErrVersionMismatch is returned when the resource has changed since the version the client has seen.
+	ErrVersionMismatch = errors.New("resource has been modified")
+)
 
 type DB interface {
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	AuditGroupCreate       = "group.create"
+	AuditGroupUpdate       = "group.update"
+	AuditGroupDelete       = "group.delete"
+	AuditGroupMemberSet    = "group.member.set"
+	AuditGroupMemberRemove = "group.member.remove"
+
+	PermGroupsRead   = "groups:read"
+	PermGroupsCreate = "groups:create" // of the user role, users own the groups they create
+	PermGroupsManage = "groups:manage"
+
+	GroupOwner  = "owner" // manages the group and its members
+	GroupMember = "member"
+
+	// Membership events are published to the groups Kafka topic
+	EventMemberAdded       = "member.added"
+	EventMemberRoleChanged = "member.role_changed"
+	EventMemberRemoved     = "member.removed"
+)
+
+var (
+	ErrUnknownMember = errors.New("unknown group member")
+	ErrLastOwner     = errors.New("the group must keep an owner")
+)
+
+type Group struct {
+	Id          int       `json:"id"`
+	Name        string    `json:"name" validate:"required"`
+	Description string    `json:"description"`
+	ExternalId  string    `json:"-"` // of the provisioning IdP
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Version     int       `json:"-"` // bumped on every change, members included
+}
+
+type GroupFilter struct {
+	Name       string
+	ExternalId string
+}
+
+type Membership struct {
+	UserId   int       `json:"user_id"`
+	Login    string    `json:"login"`
+	Role     string    `json:"role" validate:"required,oneof=owner member"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+type GroupPage struct {
+	Items []Group `json:"items"`
+	Total int     `json:"total"`
+}
+
+type MembershipPage struct {
+	Items []Membership `json:"items"`
+	Total int          `json:"total"`
+}
+
+type MembershipEvent struct {
+	Type    string    `json:"type"`
+	GroupId int       `json:"group_id"`
+	UserId  int       `json:"user_id"`
+	Role    string    `json:"role,omitempty"`
+	ActorId *int      `json:"actor_id,omitempty"`
+	At      time.Time `json:"at"`
+}
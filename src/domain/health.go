@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+const (
+	HealthUp   = "up"
+	HealthDown = "down"
+)
+
+// HealthReport is the body of /livez and /readyz, the instance is up if every check but the optional ones is.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Optional  bool      `json:"optional,omitempty"` // its failure doesn't take the instance down
+	Duration  float64   `json:"duration_ms"`
+	CheckedAt time.Time `json:"checked_at"` // checks are cached, so it may be before the request
+}
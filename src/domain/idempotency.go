@@ -0,0 +1,36 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	IdempotencyKeyMaxLength  = 255
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned for a request with the key of another request.
+	ErrIdempotencyKeyReused = errors.New("Idempotency-Key has been used for another request")
+	// ErrIdempotencyInProgress is returned while the first request with the key is being processed.
+	ErrIdempotencyInProgress = errors.New("request with the Idempotency-Key is in progress")
+)
+
+// IdempotentRequest is the first request with the key and its response, Status is zero while it's in progress.
+type IdempotentRequest struct {
+	Fingerprint string // of the method, path and body
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type IdempotencyStore interface {
+	// Start takes the key for the request, or returns the first request with the key if it's taken.
+	Start(key, fingerprint string, ttl, abandonAfter time.Duration) (*IdempotentRequest, error)
+	// Complete stores the response of the request with the key.
+	Complete(key string, status int, contentType string, body []byte) error
+	// Release frees the key of the request in progress.
+	Release(key string) error
+}
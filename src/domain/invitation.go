@@ -0,0 +1,57 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	AuditInvitationCreate = "invitation.create"
+	AuditInvitationRevoke = "invitation.revoke"
+	AuditInvitationAccept = "invitation.accept"
+
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired"
+)
+
+var (
+	ErrLoginRequired = errors.New("login and password are required to create the account")
+	ErrUserDisabled  = errors.New("account is disabled")
+)
+
+// Invitation asks the owner of the email to join the group, the account is created on acceptance
+// if there is none. Only the hash of the mailed token is stored.
+type Invitation struct {
+	Id         int        `json:"id"`
+	GroupId    int        `json:"group_id"`
+	Email      string     `json:"email" validate:"required,email"`
+	Role       string     `json:"role" validate:"required,oneof=owner member"`
+	Status     string     `json:"status"`
+	InvitedBy  *int       `json:"invited_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	AcceptedBy *int       `json:"accepted_by,omitempty"`
+	Hash       string     `json:"-"`
+}
+
+type InvitationPage struct {
+	Items []Invitation `json:"items"`
+	Total int          `json:"total"`
+}
+
+// InvitationAccept is sent by the invitee, login and password are only needed if the email has no account yet.
+type InvitationAccept struct {
+	Token    string `json:"token" validate:"required"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type AcceptedInvitation struct {
+	User    User   `json:"user"`
+	GroupId int    `json:"group_id"`
+	Role    string `json:"role"`
+	Created bool   `json:"created"` // the account has been created
+}
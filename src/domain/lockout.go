@@ -0,0 +1,27 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	AuditUserUnlock = "user.unlock"
+	PermUsersUnlock = "users:unlock"
+)
+
+// LoginFailures are the recent failed logins of an account or a client.
+type LoginFailures struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
+
+// LoginKey identifies the account, unknown logins are tracked too, so they can't be told from the known ones.
+func LoginKey(login string) string {
+	return "login:" + strings.ToLower(login)
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
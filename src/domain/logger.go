@@ -12,6 +12,8 @@ type Logger interface {
 	Warn(msg string, fields ...zapcore.Field)
 	Debug(msg string, fields ...zapcore.Field)
 	Error(msg string, fields ...zapcore.Field)
+	With(fields ...zapcore.Field) *zap.Logger
+	Named(s string) *zap.Logger
 }
 
 func NewLogger() (*zap.Logger, error) {
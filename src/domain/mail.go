@@ -0,0 +1,11 @@
+package domain
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type MailSender interface {
+	Send(m Mail) error
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const AuditIdentityLink = "identity.link"
+
+var ErrOIDCDisabled = errors.New("OIDC login is not configured")
+
+// OIDCState is what the callback needs to finish the login the user started, it's single-use.
+type OIDCState struct {
+	Hash         string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+// OIDCClaims are the ID token claims the user is identified by.
+type OIDCClaims struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// OIDCDiscovery is the part of the provider metadata the login needs.
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
@@ -0,0 +1,49 @@
+package domain
+
+import "strings"
+
+const ProblemContentType = "application/problem+json"
+
+// Problem types, errors without one of their own are about:blank and titled by the status.
+const (
+	ProblemBlank      = "about:blank"
+	ProblemValidation = "urn:gonah:problem:validation"
+	ProblemNotFound   = "urn:gonah:problem:not-found"
+	ProblemConflict   = "urn:gonah:problem:conflict"
+	ProblemModified   = "urn:gonah:problem:modified"
+	ProblemForbidden  = "urn:gonah:problem:forbidden"
+	ProblemKeyReused  = "urn:gonah:problem:idempotency-key-reused"
+)
+
+// Problem is the body of the error responses, see RFC 7807.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"` // path of the request
+	RequestId string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"` // of the validation problems
+}
+
+type FieldError struct {
+	Field   string `json:"field"` // dotted path in the body, or the name of the parameter
+	Message string `json:"message"`
+}
+
+// ValidationError lists what's wrong with the request, it's responded with 400.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func NewValidationError(field, msg string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{field, msg}}}
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return "invalid request: " + strings.Join(msgs, "; ")
+}
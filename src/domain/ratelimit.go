@@ -0,0 +1,30 @@
+package domain
+
+import "time"
+
+// Clients of the rate limit policies.
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByClient = "client" // the API key or the user, the IP of anonymous requests
+)
+
+// RateLimitPolicy is a token bucket per client: Requests per Per are allowed in the long run, Burst of them at once.
+type RateLimitPolicy struct {
+	Name     string        `yaml:"name"`
+	By       string        `yaml:"by"`
+	Routes   []string      `yaml:"routes"` // "POST /api/v1/users/:id" or "/api/v1/users/:id" for any method, all routes if empty
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"`
+	Burst    int           `yaml:"burst"` // Requests if zero
+}
+
+// RateLimit is the bucket of a client after a request took a token from it.
+type RateLimit struct {
+	Allowed bool
+	Tokens  float64 // left in the bucket
+}
+
+type RateLimitStore interface {
+	// Take takes a token from the bucket of the key, the bucket is refilled by rate tokens per second up to burst.
+	Take(key string, rate float64, burst int) (RateLimit, error)
+}
@@ -0,0 +1,32 @@
+package domain
+
+import "context"
+
+// RequestIdHeader correlates a request with its logs, its response and the Kafka messages it produced.
+const RequestIdHeader = "X-Request-ID"
+
+type requestIdKey struct{}
+
+type loggerKey struct{}
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+// RequestId is empty outside of requests.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+func WithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// LoggerFrom returns the request-scoped logger, fallback outside of requests.
+func LoggerFrom(ctx context.Context, fallback Logger) Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return logger
+	}
+	return fallback
+}
@@ -0,0 +1,20 @@
+package domain
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+
+	PermUsersRead   = "users:read"
+	PermUsersDelete = "users:delete"
+	PermRolesRead   = "roles:read"
+	PermRolesManage = "roles:manage"
+
+	PermSessionsManage = "sessions:manage"
+)
+
+type Role struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,96 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SCIM 2.0 resources and messages, see RFC 7643 and RFC 7644.
+const (
+	SCIMContentType = "application/scim+json"
+
+	SCIMSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+
+	// scimType values of the errors
+	SCIMInvalidFilter = "invalidFilter"
+	SCIMInvalidSyntax = "invalidSyntax"
+	SCIMInvalidPath   = "invalidPath"
+	SCIMInvalidValue  = "invalidValue"
+	SCIMNoTarget      = "noTarget"
+	SCIMUniqueness    = "uniqueness"
+	SCIMMutability    = "mutability"
+
+	PermSCIMProvision = "scim:provision"
+)
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+	Version      string    `json:"version"`
+}
+
+type SCIMUser struct {
+	Schemas    []string     `json:"schemas"`
+	Id         string       `json:"id,omitempty"`
+	ExternalId string       `json:"externalId,omitempty"`
+	UserName   string       `json:"userName"`
+	Active     *bool        `json:"active,omitempty"`
+	Emails     []SCIMEmail  `json:"emails,omitempty"`
+	Groups     []SCIMMember `json:"groups,omitempty"`
+	Meta       *SCIMMeta    `json:"meta,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMGroup struct {
+	Schemas     []string     `json:"schemas"`
+	Id          string       `json:"id,omitempty"`
+	ExternalId  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type SCIMPatch struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []SCIMPatchOp `json:"Operations"`
+}
+
+// SCIMPatchOp value is kept raw, its type depends on the path.
+type SCIMPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	SCIMType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
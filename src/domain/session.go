@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+const AuditSessionRevoke = "session.revoke"
+
+// Session is a login on some device, it lives as long as its refresh token family.
+type Session struct {
+	Id         int       `json:"id"`
+	UserId     int       `json:"user_id"`
+	Family     string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // the session of the request
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+const PrincipalKey = "principal"
+
+var (
+	ErrTokenExpired = errors.New("token expired")
+	ErrTokenReused  = errors.New("token already used")
+)
+
+// Principal is the authenticated caller of the request.
+// Requests made with an API key act on behalf of the key owner, limited to the key scopes.
+type Principal struct {
+	UserId    int
+	Login     string
+	SessionId int // of access tokens, revoking the session invalidates them at once
+	ApiKeyId  int
+	Scopes    []string
+}
+
+type RefreshToken struct {
+	Id        int
+	UserId    int
+	Family    string
+	Hash      string
+	ExpiresAt time.Time
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
@@ -0,0 +1,41 @@
+package domain
+
+import "errors"
+
+const TokenMFALogin = "mfa_login"
+
+var ErrTotpEnabled = errors.New("two-factor authentication is already enabled")
+
+// Totp is the TOTP enrollment of the user, it's pending until the first code is verified.
+type Totp struct {
+	UserId   int
+	Secret   string // encrypted
+	Enabled  bool
+	LastStep int64 // codes of this and earlier time steps can't be used again
+}
+
+type TotpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TotpCode is either a TOTP code or a recovery code.
+type TotpCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// MFAChallenge is returned by the login instead of tokens when the user has 2FA enabled.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type MFALogin struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
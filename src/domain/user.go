@@ -1,8 +1,65 @@
 package domain
 
+import (
+	"errors"
+	"time"
+)
+
+const (
+	UserPending  = "pending" // waits for the email confirmation
+	UserActive   = "active"
+	UserDisabled = "disabled" // deprovisioned, can't log in
+
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+)
+
+// ErrNotPending is returned by the verification of a user who is active or disabled already.
+var ErrNotPending = errors.New("user is not pending verification")
+
 type User struct {
-	Id    int    `json:"id"`
-	Login string `json:"login" validate:"required"`
+	Id           int       `json:"id"`
+	Login        string    `json:"login" validate:"required"`
+	Email        string    `json:"email" validate:"required,email"`
+	Status       string    `json:"status"`
+	Password     string    `json:"password,omitempty"`
+	PasswordHash string    `json:"-"`
+	ExternalId   string    `json:"-"` // of the provisioning IdP
+	CreatedAt    time.Time `json:"-"`
+	UpdatedAt    time.Time `json:"-"`
+	Version      int       `json:"-"` // bumped on every change, it's the ETag
+}
+
+type UserFilter struct {
+	Login      string
+	ExternalId string
+}
+
+type Credentials struct {
+	Login    string `json:"login" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UserToken is a single-use secret mailed to the user, only its hash is stored.
+type UserToken struct {
+	Id        int
+	UserId    int
+	Purpose   string
+	Hash      string
+	ExpiresAt time.Time
+}
+
+type VerifyRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type PasswordResetConfirm struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
 }
 
 func (u *User) getId() int {
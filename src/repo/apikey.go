@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type ApiKeyRepo struct {
+	db domain.DB
+}
+
+func NewApiKeyRepository(db domain.DB) *ApiKeyRepo {
+	return &ApiKeyRepo{db}
+}
+
+func (r *ApiKeyRepo) Create(key *domain.ApiKey) (err error) {
+	q := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err = r.db.QueryRow(context.Background(), q, key.UserId, key.Name, key.Prefix, key.Hash, key.Scopes, key.ExpiresAt).
+		Scan(&key.Id, &key.CreatedAt)
+	return
+}
+
+// GetAll returns active keys of the user.
+func (r *ApiKeyRepo) GetAll(userId int) (ret []domain.ApiKey, err error) {
+	q := `SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+		FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY id`
+	rows, err := r.db.Query(context.Background(), q, userId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.ApiKey{}
+	for rows.Next() {
+		var k domain.ApiKey
+		err = rows.Scan(&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt)
+		if err != nil {
+			return
+		}
+		ret = append(ret, k)
+	}
+	return ret, rows.Err()
+}
+
+// GetByPrefix returns the not revoked key with the owner's login, keys of disabled users are skipped.
+func (r *ApiKeyRepo) GetByPrefix(prefix string) (k domain.ApiKey, err error) {
+	q := `SELECT k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.created_at, k.expires_at, k.last_used_at, u.login
+		FROM api_keys k JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = $1 AND k.revoked_at IS NULL AND u.status <> $2`
+	err = r.db.QueryRow(context.Background(), q, prefix, domain.UserDisabled).
+		Scan(&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.Hash, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt, &k.Login)
+	return
+}
+
+// Revoke revokes the user's key, domain.ErrNoRows is returned if there is no such active key.
+func (r *ApiKeyRepo) Revoke(id, userId int) error {
+	q := `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+	tag, err := r.db.Exec(context.Background(), q, id, userId)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = domain.ErrNoRows
+	}
+	return err
+}
+
+// Touch updates the last usage time, at most once a minute to spare the DB on busy keys.
+func (r *ApiKeyRepo) Touch(id int) error {
+	q := `UPDATE api_keys SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	_, err := r.db.Exec(context.Background(), q, id)
+	return err
+}
@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type AuditRepo struct {
+	db domain.DB
+}
+
+func NewAuditRepository(db domain.DB) *AuditRepo {
+	return &AuditRepo{db}
+}
+
+func (r *AuditRepo) Create(e *domain.AuditEntry) error {
+	return insertAudit(r.db, e)
+}
+
+// insertAudit is called by other repositories in the transaction of the audited change.
+func insertAudit(q querier, e *domain.AuditEntry) error {
+	sql := `INSERT INTO audit_log (actor_id, action, target, request_id, ip, details, before, after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at`
+	return q.QueryRow(
+		context.Background(), sql,
+		e.ActorId, e.Action, e.Target, e.RequestId, e.IP, jsonOrNull(e.Details), jsonOrNull(e.Before), jsonOrNull(e.After),
+	).Scan(&e.Id, &e.CreatedAt)
+}
+
+// jsonOrNull stores empty maps as NULL instead of JSON null or {}.
+func jsonOrNull(m map[string]interface{}) interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// GetPage returns up to f.Limit entries matching the filter, newest first.
+func (r *AuditRepo) GetPage(f domain.AuditFilter) (ret []domain.AuditEntry, err error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.ActorId != 0 {
+		add("actor_id = ?", f.ActorId)
+	}
+	if f.Action != "" {
+		add("action = ?", f.Action)
+	}
+	if f.Target != "" {
+		add("target = ?", f.Target)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < ?", f.Until)
+	}
+	if f.BeforeId != 0 {
+		add("id < ?", f.BeforeId)
+	}
+
+	q := `SELECT id, created_at, actor_id, action, target, request_id, ip, details, before, after FROM audit_log`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	q += " ORDER BY id DESC LIMIT $" + strconv.Itoa(len(args))
+
+	rows, err := r.db.Query(context.Background(), q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.AuditEntry{}
+	for rows.Next() {
+		var e domain.AuditEntry
+		err = rows.Scan(&e.Id, &e.CreatedAt, &e.ActorId, &e.Action, &e.Target, &e.RequestId, &e.IP, &e.Details, &e.Before, &e.After)
+		if err != nil {
+			return
+		}
+		ret = append(ret, e)
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestAudit(t *testing.T) {
+	users := NewUserRepository(db)
+	audit := NewAuditRepository(db)
+
+	actor := 1000
+	user := &domain.User{Login: "audited"}
+	require.NoError(t, users.Create(user, &domain.AuditEntry{Action: domain.AuditUserCreate, ActorId: &actor, IP: "10.0.0.1"}))
+	require.NoError(t, users.UpdatePasswordHash(user.Id, "hash", &domain.AuditEntry{Action: domain.AuditUserUpdate}))
+	require.NoError(t, users.Delete(user.Id, 0, &domain.AuditEntry{Action: domain.AuditUserDelete, ActorId: &actor}))
+
+	// a failed change leaves no audit entry
+	require.ErrorIs(t, users.Delete(user.Id, 0, &domain.AuditEntry{Action: domain.AuditUserDelete}), domain.ErrNoRows)
+
+	entries, err := audit.GetPage(domain.AuditFilter{Target: userTarget(user.Id), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, domain.AuditUserDelete, entries[0].Action)
+	require.Equal(t, "audited", entries[0].Before["login"])
+	require.Nil(t, entries[0].After)
+	require.Equal(t, domain.AuditUserUpdate, entries[1].Action)
+	require.NotContains(t, entries[1].After, "password")
+	require.Equal(t, domain.AuditUserCreate, entries[2].Action)
+	require.Equal(t, "audited", entries[2].After["login"])
+	require.Equal(t, "10.0.0.1", entries[2].IP)
+
+	entries, err = audit.GetPage(domain.AuditFilter{ActorId: actor, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	entries, err = audit.GetPage(domain.AuditFilter{ActorId: actor, BeforeId: entries[0].Id, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, domain.AuditUserCreate, entries[0].Action)
+
+	_, err = db.Exec(context.Background(), `UPDATE audit_log SET action = 'forged'`)
+	require.Error(t, err)
+	_, err = db.Exec(context.Background(), `DELETE FROM audit_log`)
+	require.Error(t, err)
+}
@@ -0,0 +1,252 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// personalKeys are the personal data of the audit snapshots and details, they are dropped on erasure.
+var personalKeys = []string{"login", "email"}
+
+// ComplianceRepo handles data subject requests: the export and the erasure of everything stored about a user.
+type ComplianceRepo struct {
+	db domain.DB
+}
+
+func NewComplianceRepository(db domain.DB) *ComplianceRepo {
+	return &ComplianceRepo{db}
+}
+
+// Export collects the data of the user in one transaction, so the parts are consistent.
+// domain.ErrNoRows is returned if there is no such user.
+func (r *ComplianceRepo) Export(userId int, entry *domain.ComplianceEntry) (ret domain.UserExport, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		ret = domain.UserExport{
+			ExportedAt:  time.Now().UTC(),
+			Roles:       []string{},
+			Memberships: []domain.UserMembership{},
+			Sessions:    []domain.Session{},
+			ApiKeys:     []domain.ApiKey{},
+			Identities:  []domain.UserIdentity{},
+			Invitations: []domain.Invitation{},
+		}
+		p := &ret.Profile
+		q := `SELECT id, login, coalesce(email, ''), status, coalesce(external_id, ''), created_at, updated_at
+			FROM users WHERE id = $1`
+		err := tx.QueryRow(context.Background(), q, userId).
+			Scan(&p.Id, &p.Login, &p.Email, &p.Status, &p.ExternalId, &p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		steps := []func(tx pgx.Tx, ret *domain.UserExport) error{
+			exportRoles, exportMemberships, exportSessions, exportApiKeys, exportIdentities, exportInvitations, exportAudit,
+		}
+		for _, step := range steps {
+			if err = step(tx, &ret); err != nil {
+				return err
+			}
+		}
+		q = `SELECT count(*) > 0 FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL`
+		if err = tx.QueryRow(context.Background(), q, userId).Scan(&ret.TOTPEnabled); err != nil {
+			return err
+		}
+
+		entry.Request, entry.SubjectId = domain.ComplianceExport, userId
+		return insertCompliance(tx, entry)
+	})
+	return
+}
+
+// Erase deletes the user with everything referencing them and anonymizes the audit log,
+// the entries stay but lose the IPs, logins and emails. domain.ErrNoRows is returned if there is no such user.
+func (r *ComplianceRepo) Erase(userId int, entry *domain.ComplianceEntry, audit *domain.AuditEntry) (ret domain.Erasure, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		var login, email string
+		q := `SELECT login, coalesce(email, '') FROM users WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRow(context.Background(), q, userId).Scan(&login, &email); err != nil {
+			return err
+		}
+		ret.UserId = userId
+
+		var err error
+		q = `DELETE FROM group_members WHERE user_id = $1 RETURNING group_id`
+		if ret.Groups, err = queryInts(tx, q, userId); err != nil {
+			return err
+		}
+		for _, groupId := range ret.Groups {
+			if err = touchGroup(tx, groupId); err != nil {
+				return err
+			}
+		}
+
+		q = `DELETE FROM invitations WHERE lower(email) = lower($1)`
+		tag, err := tx.Exec(context.Background(), q, email)
+		if err != nil {
+			return err
+		}
+		ret.DeletedInvitations = int(tag.RowsAffected())
+
+		q = `DELETE FROM login_failures WHERE key = $1`
+		if _, err = tx.Exec(context.Background(), q, domain.LoginKey(login)); err != nil {
+			return err
+		}
+		// The rest references the user and is deleted with them
+		q = `DELETE FROM users WHERE id = $1`
+		if _, err = tx.Exec(context.Background(), q, userId); err != nil {
+			return err
+		}
+
+		if ret.AnonymizedAudit, err = anonymizeAudit(tx, userId, email); err != nil {
+			return err
+		}
+
+		entry.Request, entry.SubjectId = domain.ComplianceErasure, userId
+		entry.Details = map[string]interface{}{
+			"groups":                   ret.Groups,
+			"anonymized_audit_entries": ret.AnonymizedAudit,
+			"deleted_invitations":      ret.DeletedInvitations,
+		}
+		if err = insertCompliance(tx, entry); err != nil {
+			return err
+		}
+		audit.Target = userTarget(userId)
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+// anonymizeAudit drops the personal data of the entries made by the user or about them,
+// as well as the emails the user has been invited with.
+func anonymizeAudit(tx pgx.Tx, userId int, email string) (int, error) {
+	// The append-only trigger lets the transaction through
+	q := `SELECT set_config('gonah.erasure', 'on', true)`
+	if _, err := tx.Exec(context.Background(), q); err != nil {
+		return 0, err
+	}
+
+	q = `UPDATE audit_log SET ip = '', before = before - $3::text[], after = after - $3::text[],
+			details = CASE WHEN lower(details->>'email') = lower($4) THEN details - 'email' ELSE details END
+		WHERE target = $1 OR actor_id = $2 OR ($4 <> '' AND lower(details->>'email') = lower($4))`
+	tag, err := tx.Exec(context.Background(), q, userTarget(userId), userId, personalKeys, email)
+	if err != nil {
+		return 0, err
+	}
+
+	q = `SELECT set_config('gonah.erasure', 'off', true)`
+	_, err = tx.Exec(context.Background(), q)
+	return int(tag.RowsAffected()), err
+}
+
+func insertCompliance(q querier, e *domain.ComplianceEntry) error {
+	sql := `INSERT INTO compliance_log (request, subject_id, actor_id, request_id, details)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	return q.QueryRow(context.Background(), sql, e.Request, e.SubjectId, e.ActorId, e.RequestId, jsonOrNull(e.Details)).
+		Scan(&e.Id, &e.CreatedAt)
+}
+
+func exportRoles(tx pgx.Tx, ret *domain.UserExport) error {
+	q := `SELECT r.name FROM roles r JOIN user_roles ur ON ur.role_id = r.id WHERE ur.user_id = $1 ORDER BY r.name`
+	return exportRows(tx, q, ret.Profile.Id, func(rows pgx.Rows) error {
+		var role string
+		err := rows.Scan(&role)
+		ret.Roles = append(ret.Roles, role)
+		return err
+	})
+}
+
+func exportMemberships(tx pgx.Tx, ret *domain.UserExport) error {
+	q := `SELECT g.id, g.name, m.role, m.created_at FROM group_members m JOIN groups g ON g.id = m.group_id
+		WHERE m.user_id = $1 ORDER BY g.id`
+	return exportRows(tx, q, ret.Profile.Id, func(rows pgx.Rows) error {
+		var m domain.UserMembership
+		err := rows.Scan(&m.GroupId, &m.GroupName, &m.Role, &m.JoinedAt)
+		ret.Memberships = append(ret.Memberships, m)
+		return err
+	})
+}
+
+// exportSessions includes the revoked sessions, they are stored still.
+func exportSessions(tx pgx.Tx, ret *domain.UserExport) error {
+	q := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at FROM sessions WHERE user_id = $1 ORDER BY id`
+	return exportRows(tx, q, ret.Profile.Id, func(rows pgx.Rows) error {
+		var s domain.Session
+		err := rows.Scan(&s.Id, &s.UserId, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt)
+		ret.Sessions = append(ret.Sessions, s)
+		return err
+	})
+}
+
+func exportApiKeys(tx pgx.Tx, ret *domain.UserExport) error {
+	q := `SELECT id, user_id, name, prefix, scopes, created_at, expires_at, last_used_at
+		FROM api_keys WHERE user_id = $1 ORDER BY id`
+	return exportRows(tx, q, ret.Profile.Id, func(rows pgx.Rows) error {
+		var k domain.ApiKey
+		err := rows.Scan(&k.Id, &k.UserId, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedAt, &k.ExpiresAt, &k.LastUsedAt)
+		ret.ApiKeys = append(ret.ApiKeys, k)
+		return err
+	})
+}
+
+func exportIdentities(tx pgx.Tx, ret *domain.UserExport) error {
+	q := `SELECT issuer, subject, created_at FROM user_identities WHERE user_id = $1 ORDER BY id`
+	return exportRows(tx, q, ret.Profile.Id, func(rows pgx.Rows) error {
+		var i domain.UserIdentity
+		err := rows.Scan(&i.Issuer, &i.Subject, &i.CreatedAt)
+		ret.Identities = append(ret.Identities, i)
+		return err
+	})
+}
+
+func exportInvitations(tx pgx.Tx, ret *domain.UserExport) error {
+	if ret.Profile.Email == "" {
+		return nil
+	}
+	q := `SELECT ` + invitationColumns + ` FROM invitations WHERE lower(email) = lower($1) ORDER BY id`
+	return exportRows(tx, q, ret.Profile.Email, func(rows pgx.Rows) error {
+		var inv domain.Invitation
+		err := scanInvitation(rows, &inv)
+		ret.Invitations = append(ret.Invitations, inv)
+		return err
+	})
+}
+
+func exportAudit(tx pgx.Tx, ret *domain.UserExport) error {
+	q := `SELECT id, created_at, actor_id, action, target, request_id, ip, details, before, after
+		FROM audit_log WHERE actor_id = $1 OR target = $2 ORDER BY id`
+	rows, err := tx.Query(context.Background(), q, ret.Profile.Id, userTarget(ret.Profile.Id))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ret.AuditEntries = []domain.AuditEntry{}
+	for rows.Next() {
+		var e domain.AuditEntry
+		err = rows.Scan(&e.Id, &e.CreatedAt, &e.ActorId, &e.Action, &e.Target, &e.RequestId, &e.IP, &e.Details, &e.Before, &e.After)
+		if err != nil {
+			return err
+		}
+		ret.AuditEntries = append(ret.AuditEntries, e)
+	}
+	return rows.Err()
+}
+
+func exportRows(tx pgx.Tx, q string, arg interface{}, scan func(rows pgx.Rows) error) error {
+	rows, err := tx.Query(context.Background(), q, arg)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
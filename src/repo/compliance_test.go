@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestErase(t *testing.T) {
+	users := NewUserRepository(db)
+	audit := NewAuditRepository(db)
+	compliance := NewComplianceRepository(db)
+
+	user := &domain.User{Login: "erased", Email: "erased@example.com", Status: domain.UserActive}
+	require.NoError(t, users.Create(user, &domain.AuditEntry{Action: domain.AuditUserCreate, IP: "10.0.0.2"}))
+	require.NoError(t, audit.Create(&domain.AuditEntry{Action: domain.AuditAccessDenied, ActorId: &user.Id, Target: "GET /api/v1/audit", IP: "10.0.0.2"}))
+
+	export, err := compliance.Export(user.Id, &domain.ComplianceEntry{})
+	require.NoError(t, err)
+	require.Equal(t, "erased@example.com", export.Profile.Email)
+	require.Equal(t, []string{"user"}, export.Roles)
+	require.Len(t, export.AuditEntries, 2)
+
+	erasure, err := compliance.Erase(user.Id, &domain.ComplianceEntry{}, &domain.AuditEntry{Action: domain.AuditUserErase})
+	require.NoError(t, err)
+	require.Equal(t, 2, erasure.AnonymizedAudit)
+	_, err = users.GetById(user.Id)
+	require.ErrorIs(t, err, domain.ErrNoRows)
+	_, err = compliance.Erase(user.Id, &domain.ComplianceEntry{}, &domain.AuditEntry{Action: domain.AuditUserErase})
+	require.ErrorIs(t, err, domain.ErrNoRows)
+
+	entries, err := audit.GetPage(domain.AuditFilter{Target: userTarget(user.Id), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, domain.AuditUserErase, entries[0].Action)
+	require.Equal(t, domain.AuditUserCreate, entries[1].Action)
+	require.NotContains(t, entries[1].After, "login")
+	require.NotContains(t, entries[1].After, "email")
+	require.Equal(t, domain.UserActive, entries[1].After["status"])
+	require.Empty(t, entries[1].IP)
+
+	var requests int
+	q := `SELECT count(*) FROM compliance_log WHERE subject_id = $1`
+	require.NoError(t, db.QueryRow(context.Background(), q, user.Id).Scan(&requests))
+	require.Equal(t, 2, requests)
+
+	// the exception is limited to the erasure transaction
+	_, err = db.Exec(context.Background(), `UPDATE audit_log SET ip = ''`)
+	require.Error(t, err)
+	_, err = db.Exec(context.Background(), `DELETE FROM compliance_log`)
+	require.Error(t, err)
+}
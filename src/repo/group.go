@@ -0,0 +1,408 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type GroupRepo struct {
+	db domain.DB
+}
+
+func NewGroupRepository(db domain.DB) *GroupRepo {
+	return &GroupRepo{db}
+}
+
+// Find returns the page of the groups matching the filter ordered by id, along with the total number of matches.
+func (r *GroupRepo) Find(f domain.GroupFilter, offset, limit int) (ret []domain.Group, total int, err error) {
+	where := `($1 = '' OR lower(name) = lower($1)) AND ($2 = '' OR external_id = $2)`
+	q := `SELECT count(*) FROM groups WHERE ` + where
+	if err = r.db.QueryRow(context.Background(), q, f.Name, f.ExternalId).Scan(&total); err != nil {
+		return
+	}
+
+	q = `SELECT ` + groupColumns + ` FROM groups WHERE ` + where + ` ORDER BY id OFFSET $3 LIMIT $4`
+	ret, err = r.query(q, f.Name, f.ExternalId, offset, limit)
+	return
+}
+
+func (r *GroupRepo) GetById(id int) (g domain.Group, err error) {
+	q := `SELECT ` + groupColumns + ` FROM groups WHERE id = $1`
+	err = scanGroup(r.db.QueryRow(context.Background(), q, id), &g)
+	return
+}
+
+// GetByUser returns the groups the user is a member of.
+func (r *GroupRepo) GetByUser(userId int) ([]domain.Group, error) {
+	q := `SELECT ` + groupColumns + ` FROM groups
+		WHERE id IN (SELECT group_id FROM group_members WHERE user_id = $1) ORDER BY id`
+	return r.query(q, userId)
+}
+
+// Members returns all the members of the group.
+func (r *GroupRepo) Members(groupId int) ([]domain.Membership, error) {
+	q := `SELECT ` + membershipColumns + ` FROM group_members m JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1 ORDER BY u.id`
+	return queryMemberships(r.db, q, groupId)
+}
+
+// FindMembers returns the page of the members ordered by user id, along with the total number of members.
+func (r *GroupRepo) FindMembers(groupId, offset, limit int) (ret []domain.Membership, total int, err error) {
+	q := `SELECT count(*) FROM group_members WHERE group_id = $1`
+	if err = r.db.QueryRow(context.Background(), q, groupId).Scan(&total); err != nil {
+		return
+	}
+	q = `SELECT ` + membershipColumns + ` FROM group_members m JOIN users u ON u.id = m.user_id
+		WHERE m.group_id = $1 ORDER BY u.id OFFSET $2 LIMIT $3`
+	ret, err = queryMemberships(r.db, q, groupId, offset, limit)
+	return
+}
+
+// MemberRole returns the role of the user in the group, domain.ErrNoRows is returned if the user isn't a member.
+func (r *GroupRepo) MemberRole(groupId, userId int) (role string, err error) {
+	q := `SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2`
+	err = r.db.QueryRow(context.Background(), q, groupId, userId).Scan(&role)
+	return
+}
+
+// Create stores the group with the members, domain.ErrConflict is returned if the name is taken
+// and domain.ErrUnknownMember if some of the members don't exist.
+func (r *GroupRepo) Create(g *domain.Group, members []domain.Membership, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `INSERT INTO groups (name, description, external_id) VALUES ($1, $2, NULLIF($3, ''))
+			RETURNING id, created_at, updated_at, version`
+		err := tx.QueryRow(context.Background(), q, g.Name, g.Description, g.ExternalId).
+			Scan(&g.Id, &g.CreatedAt, &g.UpdatedAt, &g.Version)
+		if err != nil {
+			return conflict(err)
+		}
+
+		ids := make([]int, 0, len(members))
+		roles := make([]string, 0, len(members))
+		for _, m := range members {
+			ids = append(ids, m.UserId)
+			roles = append(roles, m.Role)
+		}
+		if err = checkUsers(tx, ids); err != nil {
+			return err
+		}
+		q = `INSERT INTO group_members (group_id, user_id, role) SELECT $1, unnest($2::int[]), unnest($3::text[])
+			ON CONFLICT DO NOTHING`
+		if _, err = tx.Exec(context.Background(), q, g.Id, ids, roles); err != nil {
+			return err
+		}
+
+		audit.Target = groupTarget(g.Id)
+		audit.Before, audit.After = diff(nil, groupSnapshot(g))
+		if len(ids) > 0 {
+			audit.Details = map[string]interface{}{"added": ids}
+		}
+		return insertAudit(tx, audit)
+	})
+}
+
+// Update replaces the name, description and external id of the group, and the members unless they are nil.
+// New members join as regular ones, the roles of the rest are kept. If version isn't zero,
+// domain.ErrVersionMismatch is returned when the group has changed since.
+// domain.ErrNoRows is returned if there is no such group.
+func (r *GroupRepo) Update(g *domain.Group, members []int, version int, audit *domain.AuditEntry) (added, removed []int, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		var before domain.Group
+		q := `SELECT ` + groupColumns + ` FROM groups WHERE id = $1 FOR UPDATE`
+		if err := scanGroup(tx.QueryRow(context.Background(), q, g.Id), &before); err != nil {
+			return err
+		}
+		if version != 0 && version != before.Version {
+			return domain.ErrVersionMismatch
+		}
+
+		q = `UPDATE groups SET name = $2, description = $3, external_id = NULLIF($4, ''), updated_at = now(), version = version + 1
+			WHERE id = $1 RETURNING created_at, updated_at, version`
+		err := tx.QueryRow(context.Background(), q, g.Id, g.Name, g.Description, g.ExternalId).
+			Scan(&g.CreatedAt, &g.UpdatedAt, &g.Version)
+		if err != nil {
+			return conflict(err)
+		}
+
+		audit.Target = groupTarget(g.Id)
+		audit.Before, audit.After = diff(groupSnapshot(&before), groupSnapshot(g))
+		if members != nil {
+			if added, removed, err = setMembers(tx, g.Id, members); err != nil {
+				return err
+			}
+			audit.Details = map[string]interface{}{}
+			if len(added) > 0 {
+				audit.Details["added"] = added
+			}
+			if len(removed) > 0 {
+				audit.Details["removed"] = removed
+			}
+		}
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+// SetMember adds the user to the group or changes the role, the previous role is returned,
+// it's empty if the user has just joined. domain.ErrNoRows is returned if there is no such group,
+// domain.ErrUnknownMember if there is no such user and domain.ErrLastOwner if the only owner is demoted.
+func (r *GroupRepo) SetMember(groupId, userId int, role string, audit *domain.AuditEntry) (previous string, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		if err := lockGroup(tx, groupId); err != nil {
+			return err
+		}
+		if err := checkUsers(tx, []int{userId}); err != nil {
+			return err
+		}
+		q := `SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2`
+		err := tx.QueryRow(context.Background(), q, groupId, userId).Scan(&previous)
+		if err != nil && !errors.Is(err, domain.ErrNoRows) {
+			return err
+		}
+		if previous == role {
+			return nil // nothing to audit
+		}
+		if previous == domain.GroupOwner {
+			if err = checkOtherOwners(tx, groupId, userId); err != nil {
+				return err
+			}
+		}
+
+		q = `INSERT INTO group_members (group_id, user_id, role) VALUES ($1, $2, $3)
+			ON CONFLICT (group_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+		if _, err = tx.Exec(context.Background(), q, groupId, userId, role); err != nil {
+			return err
+		}
+		if err = touchGroup(tx, groupId); err != nil {
+			return err
+		}
+
+		audit.Target = groupTarget(groupId)
+		audit.Details = map[string]interface{}{"user_id": userId}
+		if previous != "" {
+			audit.Before = map[string]interface{}{"role": previous}
+		}
+		audit.After = map[string]interface{}{"role": role}
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+// RemoveMember removes the user from the group and returns the role the user had.
+// domain.ErrNoRows is returned if the user isn't a member and domain.ErrLastOwner if it's the only owner.
+func (r *GroupRepo) RemoveMember(groupId, userId int, audit *domain.AuditEntry) (role string, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		if err := lockGroup(tx, groupId); err != nil {
+			return err
+		}
+		q := `DELETE FROM group_members WHERE group_id = $1 AND user_id = $2 RETURNING role`
+		if err := tx.QueryRow(context.Background(), q, groupId, userId).Scan(&role); err != nil {
+			return err
+		}
+		if role == domain.GroupOwner {
+			if err := checkOtherOwners(tx, groupId, userId); err != nil {
+				return err
+			}
+		}
+		if err := touchGroup(tx, groupId); err != nil {
+			return err
+		}
+
+		audit.Target = groupTarget(groupId)
+		audit.Details = map[string]interface{}{"user_id": userId}
+		audit.Before = map[string]interface{}{"role": role}
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+// Delete removes the group and returns the users who were its members.
+// domain.ErrNoRows is returned if there is no such group.
+// Delete returns domain.ErrVersionMismatch if version isn't the current one, zero means any version.
+func (r *GroupRepo) Delete(id, version int, audit *domain.AuditEntry) (members []int, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		var current int
+		q := `SELECT version FROM groups WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRow(context.Background(), q, id).Scan(&current); err != nil {
+			return err
+		}
+		if version != 0 && version != current {
+			return domain.ErrVersionMismatch
+		}
+		q = `DELETE FROM group_members WHERE group_id = $1 RETURNING user_id`
+		if members, err = queryInts(tx, q, id); err != nil {
+			return err
+		}
+		var g domain.Group
+		q = `DELETE FROM groups WHERE id = $1 RETURNING ` + groupColumns
+		if err := scanGroup(tx.QueryRow(context.Background(), q, id), &g); err != nil {
+			return err
+		}
+
+		audit.Target = groupTarget(id)
+		audit.Before, audit.After = diff(groupSnapshot(&g), nil)
+		if len(members) > 0 {
+			audit.Details = map[string]interface{}{"removed": members}
+		}
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+func (r *GroupRepo) query(q string, args ...interface{}) (ret []domain.Group, err error) {
+	rows, err := r.db.Query(context.Background(), q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.Group{}
+	for rows.Next() {
+		var g domain.Group
+		if err = scanGroup(rows, &g); err != nil {
+			return
+		}
+		ret = append(ret, g)
+	}
+	return ret, rows.Err()
+}
+
+// setMembers makes the users the only members of the group and returns who was added and removed.
+// domain.ErrLastOwner is returned if all the owners would be removed, groups provisioned without owners
+// don't need them. The group is locked by the caller.
+func setMembers(tx pgx.Tx, groupId int, members []int) (added, removed []int, err error) {
+	if err = checkUsers(tx, members); err != nil {
+		return
+	}
+	var owners, kept int
+	q := `SELECT count(*), count(*) FILTER (WHERE user_id = ANY($3)) FROM group_members WHERE group_id = $1 AND role = $2`
+	if err = tx.QueryRow(context.Background(), q, groupId, domain.GroupOwner, members).Scan(&owners, &kept); err != nil {
+		return
+	}
+	if owners > 0 && kept == 0 {
+		return nil, nil, domain.ErrLastOwner
+	}
+	q = `DELETE FROM group_members WHERE group_id = $1 AND NOT user_id = ANY($2) RETURNING user_id`
+	if removed, err = queryInts(tx, q, groupId, members); err != nil {
+		return
+	}
+	q = `INSERT INTO group_members (group_id, user_id) SELECT $1, unnest($2::int[])
+		ON CONFLICT DO NOTHING RETURNING user_id`
+	added, err = queryInts(tx, q, groupId, members)
+	return
+}
+
+// checkUsers returns domain.ErrUnknownMember if some of the users don't exist.
+func checkUsers(q querier, ids []int) error {
+	if ids == nil {
+		ids = []int{}
+	}
+	var known int
+	sql := `SELECT count(*) FROM users WHERE id = ANY($1)`
+	if err := q.QueryRow(context.Background(), sql, ids).Scan(&known); err != nil {
+		return err
+	}
+	if known != len(uniqueInts(ids)) {
+		return domain.ErrUnknownMember
+	}
+	return nil
+}
+
+// lockGroup serializes the membership changes of the group, so the owner checks don't race.
+func lockGroup(tx pgx.Tx, groupId int) error {
+	var id int
+	q := `SELECT id FROM groups WHERE id = $1 FOR UPDATE`
+	return tx.QueryRow(context.Background(), q, groupId).Scan(&id)
+}
+
+// checkOtherOwners returns domain.ErrLastOwner if the user is the only owner of the group.
+func checkOtherOwners(tx pgx.Tx, groupId, userId int) error {
+	var owners int
+	q := `SELECT count(*) FROM group_members WHERE group_id = $1 AND role = $2 AND user_id <> $3`
+	if err := tx.QueryRow(context.Background(), q, groupId, domain.GroupOwner, userId).Scan(&owners); err != nil {
+		return err
+	}
+	if owners == 0 {
+		return domain.ErrLastOwner
+	}
+	return nil
+}
+
+// touchGroup bumps the version of the group when its members change.
+func touchGroup(tx pgx.Tx, groupId int) error {
+	q := `UPDATE groups SET updated_at = now(), version = version + 1 WHERE id = $1`
+	_, err := tx.Exec(context.Background(), q, groupId)
+	return err
+}
+
+func queryInts(q querier, sql string, args ...interface{}) (ret []int, err error) {
+	rows, err := q.Query(context.Background(), sql, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []int{}
+	for rows.Next() {
+		var v int
+		if err = rows.Scan(&v); err != nil {
+			return
+		}
+		ret = append(ret, v)
+	}
+	return ret, rows.Err()
+}
+
+func uniqueInts(vs []int) map[int]struct{} {
+	ret := make(map[int]struct{}, len(vs))
+	for _, v := range vs {
+		ret[v] = struct{}{}
+	}
+	return ret
+}
+
+// groupColumns are read by scanGroup.
+const groupColumns = `id, name, description, coalesce(external_id, ''), created_at, updated_at, version`
+
+func scanGroup(row pgx.Row, g *domain.Group) error {
+	return row.Scan(&g.Id, &g.Name, &g.Description, &g.ExternalId, &g.CreatedAt, &g.UpdatedAt, &g.Version)
+}
+
+// membershipColumns are read by queryMemberships, m is group_members and u is users.
+const membershipColumns = `u.id, u.login, m.role, m.created_at`
+
+func queryMemberships(q querier, sql string, args ...interface{}) (ret []domain.Membership, err error) {
+	rows, err := q.Query(context.Background(), sql, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.Membership{}
+	for rows.Next() {
+		var m domain.Membership
+		if err = rows.Scan(&m.UserId, &m.Login, &m.Role, &m.JoinedAt); err != nil {
+			return
+		}
+		ret = append(ret, m)
+	}
+	return ret, rows.Err()
+}
+
+func groupTarget(id int) string {
+	return fmt.Sprintf("group:%d", id)
+}
+
+func groupSnapshot(g *domain.Group) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          g.Id,
+		"name":        g.Name,
+		"description": g.Description,
+		"external_id": g.ExternalId,
+	}
+}
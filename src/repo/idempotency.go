@@ -0,0 +1,76 @@
+package repo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const idempotencySweepInterval = time.Minute
+
+type IdempotencyRepo struct {
+	db      domain.DB
+	sweptAt int64 // unix seconds
+}
+
+func NewIdempotencyRepository(db domain.DB) *IdempotencyRepo {
+	return &IdempotencyRepo{db: db}
+}
+
+// Start takes the key for the request unless it's taken: then the first request with the key is returned.
+// Expired keys, and keys of the requests in progress for longer than abandonAfter, are taken over.
+func (r *IdempotencyRepo) Start(key, fingerprint string, ttl, abandonAfter time.Duration) (*domain.IdempotentRequest, error) {
+	r.sweep()
+	q := `INSERT INTO idempotency_keys AS ik (key, fingerprint, expires_at)
+			VALUES ($1, $2, now() + $3 * interval '1 second')
+		ON CONFLICT (key) DO UPDATE SET
+			fingerprint = excluded.fingerprint, status = NULL, content_type = '', body = NULL,
+			created_at = now(), expires_at = excluded.expires_at
+		WHERE ik.expires_at < now() OR ik.status IS NULL AND ik.created_at < now() - $4 * interval '1 second'
+		RETURNING key`
+	rows, err := r.db.Query(context.Background(), q, key, fingerprint, ttl.Seconds(), abandonAfter.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	taken := rows.Next()
+	rows.Close()
+	if err = rows.Err(); err != nil || taken {
+		return nil, err
+	}
+
+	first := &domain.IdempotentRequest{}
+	var status *int
+	q = `SELECT fingerprint, status, content_type, coalesce(body, '') FROM idempotency_keys WHERE key = $1`
+	err = r.db.QueryRow(context.Background(), q, key).Scan(&first.Fingerprint, &status, &first.ContentType, &first.Body)
+	if status != nil {
+		first.Status = *status
+	}
+	return first, err
+}
+
+// Complete stores the response of the request, it's replayed for the next requests with the key.
+func (r *IdempotencyRepo) Complete(key string, status int, contentType string, body []byte) error {
+	q := `UPDATE idempotency_keys SET status = $2, content_type = $3, body = $4 WHERE key = $1`
+	_, err := r.db.Exec(context.Background(), q, key, status, contentType, body)
+	return err
+}
+
+// Release frees the key of a failed request, so it can be retried.
+func (r *IdempotencyRepo) Release(key string) error {
+	q := `DELETE FROM idempotency_keys WHERE key = $1 AND status IS NULL`
+	_, err := r.db.Exec(context.Background(), q, key)
+	return err
+}
+
+// sweep deletes the expired keys once in a while, Start takes them over anyway.
+func (r *IdempotencyRepo) sweep() {
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&r.sweptAt)
+	if now-last < int64(idempotencySweepInterval.Seconds()) || !atomic.CompareAndSwapInt64(&r.sweptAt, last, now) {
+		return
+	}
+	q := `DELETE FROM idempotency_keys WHERE expires_at < now()`
+	_, _ = r.db.Exec(context.Background(), q)
+}
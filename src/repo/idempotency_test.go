@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotency(t *testing.T) {
+	keys := NewIdempotencyRepository(db)
+
+	first, err := keys.Start("test:key", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, first, "the free key wasn't taken")
+
+	// in progress
+	first, err = keys.Start("test:key", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	require.Equal(t, 0, first.Status)
+
+	require.NoError(t, keys.Complete("test:key", http.StatusCreated, "application/json", []byte(`{"id":1}`)))
+	first, err = keys.Start("test:key", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "fingerprint", first.Fingerprint)
+	require.Equal(t, http.StatusCreated, first.Status)
+	require.Equal(t, "application/json", first.ContentType)
+	require.Equal(t, `{"id":1}`, string(first.Body))
+
+	// released keys of the failed requests are free again, the completed ones aren't released
+	_, err = keys.Start("test:failed", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, keys.Release("test:failed"))
+	first, err = keys.Start("test:failed", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, first)
+	require.NoError(t, keys.Release("test:key"))
+	first, err = keys.Start("test:key", "fingerprint", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	// expired keys are taken over
+	_, err = keys.Start("test:expired", "fingerprint", -time.Second, time.Minute)
+	require.NoError(t, err)
+	first, err = keys.Start("test:expired", "other", time.Hour, time.Minute)
+	require.NoError(t, err)
+	require.Nil(t, first)
+}
@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type InvitationRepo struct {
+	db domain.DB
+}
+
+func NewInvitationRepository(db domain.DB) *InvitationRepo {
+	return &InvitationRepo{db}
+}
+
+// Find returns the invitations to the group, the latest first.
+func (r *InvitationRepo) Find(groupId, offset, limit int) (ret []domain.Invitation, total int, err error) {
+	q := `SELECT count(*) FROM invitations WHERE group_id = $1`
+	if err = r.db.QueryRow(context.Background(), q, groupId).Scan(&total); err != nil {
+		return
+	}
+	q = `SELECT ` + invitationColumns + ` FROM invitations WHERE group_id = $1 ORDER BY id DESC OFFSET $2 LIMIT $3`
+	rows, err := r.db.Query(context.Background(), q, groupId, offset, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.Invitation{}
+	for rows.Next() {
+		inv := domain.Invitation{}
+		if err = scanInvitation(rows, &inv); err != nil {
+			return
+		}
+		ret = append(ret, inv)
+	}
+	return ret, total, rows.Err()
+}
+
+// Create stores the invitation, pending invitations of the same email to the group are revoked,
+// so only the latest mailed link works. domain.ErrNoRows is returned if there is no such group.
+func (r *InvitationRepo) Create(inv *domain.Invitation, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		if err := lockGroup(tx, inv.GroupId); err != nil {
+			return err
+		}
+		q := `UPDATE invitations SET revoked_at = now()
+			WHERE group_id = $1 AND lower(email) = lower($2) AND accepted_at IS NULL AND revoked_at IS NULL`
+		if _, err := tx.Exec(context.Background(), q, inv.GroupId, inv.Email); err != nil {
+			return err
+		}
+		q = `INSERT INTO invitations (group_id, email, role, token_hash, invited_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + invitationColumns
+		err := scanInvitation(tx.QueryRow(context.Background(), q,
+			inv.GroupId, inv.Email, inv.Role, inv.Hash, inv.InvitedBy, inv.ExpiresAt), inv)
+		if err != nil {
+			return err
+		}
+
+		audit.Target = groupTarget(inv.GroupId)
+		audit.Details = map[string]interface{}{"invitation_id": inv.Id, "email": inv.Email, "role": inv.Role}
+		return insertAudit(tx, audit)
+	})
+}
+
+// Revoke cancels the pending invitation, domain.ErrNoRows is returned if there is no such one.
+func (r *InvitationRepo) Revoke(groupId, id int, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var email string
+		q := `UPDATE invitations SET revoked_at = now()
+			WHERE id = $1 AND group_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+			RETURNING email`
+		if err := tx.QueryRow(context.Background(), q, id, groupId).Scan(&email); err != nil {
+			return err
+		}
+
+		audit.Target = groupTarget(groupId)
+		audit.Details = map[string]interface{}{"invitation_id": id, "email": email}
+		return insertAudit(tx, audit)
+	})
+}
+
+// Accept uses the invitation token: the user with the invited email joins the group, or the account is created
+// from newUser if there is none. The email is verified by the token, so pending users get activated.
+// The role of members who are owners already is kept, previous is the role before the acceptance.
+// domain.ErrNoRows is returned if the token isn't valid, domain.ErrLoginRequired if the account
+// has to be created but newUser has no login.
+func (r *InvitationRepo) Accept(hash string, newUser *domain.User, audit *domain.AuditEntry) (ret domain.AcceptedInvitation, previous string, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		inv := domain.Invitation{}
+		q := `SELECT ` + invitationColumns + ` FROM invitations
+			WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now() FOR UPDATE`
+		if err := scanInvitation(tx.QueryRow(context.Background(), q, hash), &inv); err != nil {
+			return err
+		}
+		if err := lockGroup(tx, inv.GroupId); err != nil {
+			return err
+		}
+
+		user := &ret.User
+		q = `SELECT ` + userColumns + ` FROM users WHERE lower(email) = lower($1) FOR UPDATE`
+		err := scanUser(tx.QueryRow(context.Background(), q, inv.Email), user)
+		switch {
+		case errors.Is(err, domain.ErrNoRows):
+			if newUser == nil || newUser.Login == "" || newUser.PasswordHash == "" {
+				return domain.ErrLoginRequired
+			}
+			*user = domain.User{Login: newUser.Login, PasswordHash: newUser.PasswordHash, Email: inv.Email, Status: domain.UserActive}
+			userAudit := *audit
+			userAudit.Action = domain.AuditUserCreate
+			if err = createUser(tx, user, &userAudit); err != nil {
+				return err
+			}
+			ret.Created = true
+		case err != nil:
+			return err
+		case user.Status == domain.UserDisabled:
+			return domain.ErrUserDisabled
+		case user.Status == domain.UserPending:
+			q = `UPDATE users SET status = $2, updated_at = now(), version = version + 1 WHERE id = $1`
+			if _, err = tx.Exec(context.Background(), q, user.Id, domain.UserActive); err != nil {
+				return err
+			}
+			user.Status = domain.UserActive
+		}
+
+		q = `SELECT role FROM group_members WHERE group_id = $1 AND user_id = $2`
+		err = tx.QueryRow(context.Background(), q, inv.GroupId, user.Id).Scan(&previous)
+		if err != nil && !errors.Is(err, domain.ErrNoRows) {
+			return err
+		}
+		ret.GroupId, ret.Role = inv.GroupId, inv.Role
+		if previous == domain.GroupOwner {
+			ret.Role = previous
+		}
+		if previous != ret.Role {
+			q = `INSERT INTO group_members (group_id, user_id, role) VALUES ($1, $2, $3)
+				ON CONFLICT (group_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+			if _, err = tx.Exec(context.Background(), q, inv.GroupId, user.Id, ret.Role); err != nil {
+				return err
+			}
+			if err = touchGroup(tx, inv.GroupId); err != nil {
+				return err
+			}
+		}
+
+		q = `UPDATE invitations SET accepted_at = now(), accepted_by = $2 WHERE id = $1`
+		if _, err = tx.Exec(context.Background(), q, inv.Id, user.Id); err != nil {
+			return err
+		}
+
+		// The invitee is anonymous until the acceptance
+		if audit.ActorId == nil {
+			audit.ActorId = &user.Id
+		}
+		audit.Target = groupTarget(inv.GroupId)
+		audit.Details = map[string]interface{}{"invitation_id": inv.Id, "user_id": user.Id, "created": ret.Created}
+		if previous != "" {
+			audit.Before = map[string]interface{}{"role": previous}
+		}
+		audit.After = map[string]interface{}{"role": ret.Role}
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+const invitationColumns = `id, group_id, email, role, invited_by, created_at, expires_at, accepted_at, accepted_by,
+	CASE WHEN accepted_at IS NOT NULL THEN '` + domain.InvitationAccepted + `'
+		WHEN revoked_at IS NOT NULL THEN '` + domain.InvitationRevoked + `'
+		WHEN expires_at <= now() THEN '` + domain.InvitationExpired + `'
+		ELSE '` + domain.InvitationPending + `' END`
+
+func scanInvitation(row pgx.Row, inv *domain.Invitation) error {
+	return row.Scan(&inv.Id, &inv.GroupId, &inv.Email, &inv.Role, &inv.InvitedBy,
+		&inv.CreatedAt, &inv.ExpiresAt, &inv.AcceptedAt, &inv.AcceptedBy, &inv.Status)
+}
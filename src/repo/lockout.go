@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type LockoutRepo struct {
+	db domain.DB
+}
+
+func NewLockoutRepository(db domain.DB) *LockoutRepo {
+	return &LockoutRepo{db}
+}
+
+// Get returns the failures of the key within the window, zero value if there are none.
+func (r *LockoutRepo) Get(key string, window time.Duration) (f domain.LoginFailures, err error) {
+	q := `SELECT failures, last_failure_at, locked_until FROM login_failures
+		WHERE key = $1 AND (last_failure_at > now() - $2 * interval '1 second' OR locked_until > now())`
+	err = r.db.QueryRow(context.Background(), q, key, window.Seconds()).Scan(&f.Failures, &f.LastFailureAt, &f.LockedUntil)
+	if errors.Is(err, domain.ErrNoRows) {
+		return domain.LoginFailures{}, nil
+	}
+	return
+}
+
+// Fail counts the failure, the count starts over if the previous failure is older than the window.
+// The key gets locked for the period once the count reaches the limit.
+func (r *LockoutRepo) Fail(key string, window time.Duration, limit int, period time.Duration) (f domain.LoginFailures, err error) {
+	q := `INSERT INTO login_failures AS lf (key, failures, last_failure_at, locked_until)
+			VALUES ($1, 1, now(), CASE WHEN $3 <= 1 THEN now() + $4 * interval '1 second' END)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN lf.last_failure_at < now() - $2 * interval '1 second' THEN 1 ELSE lf.failures + 1 END,
+			last_failure_at = now(),
+			locked_until = CASE
+				WHEN (CASE WHEN lf.last_failure_at < now() - $2 * interval '1 second' THEN 1 ELSE lf.failures + 1 END) >= $3
+				THEN now() + $4 * interval '1 second'
+				ELSE lf.locked_until
+			END
+		RETURNING failures, last_failure_at, locked_until`
+	err = r.db.QueryRow(context.Background(), q, key, window.Seconds(), limit, period.Seconds()).
+		Scan(&f.Failures, &f.LastFailureAt, &f.LockedUntil)
+	return
+}
+
+// Reset forgets the failures after a successful login.
+func (r *LockoutRepo) Reset(key string) error {
+	q := `DELETE FROM login_failures WHERE key = $1`
+	_, err := r.db.Exec(context.Background(), q, key)
+	return err
+}
+
+// Unlock forgets the failures of the user account, domain.ErrNoRows is returned if there is no such user.
+func (r *LockoutRepo) Unlock(userId int, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var login string
+		q := `SELECT login FROM users WHERE id = $1`
+		if err := tx.QueryRow(context.Background(), q, userId).Scan(&login); err != nil {
+			return err
+		}
+		q = `DELETE FROM login_failures WHERE key = $1`
+		if _, err := tx.Exec(context.Background(), q, domain.LoginKey(login)); err != nil {
+			return err
+		}
+
+		audit.Target = userTarget(userId)
+		return insertAudit(tx, audit)
+	})
+}
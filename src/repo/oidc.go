@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type OIDCRepo struct {
+	db domain.DB
+}
+
+func NewOIDCRepository(db domain.DB) *OIDCRepo {
+	return &OIDCRepo{db}
+}
+
+// CreateState stores the state of the started login and drops the expired ones, nobody will finish them.
+func (r *OIDCRepo) CreateState(s *domain.OIDCState) error {
+	q := `DELETE FROM oidc_states WHERE expires_at < now()`
+	if _, err := r.db.Exec(context.Background(), q); err != nil {
+		return err
+	}
+	q = `INSERT INTO oidc_states (state_hash, code_verifier, nonce, expires_at) VALUES ($1, $2, $3, $4)`
+	_, err := r.db.Exec(context.Background(), q, s.Hash, s.CodeVerifier, s.Nonce, s.ExpiresAt)
+	return err
+}
+
+// TakeState returns the state and deletes it, so every state is used once.
+// domain.ErrNoRows is returned if it's unknown or expired.
+func (r *OIDCRepo) TakeState(hash string) (s domain.OIDCState, err error) {
+	q := `DELETE FROM oidc_states WHERE state_hash = $1 AND expires_at > now()
+		RETURNING state_hash, code_verifier, nonce, expires_at`
+	err = r.db.QueryRow(context.Background(), q, hash).Scan(&s.Hash, &s.CodeVerifier, &s.Nonce, &s.ExpiresAt)
+	return
+}
+
+// GetUserId returns the user linked to the identity of the provider.
+func (r *OIDCRepo) GetUserId(issuer, subject string) (userId int, err error) {
+	q := `SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`
+	err = r.db.QueryRow(context.Background(), q, issuer, subject).Scan(&userId)
+	return
+}
+
+// Link binds the identity to the user. The provider has verified the email,
+// so a pending user becomes active just like after the email verification.
+func (r *OIDCRepo) Link(userId int, issuer, subject string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `INSERT INTO user_identities (user_id, issuer, subject) VALUES ($1, $2, $3)`
+		if _, err := tx.Exec(context.Background(), q, userId, issuer, subject); err != nil {
+			return err
+		}
+		q = `UPDATE users SET status = $2, updated_at = now(), version = version + 1 WHERE id = $1 AND status = $3`
+		if _, err := tx.Exec(context.Background(), q, userId, domain.UserActive, domain.UserPending); err != nil {
+			return err
+		}
+
+		audit.Target = userTarget(userId)
+		if audit.Details == nil {
+			audit.Details = map[string]interface{}{}
+		}
+		audit.Details["issuer"] = issuer
+		audit.Details["subject"] = subject
+		return insertAudit(tx, audit)
+	})
+}
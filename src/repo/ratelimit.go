@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const rateLimitSweepInterval = time.Minute
+
+// refilled is the tokens of the existing bucket refilled since the last request, all the SET expressions see the old row.
+const refilled = `LEAST($3::float8, rl.tokens + EXTRACT(EPOCH FROM now() - rl.updated_at) * $2::float8)`
+
+// RateLimitRepo keeps the token buckets in Postgres, so the limits are shared by the replicas.
+type RateLimitRepo struct {
+	db      domain.DB
+	sweptAt int64 // unix seconds
+}
+
+func NewRateLimitRepository(db domain.DB) *RateLimitRepo {
+	return &RateLimitRepo{db: db}
+}
+
+func (r *RateLimitRepo) Take(key string, rate float64, burst int) (l domain.RateLimit, err error) {
+	r.sweep()
+	q := `INSERT INTO rate_limits AS rl (key, tokens, allowed, refill_secs, updated_at)
+			VALUES ($1, $3::float8 - 1, true, $3::float8 / $2::float8, now())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE WHEN ` + refilled + ` >= 1 THEN ` + refilled + ` - 1 ELSE ` + refilled + ` END,
+			allowed = ` + refilled + ` >= 1,
+			refill_secs = $3::float8 / $2::float8,
+			updated_at = now()
+		RETURNING allowed, tokens`
+	err = r.db.QueryRow(context.Background(), q, key, rate, burst).Scan(&l.Allowed, &l.Tokens)
+	return
+}
+
+// sweep deletes the full buckets once in a while, they are the same as the missing ones.
+// It fails along with Take if the database is down, so the error is left to Take.
+func (r *RateLimitRepo) sweep() {
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&r.sweptAt)
+	if now-last < int64(rateLimitSweepInterval.Seconds()) || !atomic.CompareAndSwapInt64(&r.sweptAt, last, now) {
+		return
+	}
+	q := `DELETE FROM rate_limits WHERE updated_at + refill_secs * interval '1 second' < now()`
+	_, _ = r.db.Exec(context.Background(), q)
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimit(t *testing.T) {
+	limits := NewRateLimitRepository(db)
+
+	// a slow refill, so the bucket doesn't refill during the test
+	rate, burst := 0.001, 5
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := limits.Take("test:ip:10.0.0.1", rate, burst)
+			require.NoError(t, err)
+			if l.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, burst, allowed, "concurrent requests took more tokens than the bucket has")
+
+	l, err := limits.Take("test:ip:10.0.0.1", rate, burst)
+	require.NoError(t, err)
+	require.False(t, l.Allowed)
+	require.Less(t, l.Tokens, 1.0)
+
+	// the buckets are per key
+	l, err = limits.Take("test:ip:10.0.0.2", rate, burst)
+	require.NoError(t, err)
+	require.True(t, l.Allowed)
+	require.InDelta(t, float64(burst-1), l.Tokens, 0.01)
+}
@@ -0,0 +1,65 @@
+package repo
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// querier is implemented by both the pool and transactions.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+}
+
+// withTx runs fn in a transaction, which is committed if fn returns no error.
+func withTx(db domain.DB, fn func(tx pgx.Tx) error) error {
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	// Rollback has no effect if Commit will be called
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// uniqueViolation is the SQLSTATE of a duplicate key.
+const uniqueViolation = "23505"
+
+// conflict turns the unique violation into domain.ErrConflict, other errors are returned as is.
+func conflict(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return domain.ErrConflict
+	}
+	return err
+}
+
+// diff leaves only the fields which differ in before and after, values must be comparable.
+func diff(before, after map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	b := map[string]interface{}{}
+	a := map[string]interface{}{}
+	for k, v := range before {
+		if av, ok := after[k]; !ok || av != v {
+			b[k] = v
+		}
+	}
+	for k, v := range after {
+		if bv, ok := before[k]; !ok || bv != v {
+			a[k] = v
+		}
+	}
+	return b, a
+}
@@ -0,0 +1,111 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type RoleRepo struct {
+	db domain.DB
+}
+
+func NewRoleRepository(db domain.DB) *RoleRepo {
+	return &RoleRepo{db}
+}
+
+func (r *RoleRepo) GetAll() ([]domain.Role, error) {
+	q := `SELECT r.id, r.name, r.description, coalesce(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		GROUP BY r.id ORDER BY r.id`
+	return r.query(q)
+}
+
+func (r *RoleRepo) GetByUser(userId int) ([]domain.Role, error) {
+	q := `SELECT r.id, r.name, r.description, coalesce(array_agg(p.name ORDER BY p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+		GROUP BY r.id ORDER BY r.id`
+	return r.query(q, userId)
+}
+
+func (r *RoleRepo) query(q string, args ...interface{}) (ret []domain.Role, err error) {
+	rows, err := r.db.Query(context.Background(), q, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.Role{}
+	for rows.Next() {
+		var role domain.Role
+		if err = rows.Scan(&role.Id, &role.Name, &role.Description, &role.Permissions); err != nil {
+			return
+		}
+		ret = append(ret, role)
+	}
+	return ret, rows.Err()
+}
+
+// Grant assigns the role to the user, domain.ErrNoRows is returned for unknown roles.
+func (r *RoleRepo) Grant(userId int, role string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var roleId int
+		q := `SELECT id FROM roles WHERE name = $1`
+		if err := tx.QueryRow(context.Background(), q, role).Scan(&roleId); err != nil {
+			return err
+		}
+		q = `INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+		tag, err := tx.Exec(context.Background(), q, userId, roleId)
+		if err != nil || tag.RowsAffected() == 0 {
+			return err // nothing to audit if the role was already assigned
+		}
+
+		audit.Target = userTarget(userId)
+		audit.After = map[string]interface{}{"role": role}
+		return insertAudit(tx, audit)
+	})
+}
+
+// Revoke takes the role away, domain.ErrNoRows is returned if the user doesn't have it.
+func (r *RoleRepo) Revoke(userId int, role string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `DELETE FROM user_roles WHERE user_id = $1 AND role_id = (SELECT id FROM roles WHERE name = $2)`
+		tag, err := tx.Exec(context.Background(), q, userId, role)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return domain.ErrNoRows
+		}
+
+		audit.Target = userTarget(userId)
+		audit.Before = map[string]interface{}{"role": role}
+		return insertAudit(tx, audit)
+	})
+}
+
+// HasPermissions tells if the user's roles grant all the permissions.
+func (r *RoleRepo) HasPermissions(userId int, perms []string) (ok bool, err error) {
+	q := `SELECT count(DISTINCT p.name) = (SELECT count(DISTINCT x) FROM unnest($2::text[]) x)
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1 AND p.name = ANY($2)`
+	err = r.db.QueryRow(context.Background(), q, userId, perms).Scan(&ok)
+	return
+}
+
+// PermissionsExist tells if all the permissions are known.
+func (r *RoleRepo) PermissionsExist(perms []string) (ok bool, err error) {
+	q := `SELECT count(*) = (SELECT count(DISTINCT x) FROM unnest($1::text[]) x) FROM permissions WHERE name = ANY($1)`
+	err = r.db.QueryRow(context.Background(), q, perms).Scan(&ok)
+	return
+}
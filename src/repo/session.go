@@ -0,0 +1,131 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type SessionRepo struct {
+	db domain.DB
+}
+
+func NewSessionRepository(db domain.DB) *SessionRepo {
+	return &SessionRepo{db}
+}
+
+// Create stores the session along with the first refresh token of its family.
+func (r *SessionRepo) Create(s *domain.Session, rt *domain.RefreshToken) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `INSERT INTO sessions (user_id, family, user_agent, ip) VALUES ($1, $2, $3, $4)
+			RETURNING id, created_at, last_seen_at`
+		err := tx.QueryRow(context.Background(), q, s.UserId, s.Family, s.UserAgent, s.IP).
+			Scan(&s.Id, &s.CreatedAt, &s.LastSeenAt)
+		if err != nil {
+			return err
+		}
+		q = `INSERT INTO refresh_tokens (user_id, family, token_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+		return tx.QueryRow(context.Background(), q, rt.UserId, rt.Family, rt.Hash, rt.ExpiresAt).Scan(&rt.Id)
+	})
+}
+
+// Active tells whether the session hasn't been revoked, it's checked on every request.
+func (r *SessionRepo) Active(id int) (active bool, err error) {
+	q := `SELECT revoked_at IS NULL FROM sessions WHERE id = $1`
+	err = r.db.QueryRow(context.Background(), q, id).Scan(&active)
+	if errors.Is(err, domain.ErrNoRows) {
+		return false, nil
+	}
+	return
+}
+
+// Touch updates the last seen time, at most once a minute to spare the DB on busy sessions.
+func (r *SessionRepo) Touch(id int) error {
+	q := `UPDATE sessions SET last_seen_at = now() WHERE id = $1 AND last_seen_at < now() - interval '1 minute'`
+	_, err := r.db.Exec(context.Background(), q, id)
+	return err
+}
+
+// Refreshed records the refresh of the family tokens and returns the session id.
+// domain.ErrNoRows is returned if the session has been revoked.
+func (r *SessionRepo) Refreshed(family, ip string) (id int, err error) {
+	q := `UPDATE sessions SET last_seen_at = now(), ip = $2 WHERE family = $1 AND revoked_at IS NULL RETURNING id`
+	err = r.db.QueryRow(context.Background(), q, family, ip).Scan(&id)
+	return
+}
+
+// GetByUser returns the active sessions, the most recently used first.
+func (r *SessionRepo) GetByUser(userId int) (ret []domain.Session, err error) {
+	q := `SELECT id, user_id, user_agent, ip, created_at, last_seen_at FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL ORDER BY last_seen_at DESC, id DESC`
+	rows, err := r.db.Query(context.Background(), q, userId)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.Session{}
+	for rows.Next() {
+		var s domain.Session
+		err = rows.Scan(&s.Id, &s.UserId, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt)
+		if err != nil {
+			return
+		}
+		ret = append(ret, s)
+	}
+	return ret, rows.Err()
+}
+
+// Revoke ends the session of the user and its refresh tokens,
+// domain.ErrNoRows is returned if there is no such active session.
+func (r *SessionRepo) Revoke(id, userId int, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var family string
+		q := `UPDATE sessions SET revoked_at = now() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL RETURNING family`
+		if err := tx.QueryRow(context.Background(), q, id, userId).Scan(&family); err != nil {
+			return err
+		}
+		if err := revokeFamily(tx, family); err != nil {
+			return err
+		}
+
+		audit.Target = fmt.Sprintf("session:%d", id)
+		audit.Details = map[string]interface{}{"user_id": userId}
+		return insertAudit(tx, audit)
+	})
+}
+
+// RevokeAll logs the user out everywhere and returns how many sessions were ended.
+func (r *SessionRepo) RevokeAll(userId int, audit *domain.AuditEntry) (qnt int, err error) {
+	err = withTx(r.db, func(tx pgx.Tx) error {
+		n, err := revokeUserSessions(tx, userId)
+		if err != nil {
+			return err
+		}
+		qnt = int(n)
+
+		audit.Target = userTarget(userId)
+		audit.Details = map[string]interface{}{"sessions": qnt}
+		return insertAudit(tx, audit)
+	})
+	return
+}
+
+// revokeUserSessions revokes every session and refresh token of the user,
+// tokens issued before sessions existed included.
+func revokeUserSessions(q querier, userId int) (int64, error) {
+	sql := `UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+	tag, err := q.Exec(context.Background(), sql, userId)
+	if err != nil {
+		return 0, err
+	}
+	sql = `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`
+	if _, err = q.Exec(context.Background(), sql, userId); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,81 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type TokenRepo struct {
+	db domain.DB
+}
+
+func NewTokenRepository(db domain.DB) *TokenRepo {
+	return &TokenRepo{db}
+}
+
+// RotateRefresh revokes the refresh token with the hash and stores the next one of the same family.
+// An already revoked token means it has leaked, so the whole family and its session get revoked.
+func (r *TokenRepo) RotateRefresh(hash string, next *domain.RefreshToken) error {
+	reused := false
+	err := withTx(r.db, func(tx pgx.Tx) error {
+		var (
+			id        int
+			expiresAt time.Time
+			revokedAt *time.Time
+		)
+		q := `SELECT id, user_id, family, expires_at, revoked_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+		err := tx.QueryRow(context.Background(), q, hash).Scan(&id, &next.UserId, &next.Family, &expiresAt, &revokedAt)
+		if err != nil {
+			return err
+		}
+
+		if revokedAt != nil {
+			reused = true
+			return revokeFamily(tx, next.Family)
+		}
+		if expiresAt.Before(time.Now()) {
+			return domain.ErrTokenExpired
+		}
+
+		q = `UPDATE refresh_tokens SET revoked_at = now() WHERE id = $1`
+		if _, err = tx.Exec(context.Background(), q, id); err != nil {
+			return err
+		}
+		q = `INSERT INTO refresh_tokens (user_id, family, token_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+		return tx.QueryRow(context.Background(), q, next.UserId, next.Family, next.Hash, next.ExpiresAt).Scan(&next.Id)
+	})
+	if err == nil && reused {
+		err = domain.ErrTokenReused
+	}
+	return err
+}
+
+// RevokeRefreshFamily revokes the token with the hash and every token rotated from the same login, ending the session.
+func (r *TokenRepo) RevokeRefreshFamily(hash string) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var family string
+		q := `SELECT family FROM refresh_tokens WHERE token_hash = $1`
+		err := tx.QueryRow(context.Background(), q, hash).Scan(&family)
+		if errors.Is(err, domain.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return revokeFamily(tx, family)
+	})
+}
+
+func revokeFamily(q querier, family string) error {
+	sql := `UPDATE refresh_tokens SET revoked_at = now() WHERE family = $1 AND revoked_at IS NULL`
+	if _, err := q.Exec(context.Background(), sql, family); err != nil {
+		return err
+	}
+	sql = `UPDATE sessions SET revoked_at = now() WHERE family = $1 AND revoked_at IS NULL`
+	_, err := q.Exec(context.Background(), sql, family)
+	return err
+}
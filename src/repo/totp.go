@@ -0,0 +1,113 @@
+package repo
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type TotpRepo struct {
+	db domain.DB
+}
+
+func NewTotpRepository(db domain.DB) *TotpRepo {
+	return &TotpRepo{db}
+}
+
+// Enroll stores the pending secret replacing the previous pending one,
+// domain.ErrTotpEnabled is returned if 2FA is enabled already.
+func (r *TotpRepo) Enroll(userId int, secret string) error {
+	q := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = now(), last_step = 0
+		WHERE user_totp.enabled_at IS NULL`
+	tag, err := r.db.Exec(context.Background(), q, userId, secret)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = domain.ErrTotpEnabled
+	}
+	return err
+}
+
+func (r *TotpRepo) Get(userId int) (t domain.Totp, err error) {
+	q := `SELECT user_id, secret, enabled_at IS NOT NULL, last_step FROM user_totp WHERE user_id = $1`
+	err = r.db.QueryRow(context.Background(), q, userId).Scan(&t.UserId, &t.Secret, &t.Enabled, &t.LastStep)
+	return
+}
+
+// AnyEnrolled tells whether any user has enrolled, their secrets need the same encryption key.
+func (r *TotpRepo) AnyEnrolled() (enrolled bool, err error) {
+	q := `SELECT EXISTS (SELECT 1 FROM user_totp)`
+	err = r.db.QueryRow(context.Background(), q).Scan(&enrolled)
+	return
+}
+
+// IsEnabled tells whether the login of the user needs the second step.
+func (r *TotpRepo) IsEnabled(userId int) (enabled bool, err error) {
+	q := `SELECT EXISTS (SELECT 1 FROM user_totp WHERE user_id = $1 AND enabled_at IS NOT NULL)`
+	err = r.db.QueryRow(context.Background(), q, userId).Scan(&enabled)
+	return
+}
+
+// Enable turns the pending enrollment on and replaces the recovery codes.
+func (r *TotpRepo) Enable(userId int, step int64, codeHashes []string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `UPDATE user_totp SET enabled_at = now(), last_step = $2 WHERE user_id = $1 AND enabled_at IS NULL`
+		tag, err := tx.Exec(context.Background(), q, userId, step)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return domain.ErrNoRows
+		}
+
+		q = `DELETE FROM recovery_codes WHERE user_id = $1`
+		if _, err = tx.Exec(context.Background(), q, userId); err != nil {
+			return err
+		}
+		q = `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`
+		if _, err = tx.Exec(context.Background(), q, userId, codeHashes); err != nil {
+			return err
+		}
+
+		audit.Target = userTarget(userId)
+		return insertAudit(tx, audit)
+	})
+}
+
+// UseStep remembers the time step of the accepted code, domain.ErrNoRows
+// is returned if a code of the same or a later step has been used already.
+func (r *TotpRepo) UseStep(userId int, step int64) error {
+	q := `UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`
+	tag, err := r.db.Exec(context.Background(), q, userId, step)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = domain.ErrNoRows
+	}
+	return err
+}
+
+// UseRecoveryCode marks the code as used, domain.ErrNoRows is returned if there is no such unused code.
+func (r *TotpRepo) UseRecoveryCode(userId int, hash string) error {
+	q := `UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	tag, err := r.db.Exec(context.Background(), q, userId, hash)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = domain.ErrNoRows
+	}
+	return err
+}
+
+func (r *TotpRepo) Disable(userId int, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `DELETE FROM user_totp WHERE user_id = $1`
+		if _, err := tx.Exec(context.Background(), q, userId); err != nil {
+			return err
+		}
+		q = `DELETE FROM recovery_codes WHERE user_id = $1`
+		if _, err := tx.Exec(context.Background(), q, userId); err != nil {
+			return err
+		}
+
+		audit.Target = userTarget(userId)
+		return insertAudit(tx, audit)
+	})
+}
@@ -2,6 +2,10 @@ package repo
 
 import (
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
 
 	"github.com/Kale-Grabovski/gonah/src/domain"
 )
@@ -21,16 +25,17 @@ func (r *UserRepo) Ready() (ready string, err error) {
 }
 
 func (r *UserRepo) GetAll() (ret []domain.User, err error) {
-	q := `SELECT id, login FROM users ORDER BY id`
+	q := `SELECT id, login, coalesce(email, ''), status FROM users ORDER BY id`
 	rows, err := r.db.Query(context.Background(), q)
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
+	ret = []domain.User{}
 	for rows.Next() {
 		var u domain.User
-		err = rows.Scan(&u.Id, &u.Login)
+		err = rows.Scan(&u.Id, &u.Login, &u.Email, &u.Status)
 		if err != nil {
 			return
 		}
@@ -39,26 +44,247 @@ func (r *UserRepo) GetAll() (ret []domain.User, err error) {
 	return
 }
 
-func (r *UserRepo) Create(user *domain.User) (err error) {
-	q := `INSERT INTO users (login) VALUES ($1) RETURNING id`
-	err = r.db.QueryRow(context.Background(), q, user.Login).Scan(&user.Id)
+// LastModified returns when the list of users has changed last, deletions included.
+func (r *UserRepo) LastModified() (changedAt time.Time, err error) {
+	q := `SELECT changed_at FROM table_changes WHERE name = 'users'`
+	err = r.db.QueryRow(context.Background(), q).Scan(&changedAt)
 	return
 }
 
+// Create stores the user with the default role and writes the audit entry in the same transaction.
+func (r *UserRepo) Create(user *domain.User, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		return createUser(tx, user, audit)
+	})
+}
+
+// createUser inserts the user with the default role, domain.ErrConflict is returned if the login or email is taken.
+func createUser(tx pgx.Tx, user *domain.User, audit *domain.AuditEntry) error {
+	q := `WITH u AS (
+			INSERT INTO users (login, password_hash, email, status, external_id)
+			VALUES ($1, NULLIF($2, ''), NULLIF($3, ''), $4, NULLIF($5, ''))
+			RETURNING id, created_at, updated_at, version
+		), ur AS (
+			INSERT INTO user_roles (user_id, role_id) SELECT u.id, r.id FROM u, roles r WHERE r.name = 'user'
+		)
+		SELECT id, created_at, updated_at, version FROM u`
+	err := tx.QueryRow(context.Background(), q, user.Login, user.PasswordHash, user.Email, user.Status, user.ExternalId).
+		Scan(&user.Id, &user.CreatedAt, &user.UpdatedAt, &user.Version)
+	if err != nil {
+		return conflict(err)
+	}
+
+	audit.Target = userTarget(user.Id)
+	audit.Before, audit.After = diff(nil, userSnapshot(user))
+	return insertAudit(tx, audit)
+}
+
 func (r *UserRepo) GetByLogin(login string) (qnt int, err error) {
 	q := `SELECT count(*) FROM users WHERE login = $1`
 	err = r.db.QueryRow(context.Background(), q, login).Scan(&qnt)
 	return
 }
 
+func (r *UserRepo) GetByEmail(email string) (qnt int, err error) {
+	q := `SELECT count(*) FROM users WHERE lower(email) = lower($1)`
+	err = r.db.QueryRow(context.Background(), q, email).Scan(&qnt)
+	return
+}
+
+func (r *UserRepo) FindByEmail(email string) (user domain.User, err error) {
+	q := `SELECT id, login, email, status FROM users WHERE lower(email) = lower($1)`
+	err = r.db.QueryRow(context.Background(), q, email).Scan(&user.Id, &user.Login, &user.Email, &user.Status)
+	return
+}
+
+// GetCredentials returns the user with the password hash, which is empty if no password is set.
+func (r *UserRepo) GetCredentials(login string) (user domain.User, err error) {
+	q := `SELECT id, login, coalesce(email, ''), status, coalesce(password_hash, '') FROM users WHERE login = $1`
+	err = r.db.QueryRow(context.Background(), q, login).Scan(&user.Id, &user.Login, &user.Email, &user.Status, &user.PasswordHash)
+	return
+}
+
+// UpdatePasswordHash replaces the hash, the audit entry only tells that the password has changed.
+func (r *UserRepo) UpdatePasswordHash(id int, hash string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		q := `UPDATE users SET password_hash = $2 WHERE id = $1`
+		tag, err := tx.Exec(context.Background(), q, id, hash)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return domain.ErrNoRows
+		}
+
+		audit.Target = userTarget(id)
+		if audit.Details == nil {
+			audit.Details = map[string]interface{}{}
+		}
+		audit.Details["fields"] = []string{"password"}
+		return insertAudit(tx, audit)
+	})
+}
+
 func (r *UserRepo) GetById(id int) (user domain.User, err error) {
-	q := `SELECT id, login FROM users WHERE id = $1`
-	err = r.db.QueryRow(context.Background(), q, id).Scan(&user.Id, &user.Login)
+	q := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	err = scanUser(r.db.QueryRow(context.Background(), q, id), &user)
 	return
 }
 
-func (r *UserRepo) Delete(id int) error {
-	q := `DELETE FROM users WHERE id = $1`
-	_, err := r.db.Exec(context.Background(), q, id)
-	return err
+// Find returns the page of the users matching the filter ordered by id, along with the total number of matches.
+func (r *UserRepo) Find(f domain.UserFilter, offset, limit int) (ret []domain.User, total int, err error) {
+	where := `($1 = '' OR lower(login) = lower($1)) AND ($2 = '' OR external_id = $2)`
+	q := `SELECT count(*) FROM users WHERE ` + where
+	if err = r.db.QueryRow(context.Background(), q, f.Login, f.ExternalId).Scan(&total); err != nil {
+		return
+	}
+
+	q = `SELECT ` + userColumns + ` FROM users WHERE ` + where + ` ORDER BY id OFFSET $3 LIMIT $4`
+	rows, err := r.db.Query(context.Background(), q, f.Login, f.ExternalId, offset, limit)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	ret = []domain.User{}
+	for rows.Next() {
+		var u domain.User
+		if err = scanUser(rows, &u); err != nil {
+			return
+		}
+		ret = append(ret, u)
+	}
+	return ret, total, rows.Err()
+}
+
+// Update replaces the login, email, status and external id of the user. If version isn't zero,
+// domain.ErrVersionMismatch is returned when the user has changed since. Disabling the user
+// logs them out everywhere. domain.ErrNoRows is returned if there is no such user.
+func (r *UserRepo) Update(user *domain.User, version int, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var before domain.User
+		q := `SELECT ` + userColumns + ` FROM users WHERE id = $1 FOR UPDATE`
+		if err := scanUser(tx.QueryRow(context.Background(), q, user.Id), &before); err != nil {
+			return err
+		}
+		if version != 0 && version != before.Version {
+			return domain.ErrVersionMismatch
+		}
+
+		q = `UPDATE users SET login = $2, email = NULLIF($3, ''), status = $4, external_id = NULLIF($5, ''),
+				updated_at = now(), version = version + 1
+			WHERE id = $1 RETURNING created_at, updated_at, version`
+		err := tx.QueryRow(context.Background(), q, user.Id, user.Login, user.Email, user.Status, user.ExternalId).
+			Scan(&user.CreatedAt, &user.UpdatedAt, &user.Version)
+		if err != nil {
+			return conflict(err)
+		}
+		if user.Status == domain.UserDisabled && before.Status != domain.UserDisabled {
+			if _, err = revokeUserSessions(tx, user.Id); err != nil {
+				return err
+			}
+		}
+
+		audit.Target = userTarget(user.Id)
+		audit.Before, audit.After = diff(userSnapshot(&before), userSnapshot(user))
+		return insertAudit(tx, audit)
+	})
+}
+
+// Activate uses the email verification token and activates the pending user.
+// domain.ErrNoRows is returned if the token isn't valid for the user, domain.ErrNotPending
+// if the user isn't pending, the token is kept then.
+func (r *UserRepo) Activate(id int, tokenHash string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		if err := useToken(tx, id, domain.TokenEmailVerification, tokenHash); err != nil {
+			return err
+		}
+		q := `UPDATE users SET status = $2, updated_at = now(), version = version + 1 WHERE id = $1 AND status = $3`
+		tag, err := tx.Exec(context.Background(), q, id, domain.UserActive, domain.UserPending)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return domain.ErrNotPending
+		}
+
+		audit.Target = userTarget(id)
+		audit.Before = map[string]interface{}{"status": domain.UserPending}
+		audit.After = map[string]interface{}{"status": domain.UserActive}
+		return insertAudit(tx, audit)
+	})
+}
+
+// ResetPassword uses the password reset token, replaces the password hash and
+// revokes every session of the user, so all the devices have to log in again.
+// domain.ErrNoRows is returned if the token isn't valid for the user.
+func (r *UserRepo) ResetPassword(id int, tokenHash, passwordHash string, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		if err := useToken(tx, id, domain.TokenPasswordReset, tokenHash); err != nil {
+			return err
+		}
+		// Other reset links mailed before are useless from now on
+		q := `UPDATE user_tokens SET used_at = now() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`
+		if _, err := tx.Exec(context.Background(), q, id, domain.TokenPasswordReset); err != nil {
+			return err
+		}
+		q = `UPDATE users SET password_hash = $2 WHERE id = $1`
+		if _, err := tx.Exec(context.Background(), q, id, passwordHash); err != nil {
+			return err
+		}
+		if _, err := revokeUserSessions(tx, id); err != nil {
+			return err
+		}
+
+		audit.Target = userTarget(id)
+		if audit.Details == nil {
+			audit.Details = map[string]interface{}{}
+		}
+		audit.Details["fields"] = []string{"password"}
+		return insertAudit(tx, audit)
+	})
+}
+
+// Delete removes the user, domain.ErrNoRows is returned if there is no such user. If version isn't zero,
+// domain.ErrVersionMismatch is returned when the user has changed since.
+func (r *UserRepo) Delete(id, version int, audit *domain.AuditEntry) error {
+	return withTx(r.db, func(tx pgx.Tx) error {
+		var user domain.User
+		q := `SELECT ` + userColumns + ` FROM users WHERE id = $1 FOR UPDATE`
+		if err := scanUser(tx.QueryRow(context.Background(), q, id), &user); err != nil {
+			return err
+		}
+		if version != 0 && version != user.Version {
+			return domain.ErrVersionMismatch
+		}
+		q = `DELETE FROM users WHERE id = $1`
+		if _, err := tx.Exec(context.Background(), q, id); err != nil {
+			return err
+		}
+
+		audit.Target = userTarget(id)
+		audit.Before, audit.After = diff(userSnapshot(&user), nil)
+		return insertAudit(tx, audit)
+	})
+}
+
+// userColumns are read by scanUser.
+const userColumns = `id, login, coalesce(email, ''), status, coalesce(external_id, ''), created_at, updated_at, version`
+
+func scanUser(row pgx.Row, u *domain.User) error {
+	return row.Scan(&u.Id, &u.Login, &u.Email, &u.Status, &u.ExternalId, &u.CreatedAt, &u.UpdatedAt, &u.Version)
+}
+
+func userTarget(id int) string {
+	return fmt.Sprintf("user:%d", id)
+}
+
+// userSnapshot lists the audited user fields, secrets must never get here.
+func userSnapshot(u *domain.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":     u.Id,
+		"login":  u.Login,
+		"email":  u.Email,
+		"status": u.Status,
+	}
 }
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -100,7 +101,7 @@ func TestUser(t *testing.T) {
 	user := &domain.User{
 		Login: login,
 	}
-	err = rep.Create(user)
+	err = rep.Create(user, &domain.AuditEntry{Action: domain.AuditUserCreate})
 	if err != nil {
 		t.Errorf("can't create user: %v", err)
 	}
@@ -119,10 +120,26 @@ func TestUser(t *testing.T) {
 		t.Errorf("wrong user: %v", err)
 	}
 
-	err = rep.Delete(users[0].Id)
+	modified, err := rep.LastModified()
+	if err != nil {
+		t.Errorf("can't get users last modified: %v", err)
+	}
+
+	err = rep.Delete(user.Id, user.Version+1, &domain.AuditEntry{Action: domain.AuditUserDelete})
+	if !errors.Is(err, domain.ErrVersionMismatch) {
+		t.Errorf("user of another version deleted: %v", err)
+	}
+	err = rep.Delete(user.Id, user.Version, &domain.AuditEntry{Action: domain.AuditUserDelete})
 	if err != nil {
 		t.Errorf("can't delete user: %v", err)
 	}
+	deleted, err := rep.LastModified()
+	if err != nil {
+		t.Errorf("can't get users last modified: %v", err)
+	}
+	if !deleted.After(modified) {
+		t.Errorf("users last modified %v isn't changed by delete", deleted)
+	}
 
 	users, err = rep.GetAll()
 	if err != nil {
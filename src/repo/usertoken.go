@@ -0,0 +1,53 @@
+package repo
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+type UserTokenRepo struct {
+	db domain.DB
+}
+
+func NewUserTokenRepository(db domain.DB) *UserTokenRepo {
+	return &UserTokenRepo{db}
+}
+
+func (r *UserTokenRepo) Create(t *domain.UserToken) (err error) {
+	q := `INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4) RETURNING id`
+	err = r.db.QueryRow(context.Background(), q, t.UserId, t.Purpose, t.Hash, t.ExpiresAt).Scan(&t.Id)
+	return
+}
+
+// Issued returns how many tokens for the purpose the user got since the time and when the last one was issued.
+func (r *UserTokenRepo) Issued(userId int, purpose string, since time.Time) (qnt int, last *time.Time, err error) {
+	q := `SELECT count(*), max(created_at) FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND created_at >= $3`
+	err = r.db.QueryRow(context.Background(), q, userId, purpose, since).Scan(&qnt, &last)
+	return
+}
+
+// Owner returns the user the valid token for the purpose was issued to.
+func (r *UserTokenRepo) Owner(purpose, hash string) (userId int, err error) {
+	q := `SELECT user_id FROM user_tokens WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()`
+	err = r.db.QueryRow(context.Background(), q, hash, purpose).Scan(&userId)
+	return
+}
+
+// useToken marks the valid token as used, domain.ErrNoRows is returned
+// if the token is unknown, belongs to another user, expired or has been used already.
+func useToken(q querier, userId int, purpose, hash string) error {
+	sql := `UPDATE user_tokens SET used_at = now()
+		WHERE token_hash = $1 AND purpose = $2 AND user_id = $3 AND used_at IS NULL AND expires_at > now()`
+	tag, err := q.Exec(context.Background(), sql, hash, purpose, userId)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = domain.ErrNoRows
+	}
+	return err
+}
+
+// Use marks the valid token as used, see useToken.
+func (r *UserTokenRepo) Use(userId int, purpose, hash string) error {
+	return useToken(r.db, userId, purpose, hash)
+}
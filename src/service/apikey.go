@@ -0,0 +1,38 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// API keys look like gonah_<prefix>_<secret>. The prefix is stored in clear text
+// to find the key and to show it to the owner, the whole key is only stored hashed.
+const apiKeyPrefix = "gonah_"
+
+func NewApiKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 4+24)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(b[:4])
+	key = apiKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(b[4:])
+	return key, prefix, HashToken(key), nil
+}
+
+// IsApiKey tells API keys from access tokens sent in the same Authorization header.
+func IsApiKey(key string) bool {
+	return strings.HasPrefix(key, apiKeyPrefix)
+}
+
+func ParseApiKey(key string) (prefix string, ok bool) {
+	if !IsApiKey(key) {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, apiKeyPrefix), "_", 2)
+	if len(parts) != 2 || len(parts[0]) != 8 || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
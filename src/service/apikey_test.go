@@ -0,0 +1,22 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKey(t *testing.T) {
+	key, prefix, hash, err := NewApiKey()
+	require.NoError(t, err)
+	require.Equal(t, HashToken(key), hash)
+
+	parsed, ok := ParseApiKey(key)
+	require.True(t, ok)
+	require.Equal(t, prefix, parsed)
+
+	for _, bad := range []string{"", "gonah_", "gonah_abc_secret", "gonah_12345678_", "eyJhbGciOi.x.y"} {
+		_, ok = ParseApiKey(bad)
+		require.False(t, ok, bad)
+	}
+}
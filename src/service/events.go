@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const eventsBuffer = 100
+
+// Events publishes JSON events to the Kafka topic. Publishing never blocks the request:
+// events are dropped with an error logged if the producer can't keep up or is down.
+// Events carry the ID of the request in ctx, if any.
+type Events struct {
+	topic  string
+	ch     chan Message
+	logger domain.Logger
+}
+
+func NewEvents(kafka *Kafka, topic string, logger domain.Logger) *Events {
+	ch := make(chan Message, eventsBuffer)
+	if err := kafka.GetKeyedProducer(topic, 0, ch); err != nil {
+		logger.Error("failed to connect to topic", zap.String("topic", topic), zap.Error(err))
+	}
+	return &Events{topic, ch, logger}
+}
+
+func (s *Events) Publish(ctx context.Context, event interface{}) {
+	msg, err := json.Marshal(event)
+	if err != nil {
+		s.logger.Error("cannot encode event", zap.String("topic", s.topic), zap.Error(err))
+		return
+	}
+	s.send(Message{Value: msg, RequestId: domain.RequestId(ctx)})
+}
+
+// Tombstone deletes the key from the compacted topic.
+func (s *Events) Tombstone(ctx context.Context, key string) {
+	s.send(Message{Key: []byte(key), RequestId: domain.RequestId(ctx)})
+}
+
+func (s *Events) send(m Message) {
+	select {
+	case s.ch <- m:
+	default:
+		s.logger.Error("event dropped, the producer is behind",
+			zap.String("topic", s.topic), zap.String("request_id", m.RequestId), zap.ByteString("key", m.Key), zap.ByteString("event", m.Value))
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const (
+	healthCacheTTL = 2 * time.Second
+	healthTimeout  = time.Second
+)
+
+var errStopping = errors.New("shutting down")
+
+// Check fails if the dependency isn't usable.
+type Check func(ctx context.Context) error
+
+// Health runs the liveness and readiness checks. The results are cached for the TTL,
+// so frequent probes of many replicas don't load the dependencies.
+type Health struct {
+	ttl       time.Duration
+	timeout   time.Duration
+	liveness  []*healthCheck
+	readiness []*healthCheck
+}
+
+type healthCheck struct {
+	name     string
+	check    Check
+	optional bool       // reported, but the instance is up without it
+	mu       sync.Mutex // a single probe runs the check, the concurrent ones wait for its result
+	result   domain.HealthCheck
+}
+
+func NewHealth(cfg *domain.Config) *Health {
+	s := &Health{ttl: cfg.Health.CacheTTL, timeout: cfg.Health.Timeout}
+	if s.ttl == 0 {
+		s.ttl = healthCacheTTL
+	}
+	if s.timeout == 0 {
+		s.timeout = healthTimeout
+	}
+	return s
+}
+
+// AddLiveness registers the check failing if the process has to be restarted.
+func (s *Health) AddLiveness(name string, check Check) {
+	s.liveness = append(s.liveness, &healthCheck{name: name, check: check})
+}
+
+// AddReadiness registers the check failing if the instance can't serve requests for now.
+func (s *Health) AddReadiness(name string, check Check) {
+	s.readiness = append(s.readiness, &healthCheck{name: name, check: check})
+}
+
+// AddOptionalReadiness registers the check of a dependency the requests can do without for a while,
+// its failure is reported but keeps the instance ready.
+func (s *Health) AddOptionalReadiness(name string, check Check) {
+	s.readiness = append(s.readiness, &healthCheck{name: name, check: check, optional: true})
+}
+
+func (s *Health) Live(ctx context.Context) domain.HealthReport {
+	return s.report(ctx, s.liveness)
+}
+
+func (s *Health) Ready(ctx context.Context) domain.HealthReport {
+	return s.report(ctx, s.readiness)
+}
+
+// report runs the checks concurrently.
+func (s *Health) report(ctx context.Context, checks []*healthCheck) domain.HealthReport {
+	results := make([]domain.HealthCheck, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = s.run(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	r := domain.HealthReport{Status: domain.HealthUp, Checks: make(map[string]domain.HealthCheck, len(checks))}
+	for i, c := range checks {
+		r.Checks[c.name] = results[i]
+		if results[i].Status != domain.HealthUp && !c.optional {
+			r.Status = domain.HealthDown
+		}
+	}
+	return r
+}
+
+func (s *Health) run(ctx context.Context, c *healthCheck) domain.HealthCheck {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.result.CheckedAt) < s.ttl {
+		return c.result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	start := time.Now()
+	err := c.check(ctx)
+	c.result = domain.HealthCheck{
+		Status:    domain.HealthUp,
+		Optional:  c.optional,
+		Duration:  float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		c.result.Status = domain.HealthDown
+		c.result.Error = err.Error()
+	}
+	return c.result
+}
+
+// DBCheck fails if the DB doesn't answer.
+func DBCheck(db domain.DB) Check {
+	return func(ctx context.Context) error {
+		_, err := db.Exec(ctx, `SELECT 1`)
+		return err
+	}
+}
+
+// Check fails once the shutdown has started, so the load balancers stop sending requests.
+func (s *Lifecycle) Check(ctx context.Context) error {
+	if s.Stopping() {
+		return errStopping
+	}
+	return nil
+}
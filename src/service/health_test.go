@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestHealth(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Health.CacheTTL = time.Hour
+	cfg.Health.Timeout = 50 * time.Millisecond
+	health := NewHealth(cfg)
+
+	calls := 0
+	health.AddReadiness("db", func(ctx context.Context) error {
+		calls++
+		return nil
+	})
+	health.AddReadiness("kafka", func(ctx context.Context) error {
+		<-ctx.Done() // hangs until the timeout
+		return ctx.Err()
+	})
+	lifecycle := NewLifecycle()
+	health.AddReadiness("shutdown", lifecycle.Check)
+	health.AddOptionalReadiness("schema-registry", func(ctx context.Context) error {
+		return errors.New("unreachable")
+	})
+	health.AddLiveness("loop", func(ctx context.Context) error { return nil })
+
+	r := health.Ready(context.Background())
+	require.Equal(t, domain.HealthDown, r.Status)
+	require.Equal(t, domain.HealthUp, r.Checks["db"].Status)
+	require.Equal(t, domain.HealthDown, r.Checks["kafka"].Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), r.Checks["kafka"].Error)
+	require.Equal(t, domain.HealthUp, r.Checks["shutdown"].Status)
+	require.Equal(t, domain.HealthDown, r.Checks["schema-registry"].Status)
+	require.True(t, r.Checks["schema-registry"].Optional)
+
+	// the optional checks don't take the instance down
+	optional := NewHealth(cfg)
+	optional.AddReadiness("db", func(ctx context.Context) error { return nil })
+	optional.AddOptionalReadiness("kafka", func(ctx context.Context) error { return errors.New("no brokers") })
+	r = optional.Ready(context.Background())
+	require.Equal(t, domain.HealthUp, r.Status)
+	require.Equal(t, domain.HealthDown, r.Checks["kafka"].Status)
+
+	// the results are cached
+	health.Ready(context.Background())
+	require.Equal(t, 1, calls)
+
+	r = health.Live(context.Background())
+	require.Equal(t, domain.HealthUp, r.Status)
+	require.Len(t, r.Checks, 1, "the liveness and readiness checks are apart")
+
+	lifecycle.Stop()
+	require.True(t, errors.Is(lifecycle.Check(context.Background()), errStopping))
+}
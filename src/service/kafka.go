@@ -1,8 +1,13 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/VictoriaMetrics/metrics"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"go.uber.org/zap"
 
@@ -10,60 +15,140 @@ import (
 )
 
 const (
-	producerFlushMs   = 300
+	producerFlushMs   = 100 // at most between the checks of the shutdown deadline
 	consumerTimeoutMs = 100
-	closeSleepMs      = 500 // should be > producerFlushMs + consumerTimeoutMs
 )
 
+// KafkaCloseTimeout is for Close outside of the API shutdown.
+const KafkaCloseTimeout = 5 * time.Second
+
+// Kafka runs the producers and consumers of the topics. Close stops them in order:
+// the producers produce the messages sent to them before and wait for the delivery,
+// then the consumers commit their offsets.
 type Kafka struct {
-	cfg          *domain.Config
-	logger       domain.Logger
-	consumerDone chan struct{}
-	producerDone chan struct{}
+	cfg           *domain.Config
+	logger        domain.Logger
+	stopProducers chan struct{}
+	stopConsumers chan struct{}
+	producers     sync.WaitGroup
+	consumers     sync.WaitGroup
+	deadline      time.Time // of the flush, set before the producers are stopped
+	closeOnce     sync.Once
+	closeErr      error
+	adminMu       sync.Mutex
+	admin         *kafka.AdminClient // of Ping, created on the first one
 }
 
 func NewKafka(cfg *domain.Config, logger domain.Logger) *Kafka {
 	return &Kafka{
-		cfg:          cfg,
-		logger:       logger,
-		consumerDone: make(chan struct{}),
-		producerDone: make(chan struct{}),
+		cfg:           cfg,
+		logger:        logger,
+		stopProducers: make(chan struct{}),
+		stopConsumers: make(chan struct{}),
 	}
 }
 
-func (s *Kafka) GetProducer(topic string, partition int32, ch <-chan []byte) error {
+// Message is a keyed Kafka message, nil Value is a tombstone deleting the key from compacted topics.
+// RequestId of the request producing the message is sent in the X-Request-ID header.
+type Message struct {
+	Key       []byte
+	Value     []byte
+	RequestId string
+}
+
+func (s *Kafka) GetKeyedProducer(topic string, partition int32, ch <-chan Message) error {
 	producer, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": s.cfg.Kafka.Host})
 	if err != nil {
 		return err
 	}
+	if partition == 0 {
+		partition = kafka.PartitionAny
+	}
 
+	s.producers.Add(1)
+	go s.deliveries(topic, producer)
 	go func() {
-		if partition == 0 {
-			partition = kafka.PartitionAny
-		}
+		defer s.producers.Done()
 		for {
 			select {
 			case m := <-ch:
-				err = producer.Produce(&kafka.Message{
-					TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
-					Value:          m,
-				}, nil)
-				if err != nil {
-					s.logger.Error("cannot producer a message", zap.Error(err))
+				s.produce(producer, topic, partition, m)
+			case <-s.stopProducers:
+				// The messages sent before Close are produced too
+				for {
+					select {
+					case m := <-ch:
+						s.produce(producer, topic, partition, m)
+						continue
+					default:
+					}
+					break
 				}
-			case <-s.producerDone:
-				producer.Flush(producerFlushMs)
+				s.flush(producer, topic)
 				producer.Close()
-				s.logger.Info("producer closed")
+				s.logger.Info("producer closed", zap.String("topic", topic))
 				return
-			default:
 			}
 		}
 	}()
-	go s.Consume(topic)
+
+	s.consumers.Add(1)
+	go func() {
+		defer s.consumers.Done()
+		s.Consume(topic)
+	}()
 	return nil
 }
 
+func (s *Kafka) produce(producer *kafka.Producer, topic string, partition int32, m Message) {
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition},
+		Key:            m.Key,
+		Value:          m.Value,
+	}
+	if m.RequestId != "" {
+		msg.Headers = []kafka.Header{{Key: domain.RequestIdHeader, Value: []byte(m.RequestId)}}
+	}
+	if err := producer.Produce(msg, nil); err != nil {
+		s.logger.Error("cannot producer a message", zap.String("request_id", m.RequestId), zap.Error(err))
+	}
+}
+
+// deliveries reads the delivery reports until the producer is closed, Flush waits for them to be read.
+func (s *Kafka) deliveries(topic string, producer *kafka.Producer) {
+	failed := metrics.GetOrCreateCounter(fmt.Sprintf(`gonah_kafka_delivery_failures_total{topic=%q}`, topic))
+	for e := range producer.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			if ev.TopicPartition.Error != nil {
+				failed.Inc()
+				s.logger.Error("message not delivered", zap.String("topic", topic),
+					zap.String("request_id", messageRequestId(ev)), zap.Error(ev.TopicPartition.Error))
+			}
+		case kafka.Error:
+			s.logger.Warn("producer error", zap.String("topic", topic), zap.Error(ev))
+		}
+	}
+}
+
+// flush waits for the delivery of the produced messages until the deadline.
+func (s *Kafka) flush(producer *kafka.Producer, topic string) {
+	for {
+		timeout := time.Until(s.deadline)
+		if timeout > producerFlushMs*time.Millisecond {
+			timeout = producerFlushMs * time.Millisecond
+		}
+		left := producer.Flush(int(timeout.Milliseconds()))
+		if left == 0 {
+			return
+		}
+		if time.Now().After(s.deadline) {
+			s.logger.Error("messages not delivered before shutdown", zap.String("topic", topic), zap.Int("count", left))
+			return
+		}
+	}
+}
+
 func (s *Kafka) Consume(topic string) {
 	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
 		"bootstrap.servers": s.cfg.Kafka.Host,
@@ -81,24 +166,102 @@ func (s *Kafka) Consume(topic string) {
 	for {
 		msg, err := consumer.ReadMessage(consumerTimeoutMs * time.Millisecond)
 		if err == nil {
-			s.logger.Info("kafka consumer", zap.ByteString("msg", msg.Value))
+			s.logger.Info("kafka consumer", zap.String("request_id", messageRequestId(msg)), zap.ByteString("msg", msg.Value))
 		} else if !err.(kafka.Error).IsTimeout() {
 			s.logger.Error("consumer error", zap.Error(err))
 			break
 		}
 
 		select {
-		case <-s.consumerDone:
+		case <-s.stopConsumers:
+			var kerr kafka.Error
+			if _, err = consumer.Commit(); err != nil && !(errors.As(err, &kerr) && kerr.Code() == kafka.ErrNoOffset) {
+				s.logger.Error("cannot commit offsets", zap.String("topic", topic), zap.Error(err))
+			}
 			consumer.Close()
-			s.logger.Info("consumer closed")
+			s.logger.Info("consumer closed", zap.String("topic", topic))
 			return
 		default:
 		}
 	}
+	consumer.Close()
 }
 
-func (s *Kafka) Close() {
-	s.consumerDone <- struct{}{}
-	s.producerDone <- struct{}{}
-	time.Sleep(closeSleepMs * time.Millisecond)
+// Ping fails if the metadata of the brokers can't be fetched.
+func (s *Kafka) Ping(ctx context.Context) error {
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	if s.admin == nil {
+		admin, err := kafka.NewAdminClient(&kafka.ConfigMap{"bootstrap.servers": s.cfg.Kafka.Host})
+		if err != nil {
+			return err
+		}
+		s.admin = admin
+	}
+
+	timeout := time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return context.DeadlineExceeded
+		}
+	}
+	md, err := s.admin.GetMetadata(nil, false, int(timeout.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	if len(md.Brokers) == 0 {
+		return errors.New("no brokers")
+	}
+	return nil
+}
+
+func messageRequestId(msg *kafka.Message) string {
+	for _, h := range msg.Headers {
+		if h.Key == domain.RequestIdHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// Close stops the producers and then the consumers, it gives up once ctx is done.
+// It's done once, the next calls return the result of the first one.
+func (s *Kafka) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(KafkaCloseTimeout)
+		}
+		// The rest of the time is for closing the producers, it takes a while with undelivered messages
+		s.deadline = deadline.Add(-time.Until(deadline) / 4)
+		close(s.stopProducers)
+		if err := waitGroup(ctx, &s.producers); err != nil {
+			s.closeErr = fmt.Errorf("producers not closed: %w", err)
+		}
+		close(s.stopConsumers)
+		if err := waitGroup(ctx, &s.consumers); err != nil && s.closeErr == nil {
+			s.closeErr = fmt.Errorf("consumers not closed: %w", err)
+		}
+
+		s.adminMu.Lock()
+		if s.admin != nil {
+			s.admin.Close()
+		}
+		s.adminMu.Unlock()
+	})
+	return s.closeErr
+}
+
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
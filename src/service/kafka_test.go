@@ -0,0 +1,33 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestKafkaClose(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Kafka.Host = "localhost:1" // nothing listens, so nothing is delivered
+	k := NewKafka(cfg, zap.NewNop())
+
+	// every producer and consumer is stopped, not just one of them
+	for _, topic := range []string{"users", "groups"} {
+		ch := make(chan Message, 1)
+		require.NoError(t, k.GetKeyedProducer(topic, 0, ch))
+		ch <- Message{Value: []byte("{}")}
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, k.Close(ctx), "undelivered messages don't fail the shutdown")
+	require.Less(t, time.Since(start), 2*time.Second, "the flush is bounded by the deadline")
+
+	require.NoError(t, k.Close(context.Background()), "Close is done once")
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Lifecycle tells whether the process is shutting down, it isn't ready for new requests then.
+// It also tracks the work the requests leave running in the background, the shutdown waits for it.
+type Lifecycle struct {
+	stopping   int32
+	background sync.WaitGroup
+}
+
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{}
+}
+
+func (s *Lifecycle) Stop() {
+	atomic.StoreInt32(&s.stopping, 1)
+}
+
+func (s *Lifecycle) Stopping() bool {
+	return atomic.LoadInt32(&s.stopping) == 1
+}
+
+// Go runs f in the background, it's called by the requests before the API is shut down.
+func (s *Lifecycle) Go(f func()) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		f()
+	}()
+}
+
+// Wait waits for the background work until ctx is done.
+func (s *Lifecycle) Wait(ctx context.Context) error {
+	return waitGroup(ctx, &s.background)
+}
@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// File drops every mail into the directory as .eml file, which is handy for tests.
+type File struct {
+	dir  string
+	from string
+}
+
+func NewFile(cfg *domain.Config) (*File, error) {
+	if cfg.Mail.Dir == "" {
+		return nil, fmt.Errorf("mail directory required")
+	}
+	if err := os.MkdirAll(cfg.Mail.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &File{cfg.Mail.Dir, cfg.Mail.From}, nil
+}
+
+func (s *File) Send(m domain.Mail) error {
+	msg, err := message(s.from, m)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("/", "_", "@", "_at_").Replace(m.To))
+	tmp := filepath.Join(s.dir, "."+name)
+	if err = os.WriteFile(tmp, msg, 0o600); err != nil {
+		return err
+	}
+	// Readers never see half-written files
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
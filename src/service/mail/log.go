@@ -0,0 +1,21 @@
+package mail
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// Log doesn't send anything, it only logs mails for development.
+type Log struct {
+	logger domain.Logger
+}
+
+func NewLog(logger domain.Logger) *Log {
+	return &Log{logger}
+}
+
+func (s *Log) Send(m domain.Mail) error {
+	s.logger.Info("mail", zap.String("to", m.To), zap.String("subject", m.Subject), zap.String("body", m.Body))
+	return nil
+}
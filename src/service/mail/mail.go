@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// New returns the sender chosen by the config, the log driver is the default.
+func New(cfg *domain.Config, logger domain.Logger) (domain.MailSender, error) {
+	switch cfg.Mail.Driver {
+	case "smtp":
+		return NewSMTP(cfg)
+	case "file":
+		return NewFile(cfg)
+	case "log", "":
+		return NewLog(logger), nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+}
+
+// message renders the mail as RFC 5322 message.
+func message(from string, m domain.Mail) ([]byte, error) {
+	if _, err := mail.ParseAddress(m.To); err != nil {
+		return nil, fmt.Errorf("bad recipient: %w", err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(m.Body)
+	b.WriteString("\r\n")
+	return b.Bytes(), nil
+}
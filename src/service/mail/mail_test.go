@@ -0,0 +1,129 @@
+package mail
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestFile(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Mail.Driver = "file"
+	cfg.Mail.From = "gonah <noreply@gonah.local>"
+	cfg.Mail.Dir = t.TempDir()
+	sender, err := New(cfg, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, sender.Send(domain.Mail{To: "alice@example.com", Subject: "Hi", Body: "token=abc"}))
+	require.Error(t, sender.Send(domain.Mail{To: "not an address", Subject: "Hi"}))
+
+	files, err := filepath.Glob(filepath.Join(cfg.Mail.Dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	msg, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(msg), "To: alice@example.com\r\n")
+	require.Contains(t, string(msg), "token=abc")
+}
+
+// TestSMTP talks to a minimal SMTP stand-in, which records the message.
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					received <- data.String()
+					reply("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	cfg := &domain.Config{}
+	cfg.Mail.Driver = "smtp"
+	cfg.Mail.From = "gonah <noreply@gonah.local>"
+	cfg.Mail.SMTP.Host = addr.IP.String()
+	cfg.Mail.SMTP.Port = addr.Port
+	sender, err := New(cfg, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, sender.Send(domain.Mail{To: "bob@example.com", Subject: "Hi", Body: "token=xyz"}))
+	msg := <-received
+	require.Contains(t, msg, "To: bob@example.com\r\n")
+	require.Contains(t, msg, "token=xyz")
+}
+
+// TestSMTPTimeout gives up on a server which never greets.
+func TestSMTPTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	cfg := &domain.Config{}
+	cfg.Mail.Driver = "smtp"
+	cfg.Mail.From = "gonah <noreply@gonah.local>"
+	cfg.Mail.SMTP.Host = addr.IP.String()
+	cfg.Mail.SMTP.Port = addr.Port
+	cfg.Mail.SMTP.Timeout = 100 * time.Millisecond
+	sender, err := New(cfg, nil)
+	require.NoError(t, err)
+
+	err = sender.Send(domain.Mail{To: "bob@example.com", Subject: "Hi"})
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	require.True(t, netErr.Timeout())
+}
@@ -0,0 +1,105 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// smtpTimeout is the default of the whole exchange with the server.
+const smtpTimeout = 30 * time.Second
+
+type SMTP struct {
+	addr     string
+	host     string
+	from     string
+	envelope string
+	auth     smtp.Auth
+	startTLS bool
+	timeout  time.Duration
+}
+
+func NewSMTP(cfg *domain.Config) (*SMTP, error) {
+	c := cfg.Mail.SMTP
+	if c.Host == "" {
+		return nil, errors.New("SMTP host required")
+	}
+	from, err := mail.ParseAddress(cfg.Mail.From)
+	if err != nil {
+		return nil, fmt.Errorf("bad sender address: %w", err)
+	}
+
+	s := &SMTP{
+		addr:     net.JoinHostPort(c.Host, strconv.Itoa(c.Port)),
+		host:     c.Host,
+		from:     cfg.Mail.From,
+		envelope: from.Address,
+		startTLS: c.StartTLS,
+		timeout:  c.Timeout,
+	}
+	if s.timeout == 0 {
+		s.timeout = smtpTimeout
+	}
+	if c.Username != "" {
+		s.auth = smtp.PlainAuth("", c.Username, c.Password, c.Host)
+	}
+	return s, nil
+}
+
+func (s *SMTP) Send(m domain.Mail) error {
+	msg, err := message(s.from, m)
+	if err != nil {
+		return err
+	}
+	to, _ := mail.ParseAddress(m.To)
+
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return err
+	}
+	if err = conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.startTLS {
+		if err = client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if err = client.Auth(s.auth); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(s.envelope); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -99,6 +99,26 @@ func Run(dir string, conn domain.DB, logger domain.Logger) {
 	logger.Info("Migration done. Current schema version", zap.Int32("ver", ver))
 }
 
+// Check returns the check failing until the schema is migrated to the latest migration in dir.
+// The migrations are counted once, they don't change while the process runs.
+func Check(dir string, conn domain.DB) func(ctx context.Context) error {
+	paths, err := FindMigrationsEx(dir, defaultMigratorFS{})
+	latest := int32(len(paths))
+	return func(ctx context.Context) error {
+		if err != nil {
+			return err
+		}
+		var current int32
+		if err := conn.QueryRow(ctx, "select version from schema_version").Scan(&current); err != nil {
+			return err
+		}
+		if current < latest {
+			return fmt.Errorf("schema version %d, want %d", current, latest)
+		}
+		return nil
+	}
+}
+
 func newMigrator(conn domain.DB, versionTable string) (m *Migrator, err error) {
 	return newMigratorEx(conn, versionTable, &MigratorOptions{MigratorFS: defaultMigratorFS{}})
 }
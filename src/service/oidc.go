@@ -0,0 +1,307 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+// jwksRefreshInterval limits refetching the provider keys when a token is signed with an unknown one.
+const jwksRefreshInterval = time.Minute
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+type idTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// OIDC is the client side of the OpenID Connect authorization code flow with PKCE.
+// The provider metadata is discovered on the first login, its keys are
+// refetched whenever an ID token is signed with a key not seen before.
+type OIDC struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectURL  string
+	scopes       []string
+	client       *http.Client
+
+	mu          sync.Mutex
+	discovery   *domain.OIDCDiscovery
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewOIDC(cfg *domain.Config) *OIDC {
+	oc := cfg.Auth.OIDC
+	s := &OIDC{
+		issuer:       strings.TrimSuffix(oc.Issuer, "/"),
+		clientId:     oc.ClientId,
+		clientSecret: oc.ClientSecret,
+		redirectURL:  oc.RedirectURL,
+		scopes:       oc.Scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		keys:         make(map[string]crypto.PublicKey),
+	}
+	if len(s.scopes) == 0 {
+		s.scopes = []string{"openid", "email"}
+	}
+	return s
+}
+
+func (s *OIDC) Enabled() bool {
+	return s.issuer != ""
+}
+
+// AuthURL returns the provider page the user is redirected to.
+// The code verifier is kept until the callback, only its hash goes to the provider.
+func (s *OIDC) AuthURL(state, nonce, codeVerifier string) (string, error) {
+	d, err := s.discover()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("bad authorization endpoint: %w", err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", s.clientId)
+	q.Set("redirect_uri", s.redirectURL)
+	q.Set("scope", strings.Join(s.scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", CodeChallenge(codeVerifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades the authorization code for the ID token and validates it.
+func (s *OIDC) Exchange(code, codeVerifier, nonce string) (*domain.OIDCClaims, error) {
+	d, err := s.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.redirectURL)
+	form.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientId), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	tokens := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	if err = json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("bad token response: %w", err)
+	}
+	if tokens.IdToken == "" {
+		return nil, fmt.Errorf("%w: no ID token in the response", ErrInvalidIDToken)
+	}
+	return s.VerifyIDToken(tokens.IdToken, nonce)
+}
+
+// VerifyIDToken checks the signature, issuer, audience, expiration and nonce of the token.
+func (s *OIDC) VerifyIDToken(raw, nonce string) (*domain.OIDCClaims, error) {
+	d, err := s.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &idTokenClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(s.clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	_, err = parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return s.key(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return &domain.OIDCClaims{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// CodeChallenge is what AuthURL sends for the verifier, the provider compares them on exchange.
+func CodeChallenge(codeVerifier string) string {
+	h := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func (s *OIDC) discover() (*domain.OIDCDiscovery, error) {
+	if !s.Enabled() {
+		return nil, domain.ErrOIDCDisabled
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.discovery != nil {
+		return s.discovery, nil
+	}
+
+	d := &domain.OIDCDiscovery{}
+	if err := s.getJSON(s.issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	// The issuer must be the one we trust, otherwise its tokens won't pass the validation anyway
+	if strings.TrimSuffix(d.Issuer, "/") != s.issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", d.Issuer, s.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document misses endpoints")
+	}
+	s.discovery = d
+	return d, nil
+}
+
+func (s *OIDC) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(s.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	set := domain.JWKS{}
+	if err := s.getJSON(s.discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("cannot fetch provider keys: %w", err)
+	}
+	s.keysFetched = time.Now()
+	s.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		// keys of unsupported types are skipped, tokens signed with them fail as unknown
+		if key, err := parseJWK(jwk); err == nil {
+			s.keys[jwk.Kid] = key
+		}
+	}
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (s *OIDC) getJSON(url string, v interface{}) error {
+	resp, err := s.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+func parseJWK(jwk domain.JWK) (crypto.PublicKey, error) {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+	"github.com/Kale-Grabovski/gonah/src/service/oidctest"
+)
+
+func TestOIDC(t *testing.T) {
+	idp, err := oidctest.NewServer("gonah", "client secret")
+	require.NoError(t, err)
+	defer idp.Close()
+	identity := oidctest.Identity{Subject: "42", Email: "alice@example.com", EmailVerified: true}
+	idp.SetIdentity(identity)
+
+	cfg := &domain.Config{}
+	cfg.Auth.OIDC.Issuer = idp.Issuer()
+	cfg.Auth.OIDC.ClientId = "gonah"
+	cfg.Auth.OIDC.ClientSecret = "client secret"
+	cfg.Auth.OIDC.RedirectURL = "http://localhost:8877/api/v1/auth/oidc/callback"
+	s := NewOIDC(cfg)
+	require.True(t, s.Enabled())
+
+	// authorize plays the browser following the redirect to the provider
+	authorize := func(verifier string) string {
+		authURL, err := s.AuthURL("state", "nonce", verifier)
+		require.NoError(t, err)
+		client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(authURL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		require.NoError(t, err)
+		require.Equal(t, "state", callback.Query().Get("state"))
+		return callback.Query().Get("code")
+	}
+
+	claims, err := s.Exchange(authorize("verifier"), "verifier", "nonce")
+	require.NoError(t, err)
+	require.Equal(t, identity.Subject, claims.Subject)
+	require.Equal(t, identity.Email, claims.Email)
+	require.True(t, claims.EmailVerified)
+	require.Equal(t, idp.Issuer(), claims.Issuer)
+
+	_, err = s.Exchange(authorize("verifier"), "other verifier", "nonce")
+	require.Error(t, err, "PKCE verifier mismatch")
+	_, err = s.Exchange(authorize("verifier"), "verifier", "other nonce")
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	expired, err := idp.IDToken(identity, "gonah", "nonce", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = s.VerifyIDToken(expired, "nonce")
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	alien, err := idp.IDToken(identity, "other client", "nonce", time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	_, err = s.VerifyIDToken(alien, "nonce")
+	require.ErrorIs(t, err, ErrInvalidIDToken)
+
+	_, err = NewOIDC(&domain.Config{}).AuthURL("state", "nonce", "verifier")
+	require.ErrorIs(t, err, domain.ErrOIDCDisabled)
+}
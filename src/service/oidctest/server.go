@@ -0,0 +1,209 @@
+// Package oidctest is a minimal OpenID Connect provider for tests. Its authorization
+// endpoint doesn't ask anything and immediately logs in the identity set with SetIdentity.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const keyId = "oidctest"
+
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type grant struct {
+	identity    Identity
+	redirectURI string
+	challenge   string
+	nonce       string
+}
+
+type Server struct {
+	ClientId     string
+	ClientSecret string
+
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	mu       sync.Mutex
+	identity Identity
+	codes    map[string]grant
+}
+
+// NewServer starts the provider on a random port.
+func NewServer(clientId, clientSecret string) (*Server, error) {
+	return NewServerOn("127.0.0.1:0", clientId, clientSecret)
+}
+
+// NewServerOn starts the provider on the address, which is handy when the issuer goes to a config.
+func NewServerOn(addr, clientId, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{ClientId: clientId, ClientSecret: clientSecret, key: key, codes: make(map[string]grant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	s.srv = &httptest.Server{Listener: l, Config: &http.Server{Handler: mux}}
+	s.srv.Start()
+	return s, nil
+}
+
+func (s *Server) Issuer() string {
+	return s.srv.URL
+}
+
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// SetIdentity sets who logs in on the next authorization requests.
+func (s *Server) SetIdentity(id Identity) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.identity = id
+}
+
+// IDToken signs an ID token for the identity, tests use it to check the validation.
+func (s *Server) IDToken(id Identity, audience, nonce string, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"iss":            s.Issuer(),
+		"sub":            id.Subject,
+		"aud":            audience,
+		"iat":            time.Now().Unix(),
+		"exp":            expiresAt.Unix(),
+		"nonce":          nonce,
+		"email":          id.Email,
+		"email_verified": id.EmailVerified,
+		"name":           id.Name,
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	t.Header["kid"] = keyId
+	return t.SignedString(s.key)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, domain.OIDCDiscovery{
+		Issuer:                s.Issuer(),
+		AuthorizationEndpoint: s.Issuer() + "/authorize",
+		TokenEndpoint:         s.Issuer() + "/token",
+		JWKSURI:               s.Issuer() + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, domain.JWKS{Keys: []domain.JWK{{
+		Kty: "RSA",
+		Kid: keyId,
+		Use: "sig",
+		Alg: "RS256",
+		N:   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+	}}})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != s.ClientId || q.Get("response_type") != "code" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = grant{
+		identity:    s.identity,
+		redirectURI: redirect.String(),
+		challenge:   q.Get("code_challenge"),
+		nonce:       q.Get("nonce"),
+	}
+	s.mu.Unlock()
+
+	rq := redirect.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirect.RawQuery = rq.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	// the credentials are form-encoded before basic auth, RFC 6749 section 2.3.1
+	id, secret, ok := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if !ok || id != s.ClientId || secret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	s.mu.Lock()
+	g, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := s.IDToken(g.identity, s.ClientId, g.nonce, time.Now().Add(time.Minute))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const (
+	AlgArgon2id = "argon2id"
+	AlgBcrypt   = "bcrypt"
+
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+	bcryptMaxLen  = 72
+)
+
+var (
+	ErrWeakPassword    = errors.New("weak password")
+	ErrBadPasswordHash = errors.New("malformed password hash")
+)
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+// Password hashes and verifies user passwords. The algorithm and its
+// parameters are encoded into every hash, so hashes made with old settings
+// keep working and are reported for rehashing on the next successful login.
+type Password struct {
+	alg       string
+	cost      int
+	argon     argon2Params
+	minLength int
+	maxLength int
+	dummy     string
+}
+
+func NewPassword(cfg *domain.Config) (*Password, error) {
+	pc := cfg.Auth.Password
+	p := &Password{
+		alg:       pc.Algorithm,
+		cost:      pc.BcryptCost,
+		minLength: pc.MinLength,
+		maxLength: pc.MaxLength,
+		argon: argon2Params{
+			memory:      pc.Argon2.Memory,
+			iterations:  pc.Argon2.Iterations,
+			parallelism: pc.Argon2.Parallelism,
+		},
+	}
+	if p.alg == "" {
+		p.alg = AlgArgon2id
+	}
+	if p.alg != AlgArgon2id && p.alg != AlgBcrypt {
+		return nil, fmt.Errorf("unknown password algorithm %q", p.alg)
+	}
+	if p.cost == 0 {
+		p.cost = bcrypt.DefaultCost
+	}
+	if p.cost < bcrypt.MinCost || p.cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	if p.argon.memory == 0 {
+		p.argon.memory = 64 * 1024
+	}
+	if p.argon.iterations == 0 {
+		p.argon.iterations = 3
+	}
+	if p.argon.parallelism == 0 {
+		p.argon.parallelism = 2
+	}
+	if p.minLength == 0 {
+		p.minLength = 8
+	}
+	if p.maxLength == 0 {
+		p.maxLength = 128
+	}
+	if p.alg == AlgBcrypt && p.maxLength > bcryptMaxLen {
+		// bcrypt silently ignores everything after 72 bytes
+		p.maxLength = bcryptMaxLen
+	}
+
+	// The dummy hash is verified for unknown logins, so they take as long as known ones
+	var err error
+	p.dummy, err = p.Hash("dummy password for unknown logins")
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate checks the password against the configured policy.
+func (s *Password) Validate(password, login string) error {
+	if len([]rune(password)) < s.minLength {
+		return fmt.Errorf("%w: must be at least %d characters long", ErrWeakPassword, s.minLength)
+	}
+	if len(password) > s.maxLength {
+		return fmt.Errorf("%w: must be at most %d bytes long", ErrWeakPassword, s.maxLength)
+	}
+
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return fmt.Errorf("%w: must contain both letters and digits", ErrWeakPassword)
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		return fmt.Errorf("%w: must differ from the login", ErrWeakPassword)
+	}
+	return nil
+}
+
+func (s *Password) Hash(password string) (string, error) {
+	if s.alg == AlgBcrypt {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+		return string(h), err
+	}
+
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, s.argon.iterations, s.argon.memory, s.argon.parallelism, argon2KeyLen)
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		s.argon.memory,
+		s.argon.iterations,
+		s.argon.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify compares the password with the encoded hash. rehash is true when
+// the hash was made with an algorithm or parameters other than the current ones.
+func (s *Password) Verify(password, encoded string) (ok, rehash bool, err error) {
+	if encoded == "" {
+		s.VerifyDummy(password)
+		return false, false, nil
+	}
+
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		params, salt, key, err := decodeArgon2(encoded)
+		if err != nil {
+			return false, false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		ok = subtle.ConstantTimeCompare(key, other) == 1
+		rehash = s.alg != AlgArgon2id || params != s.argon
+		return ok, ok && rehash, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	} else if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrBadPasswordHash, err)
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, fmt.Errorf("%w: %v", ErrBadPasswordHash, err)
+	}
+	return true, s.alg != AlgBcrypt || cost != s.cost, nil
+}
+
+// VerifyDummy burns the same amount of time as a real verification.
+func (s *Password) VerifyDummy(password string) {
+	_, _, _ = s.Verify(password, s.dummy)
+}
+
+func decodeArgon2(encoded string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrBadPasswordHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrBadPasswordHash
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	if err != nil {
+		return p, nil, nil, ErrBadPasswordHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, ErrBadPasswordHash
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, ErrBadPasswordHash
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func passwordConfig(alg string) *domain.Config {
+	cfg := &domain.Config{}
+	cfg.Auth.Password.Algorithm = alg
+	cfg.Auth.Password.BcryptCost = 4
+	cfg.Auth.Password.Argon2.Memory = 1024
+	cfg.Auth.Password.Argon2.Iterations = 1
+	cfg.Auth.Password.Argon2.Parallelism = 1
+	return cfg
+}
+
+func TestPasswordHashVerify(t *testing.T) {
+	for _, alg := range []string{AlgArgon2id, AlgBcrypt} {
+		p, err := NewPassword(passwordConfig(alg))
+		require.NoError(t, err)
+
+		hash, err := p.Hash("secret123")
+		require.NoError(t, err)
+
+		ok, rehash, err := p.Verify("secret123", hash)
+		require.NoError(t, err)
+		require.True(t, ok, alg)
+		require.False(t, rehash, alg)
+
+		ok, _, err = p.Verify("secret124", hash)
+		require.NoError(t, err)
+		require.False(t, ok, alg)
+	}
+}
+
+func TestPasswordRehash(t *testing.T) {
+	old, err := NewPassword(passwordConfig(AlgBcrypt))
+	require.NoError(t, err)
+	hash, err := old.Hash("secret123")
+	require.NoError(t, err)
+
+	cur, err := NewPassword(passwordConfig(AlgArgon2id))
+	require.NoError(t, err)
+	ok, rehash, err := cur.Verify("secret123", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, rehash)
+
+	cfg := passwordConfig(AlgArgon2id)
+	cfg.Auth.Password.Argon2.Iterations = 2
+	stronger, err := NewPassword(cfg)
+	require.NoError(t, err)
+	hash, err = cur.Hash("secret123")
+	require.NoError(t, err)
+	ok, rehash, err = stronger.Verify("secret123", hash)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.True(t, rehash)
+
+	_, _, err = cur.Verify("secret123", "$argon2id$garbage")
+	require.ErrorIs(t, err, ErrBadPasswordHash)
+}
+
+func TestPasswordValidate(t *testing.T) {
+	p, err := NewPassword(passwordConfig(AlgBcrypt))
+	require.NoError(t, err)
+
+	require.NoError(t, p.Validate("secret123", "alice"))
+	require.ErrorIs(t, p.Validate("sec1", "alice"), ErrWeakPassword)
+	require.ErrorIs(t, p.Validate("secretsecret", "alice"), ErrWeakPassword)
+	require.ErrorIs(t, p.Validate("alice12345", "Alice12345"), ErrWeakPassword)
+	require.ErrorIs(t, p.Validate(string(make([]byte, 80))+"a1", "alice"), ErrWeakPassword)
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const rateLimitSweepInterval = time.Minute
+
+// MemoryRateLimits keeps the token buckets in the process, every replica limits the requests it gets.
+type MemoryRateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	fullAt time.Time // the bucket can be forgotten after it
+}
+
+func NewMemoryRateLimits() *MemoryRateLimits {
+	return &MemoryRateLimits{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (s *MemoryRateLimits) Take(key string, rate float64, burst int) (domain.RateLimit, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	tokens := float64(burst)
+	b, ok := s.buckets[key]
+	if ok {
+		tokens = math.Min(tokens, b.tokens+now.Sub(b.at).Seconds()*rate)
+	} else {
+		b = &bucket{}
+		s.buckets[key] = b
+	}
+
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+	b.tokens, b.at = tokens, now
+	b.fullAt = now.Add(time.Duration((float64(burst) - tokens) / rate * float64(time.Second)))
+	return domain.RateLimit{Allowed: allowed, Tokens: tokens}, nil
+}
+
+// sweep forgets the full buckets, they are the same as the missing ones.
+func (s *MemoryRateLimits) sweep(now time.Time) {
+	if now.Sub(s.sweptAt) < rateLimitSweepInterval {
+		return
+	}
+	s.sweptAt = now
+	for key, b := range s.buckets {
+		if !b.fullAt.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryRateLimits(t *testing.T) {
+	limits := NewMemoryRateLimits()
+	now := time.Now()
+	limits.now = func() time.Time { return now }
+
+	// 2 requests per second, 3 at once
+	for i := 0; i < 3; i++ {
+		l, err := limits.Take("ip:10.0.0.1", 2, 3)
+		require.NoError(t, err)
+		require.True(t, l.Allowed)
+	}
+	l, _ := limits.Take("ip:10.0.0.1", 2, 3)
+	require.False(t, l.Allowed)
+	require.Zero(t, l.Tokens)
+
+	l, _ = limits.Take("ip:10.0.0.2", 2, 3)
+	require.True(t, l.Allowed, "the buckets are per key")
+
+	now = now.Add(750 * time.Millisecond)
+	l, _ = limits.Take("ip:10.0.0.1", 2, 3)
+	require.True(t, l.Allowed)
+	require.InDelta(t, 0.5, l.Tokens, 1e-9)
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	_, _ = limits.Take("ip:10.0.0.3", 2, 3)
+	require.Len(t, limits.buckets, 1)
+}
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const ephemeralKeyId = "ephemeral"
+
+var ErrInvalidToken = errors.New("invalid token")
+
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+type accessClaims struct {
+	Login     string `json:"login"`
+	SessionId int    `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Token issues and parses signed access tokens and generates opaque refresh tokens.
+// Every configured key is accepted for verification and published in JWKS,
+// only the active one signs new tokens, so keys can be rotated without logging anybody out.
+type Token struct {
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	active     *signingKey
+	keys       map[string]*signingKey
+	parser     *jwt.Parser
+}
+
+func NewToken(cfg *domain.Config, logger domain.Logger) (*Token, error) {
+	jc := cfg.Auth.JWT
+	s := &Token{
+		issuer:     jc.Issuer,
+		audience:   jc.Audience,
+		accessTTL:  jc.AccessTTL,
+		refreshTTL: jc.RefreshTTL,
+		keys:       make(map[string]*signingKey),
+	}
+	if s.accessTTL == 0 {
+		s.accessTTL = 15 * time.Minute
+	}
+	if s.refreshTTL == 0 {
+		s.refreshTTL = 30 * 24 * time.Hour
+	}
+
+	for _, kc := range jc.Keys {
+		key, err := loadSigningKey(kc.Id, kc.Alg, kc.File, kc.PEM)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load signing key %q: %w", kc.Id, err)
+		}
+		s.keys[key.id] = key
+	}
+
+	if len(s.keys) == 0 {
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("no JWT signing keys configured, using an ephemeral key: tokens won't survive restarts and won't be accepted by other replicas")
+		s.keys[ephemeralKeyId] = &signingKey{id: ephemeralKeyId, method: jwt.SigningMethodEdDSA, private: private}
+		s.active = s.keys[ephemeralKeyId]
+	} else if s.active = s.keys[jc.ActiveKey]; s.active == nil {
+		return nil, fmt.Errorf("active signing key %q is not configured", jc.ActiveKey)
+	}
+
+	var methods []string
+	for _, k := range s.keys {
+		methods = append(methods, k.method.Alg())
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired(), jwt.WithIssuedAt()}
+	if s.issuer != "" {
+		opts = append(opts, jwt.WithIssuer(s.issuer))
+	}
+	if s.audience != "" {
+		opts = append(opts, jwt.WithAudience(s.audience))
+	}
+	s.parser = jwt.NewParser(opts...)
+
+	logger.Info("JWT keys loaded", zap.String("activeKid", s.active.id), zap.Int("keys", len(s.keys)))
+	return s, nil
+}
+
+func (s *Token) RefreshTTL() time.Duration {
+	return s.refreshTTL
+}
+
+// IssueAccess returns a signed access token for the principal.
+func (s *Token) IssueAccess(p *domain.Principal) (token string, expiresIn time.Duration, err error) {
+	now := time.Now()
+	claims := accessClaims{
+		Login:     p.Login,
+		SessionId: p.SessionId,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.issuer,
+			Subject:   strconv.Itoa(p.UserId),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.accessTTL)),
+		},
+	}
+	if s.audience != "" {
+		claims.Audience = jwt.ClaimStrings{s.audience}
+	}
+
+	t := jwt.NewWithClaims(s.active.method, claims)
+	t.Header["kid"] = s.active.id
+	token, err = t.SignedString(s.active.private)
+	return token, s.accessTTL, err
+}
+
+// ParseAccess validates the access token and returns the principal it was issued for.
+func (s *Token) ParseAccess(token string) (*domain.Principal, error) {
+	claims := &accessClaims{}
+	_, err := s.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := s.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("key %q doesn't match algorithm %s", kid, t.Method.Alg())
+		}
+		return key.private.Public(), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userId, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad subject", ErrInvalidToken)
+	}
+	return &domain.Principal{UserId: userId, Login: claims.Login, SessionId: claims.SessionId}, nil
+}
+
+// NewRefresh returns a random opaque refresh token and the hash to store instead of it.
+func (s *Token) NewRefresh() (token, hash string, err error) {
+	return NewSecret()
+}
+
+// NewSecret returns a random URL-safe secret and its hash to store.
+func NewSecret() (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(b)
+	return secret, HashToken(secret), nil
+}
+
+// HashToken hashes high-entropy secrets like refresh tokens, which don't need a slow hash.
+func HashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// JWKS returns the public parts of all keys as a JSON Web Key Set.
+func (s *Token) JWKS() domain.JWKS {
+	set := domain.JWKS{Keys: []domain.JWK{}}
+	for _, k := range s.keys {
+		jwk := domain.JWK{Kid: k.id, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func loadSigningKey(id, alg, file, pemData string) (*signingKey, error) {
+	if id == "" {
+		return nil, errors.New("key id required")
+	}
+	data := []byte(pemData)
+	if file != "" {
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var (
+		private interface{}
+		err     error
+	)
+	if block.Type == "RSA PRIVATE KEY" {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: id}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.private = k
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		key.private = k
+		key.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	if alg != "" && alg != key.method.Alg() {
+		return nil, fmt.Errorf("algorithm %s doesn't match the key, expected %s", alg, key.method.Alg())
+	}
+	return key, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func tokenConfig(t *testing.T, active string) *domain.Config {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDer, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+
+	cfg := &domain.Config{}
+	cfg.Auth.JWT.Issuer = "gonah"
+	cfg.Auth.JWT.Audience = "gonah-api"
+	cfg.Auth.JWT.ActiveKey = active
+	cfg.Auth.JWT.Keys = []domain.JWTKey{
+		{
+			Id:  "rsa",
+			PEM: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})),
+		},
+		{
+			Id:  "ed",
+			Alg: "EdDSA",
+			PEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDer})),
+		},
+	}
+	return cfg
+}
+
+func TestTokenRotation(t *testing.T) {
+	cfg := tokenConfig(t, "rsa")
+	old, err := NewToken(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	token, _, err := old.IssueAccess(&domain.Principal{UserId: 42, Login: "alice", SessionId: 7})
+	require.NoError(t, err)
+
+	// the key is rotated, but tokens signed with the previous one are still accepted
+	cfg.Auth.JWT.ActiveKey = "ed"
+	cur, err := NewToken(cfg, zap.NewNop())
+	require.NoError(t, err)
+	p, err := cur.ParseAccess(token)
+	require.NoError(t, err)
+	require.Equal(t, 42, p.UserId)
+	require.Equal(t, "alice", p.Login)
+	require.Equal(t, 7, p.SessionId)
+
+	token, _, err = cur.IssueAccess(&domain.Principal{UserId: 43, Login: "bob"})
+	require.NoError(t, err)
+	p, err = old.ParseAccess(token)
+	require.NoError(t, err)
+	require.Equal(t, 43, p.UserId)
+
+	jwks := cur.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	for _, k := range jwks.Keys {
+		switch k.Kid {
+		case "rsa":
+			require.Equal(t, "RS256", k.Alg)
+			require.NotEmpty(t, k.N)
+		case "ed":
+			require.Equal(t, "EdDSA", k.Alg)
+			require.NotEmpty(t, k.X)
+		}
+	}
+}
+
+func TestTokenInvalid(t *testing.T) {
+	s, err := NewToken(&domain.Config{}, zap.NewNop())
+	require.NoError(t, err)
+	other, err := NewToken(tokenConfig(t, "ed"), zap.NewNop())
+	require.NoError(t, err)
+
+	token, _, err := other.IssueAccess(&domain.Principal{UserId: 1})
+	require.NoError(t, err)
+	_, err = s.ParseAccess(token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = s.ParseAccess("garbage")
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	cfg := tokenConfig(t, "missing")
+	_, err = NewToken(cfg, zap.NewNop())
+	require.Error(t, err)
+
+	cfg = tokenConfig(t, "rsa")
+	cfg.Auth.JWT.Keys[0].Alg = "EdDSA"
+	_, err = NewToken(cfg, zap.NewNop())
+	require.Error(t, err)
+}
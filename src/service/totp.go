@@ -0,0 +1,184 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+const (
+	totpPeriod     = 30 // seconds
+	totpDigits     = 6
+	totpSkew       = 1 // steps accepted before and after the current one for clock drift
+	totpSecretLen  = 20
+	recoveryCodes  = 10
+	recoveryLength = 10
+)
+
+var (
+	ErrBadTotpSecret = errors.New("cannot decrypt TOTP secret")
+
+	base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// Totp generates and checks RFC 6238 codes (SHA-1, 6 digits, 30 seconds),
+// which is what every authenticator app supports. Secrets are encrypted
+// with AES-GCM before they get to the database.
+type Totp struct {
+	issuer string
+	aead   cipher.AEAD
+}
+
+func NewTotp(cfg *domain.Config, logger domain.Logger) (*Totp, error) {
+	tc := cfg.Auth.TOTP
+	s := &Totp{issuer: tc.Issuer}
+	if s.issuer == "" {
+		s.issuer = "gonah"
+	}
+
+	var key []byte
+	if tc.EncryptionKey == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		logger.Warn("no TOTP encryption key configured, using an ephemeral key: 2FA enrollments won't survive restarts")
+	} else {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(tc.EncryptionKey); err != nil {
+			return nil, fmt.Errorf("cannot decode TOTP encryption key: %w", err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("TOTP encryption key must be 32 bytes long, got %d", len(key))
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSecret returns a random base32 secret and the otpauth URI to show as a QR code.
+func (s *Totp) NewSecret(account string) (secret, uri string, err error) {
+	b := make([]byte, totpSecretLen)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = base32NoPadding.EncodeToString(b)
+
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", s.issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + s.issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return secret, u.String(), nil
+}
+
+func (s *Totp) Encrypt(secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *Totp) Decrypt(encrypted string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return "", ErrBadTotpSecret
+	}
+	n := s.aead.NonceSize()
+	secret, err := s.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", ErrBadTotpSecret
+	}
+	return string(secret), nil
+}
+
+// Check returns the time step the code belongs to. Codes of steps up to lastStep
+// have been used already and are rejected, so a code can't be replayed.
+func (s *Totp) Check(secret, code string, lastStep int64, now time.Time) (step int64, ok bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step = current - totpSkew; step <= current+totpSkew; step++ {
+		if step > lastStep && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Code returns the code for the time, it's what the authenticator app shows.
+func (s *Totp) Code(secret string, now time.Time) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, now.Unix()/totpPeriod), nil
+}
+
+func totpCode(key []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// NewRecoveryCodes returns one-time codes to show to the user once and their hashes to store.
+func NewRecoveryCodes() (codes, hashes []string, err error) {
+	b := make([]byte, recoveryLength*5/8)
+	for i := 0; i < recoveryCodes; i++ {
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(b))
+		code = code[:recoveryLength/2] + "-" + code[recoveryLength/2:]
+		codes = append(codes, code)
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode ignores the case and dashes, users retype the codes from paper.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return HashToken(code)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Kale-Grabovski/gonah/src/domain"
+)
+
+func TestTotpCheck(t *testing.T) {
+	s, err := NewTotp(&domain.Config{}, zap.NewNop())
+	require.NoError(t, err)
+
+	// RFC 6238 appendix B, the last 6 digits of the SHA-1 vectors
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	for unix, code := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := s.Code(secret, time.Unix(unix, 0))
+		require.NoError(t, err)
+		require.Equal(t, code, got, unix)
+	}
+
+	now := time.Unix(1111111109, 0)
+	step, ok := s.Check(secret, "081804", 0, now)
+	require.True(t, ok)
+	require.Equal(t, now.Unix()/totpPeriod, step)
+
+	// the previous step is accepted for clock drift, but not after a later code was used
+	_, ok = s.Check(secret, "081804", 0, now.Add(totpPeriod*time.Second))
+	require.True(t, ok)
+	_, ok = s.Check(secret, "081804", step, now)
+	require.False(t, ok)
+	_, ok = s.Check(secret, "081804", 0, now.Add(2*totpPeriod*time.Second))
+	require.False(t, ok)
+	_, ok = s.Check(secret, "000000", 0, now)
+	require.False(t, ok)
+}
+
+func TestTotpEncrypt(t *testing.T) {
+	cfg := &domain.Config{}
+	cfg.Auth.TOTP.EncryptionKey = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	s, err := NewTotp(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	secret, uri, err := s.NewSecret("alice")
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/gonah:alice?"))
+	require.Contains(t, uri, "secret="+secret)
+
+	enc, err := s.Encrypt(secret)
+	require.NoError(t, err)
+	require.NotContains(t, enc, secret)
+	dec, err := s.Decrypt(enc)
+	require.NoError(t, err)
+	require.Equal(t, secret, dec)
+
+	other, err := NewTotp(&domain.Config{}, zap.NewNop())
+	require.NoError(t, err)
+	_, err = other.Decrypt(enc)
+	require.ErrorIs(t, err, ErrBadTotpSecret)
+
+	cfg.Auth.TOTP.EncryptionKey = base64.StdEncoding.EncodeToString(make([]byte, 16))
+	_, err = NewTotp(cfg, zap.NewNop())
+	require.Error(t, err)
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, recoveryCodes)
+	require.Len(t, hashes, recoveryCodes)
+	require.Len(t, codes[0], recoveryLength+1)
+	require.Equal(t, hashes[0], HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+	require.NotEqual(t, hashes[0], hashes[1])
+}